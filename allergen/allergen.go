@@ -0,0 +1,192 @@
+// Package allergen derives the major food allergens from ingredient
+// statements along with any "may contain" advisory statements
+package allergen
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prLorence/fdc-api/ingredient"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// MILK etc are the names of the major food allergens
+const (
+	MILK      = "milk"
+	EGG       = "egg"
+	FISH      = "fish"
+	SHELLFISH = "shellfish"
+	TREENUT   = "treenut"
+	PEANUT    = "peanut"
+	WHEAT     = "wheat"
+	SOY       = "soy"
+	SESAME    = "sesame"
+)
+
+// INGREDIENT etc identify where the evidence for an allergen was found
+const (
+	INGREDIENT = "INGREDIENT"
+	CONTAINS   = "CONTAINS"
+	MAYCONTAIN = "MAYCONTAIN"
+)
+
+// Allergen lists the words and phrases which indicate an allergen and
+// phrases containing them which do not
+type Allergen struct {
+	Name     string
+	Keywords []string
+	Except   []string
+}
+
+// Allergens are the major food allergens in the order they are reported
+var Allergens = []Allergen{
+	{MILK, []string{"milk", "cream", "butter", "buttermilk", "cheese", "whey", "casein", "caseinate", "sodium caseinate", "lactose", "lactalbumin", "lactoglobulin", "yogurt", "ghee", "curds", "nonfat dry milk", "milkfat", "half and half"},
+		[]string{"cocoa butter", "shea butter", "peanut butter", "nut butter", "almond butter", "cashew butter", "sunflower butter", "seed butter", "apple butter", "coconut milk", "coconut cream", "almond milk", "oat milk", "soy milk", "rice milk", "cashew milk", "milk thistle", "cream of tartar"}},
+	{EGG, []string{"egg", "egg white", "egg yolk", "albumen", "albumin", "ovalbumin", "lysozyme", "mayonnaise", "meringue"}, nil},
+	{FISH, []string{"fish", "anchovy", "anchovies", "bass", "catfish", "cod", "flounder", "haddock", "hake", "halibut", "herring", "mackerel", "mahi mahi", "perch", "pollock", "salmon", "sardine", "snapper", "sole", "swordfish", "tilapia", "trout", "tuna", "fish sauce", "fish oil"}, nil},
+	{SHELLFISH, []string{"shellfish", "shrimp", "prawn", "crab", "lobster", "crawfish", "crayfish", "langoustine", "clam", "oyster", "mussel", "scallop"}, nil},
+	{TREENUT, []string{"tree nut", "almond", "brazil nut", "cashew", "chestnut", "filbert", "hazelnut", "macadamia", "pecan", "pine nut", "pistachio", "walnut", "praline", "marzipan", "nougat"},
+		[]string{"water chestnut"}},
+	{PEANUT, []string{"peanut", "peanut butter", "peanut flour", "peanut oil", "groundnut", "arachis"}, nil},
+	{WHEAT, []string{"wheat", "flour", "enriched flour", "bleached flour", "semolina", "durum", "spelt", "farina", "bulgur", "couscous", "seitan", "graham", "einkorn", "emmer", "kamut", "triticale", "wheat gluten", "vital wheat gluten"},
+		[]string{"buckwheat", "rice flour", "corn flour", "oat flour", "potato flour", "almond flour", "coconut flour", "tapioca flour", "soy flour", "chickpea flour", "peanut flour", "bean flour", "pea flour", "sorghum flour", "quinoa flour", "cassava flour", "rye flour"}},
+	{SOY, []string{"soy", "soya", "soybean", "soybeans", "soy lecithin", "tofu", "edamame", "miso", "tempeh", "tamari", "soy sauce"}, nil},
+	{SESAME, []string{"sesame", "sesame seed", "sesame oil", "tahini", "benne"}, nil},
+}
+
+var (
+	// contains finds allergen declarations, e.g. "contains: milk, soy"
+	contains = regexp.MustCompile(`\b(allergens?\s*:\s*)?contains\b\s*:?`)
+	// advisories find precautionary statements
+	advisories = regexp.MustCompile(`\b(may\s+(also\s+)?contain|(made|manufactured|processed|produced|packaged)\s+(in|on)\b|shared\s+(equipment|facility))`)
+	// qualifier follows "contains" when it introduces minor ingredients
+	qualifier = regexp.MustCompile(`^\s*(less\s+than|\d|one\s+or\s+more|the\s+following)`)
+)
+
+// Lookup returns the allergen name for a name or common alias, e.g. peanuts
+// or "tree nuts"
+func Lookup(name string) (string, bool) {
+	n := strings.Replace(ingredient.Normalize(name), " ", "", -1)
+	n = strings.TrimSuffix(n, "s")
+	for _, a := range Allergens {
+		if n == a.Name {
+			return a.Name, true
+		}
+	}
+	return "", false
+}
+
+// Tag sets the allergens and may contain allergens of a food from its
+// ingredients.  Foods without an ingredient statement are left unchecked.
+func Tag(f *fdc.Food) {
+	if strings.TrimSpace(f.Ingredients) == "" {
+		return
+	}
+	r := Detect(f)
+	f.Allergens = r.Allergens
+	f.MayContain = r.MayContain
+}
+
+// Detect reports the allergens of a food with the ingredient tokens and
+// labeling statements which indicate them.  Allergens which a food contains
+// are not also reported as may contain.
+func Detect(f *fdc.Food) fdc.AllergenReport {
+	r := fdc.AllergenReport{FdcID: f.FdcID, Description: f.Description, Allergens: []string{}, MayContain: []string{}, Evidence: []fdc.AllergenEvidence{}}
+	tokens := f.IngredientTokens
+	if len(tokens) == 0 {
+		tokens = ingredient.Parse(f.Ingredients)
+	}
+	for _, t := range tokens {
+		r.Evidence = append(r.Evidence, match(t, INGREDIENT)...)
+	}
+	s := strings.ToLower(f.Ingredients)
+	for _, st := range statements(s, contains) {
+		if !qualifier.MatchString(st) {
+			r.Evidence = append(r.Evidence, match(st, CONTAINS)...)
+		}
+	}
+	for _, st := range statements(s, advisories) {
+		r.Evidence = append(r.Evidence, match(st, MAYCONTAIN)...)
+	}
+	found := make(map[string]bool)
+	for _, e := range r.Evidence {
+		if e.Source != MAYCONTAIN {
+			found[e.Allergen] = true
+		}
+	}
+	maybe := make(map[string]bool)
+	for _, e := range r.Evidence {
+		if e.Source == MAYCONTAIN && !found[e.Allergen] {
+			maybe[e.Allergen] = true
+		}
+	}
+	for _, a := range Allergens {
+		if found[a.Name] {
+			r.Allergens = append(r.Allergens, a.Name)
+		} else if maybe[a.Name] {
+			r.MayContain = append(r.MayContain, a.Name)
+		}
+	}
+	sort.SliceStable(r.Evidence, func(i, j int) bool {
+		return order(r.Evidence[i].Allergen) < order(r.Evidence[j].Allergen)
+	})
+	return r
+}
+
+// statements returns the text following each match of re up to the end of
+// its sentence
+func statements(s string, re *regexp.Regexp) []string {
+	var st []string
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		rest := s[loc[1]:]
+		if i := strings.Index(rest, ". "); i >= 0 {
+			rest = rest[:i]
+		}
+		st = append(st, strings.TrimSuffix(strings.TrimSpace(rest), "."))
+	}
+	return st
+}
+
+// match returns evidence for each allergen with a keyword in text which is
+// not part of one of the allergen's exceptions
+func match(text string, source string) []fdc.AllergenEvidence {
+	var ev []fdc.AllergenEvidence
+	t := " " + ingredient.Normalize(text) + " "
+	for _, a := range Allergens {
+		s := t
+		for _, x := range a.Except {
+			for _, p := range []string{x, x + "s", x + "es"} {
+				s = strings.Replace(s, " "+p+" ", "  ", -1)
+			}
+		}
+		for _, k := range a.Keywords {
+			if hasWord(s, k) {
+				ev = append(ev, fdc.AllergenEvidence{Allergen: a.Name, Source: source, Text: strings.TrimSpace(t), Keyword: k})
+				break
+			}
+		}
+	}
+	return ev
+}
+
+// hasWord reports whether padded text contains a keyword or its plural as
+// whole words
+func hasWord(text string, keyword string) bool {
+	for _, k := range []string{keyword, keyword + "s", keyword + "es"} {
+		if strings.Contains(text, " "+k+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// order returns the position of an allergen in Allergens
+func order(name string) int {
+	for i, a := range Allergens {
+		if a.Name == name {
+			return i
+		}
+	}
+	return len(Allergens)
+}
@@ -0,0 +1,65 @@
+package allergen
+
+import (
+	"reflect"
+	"testing"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		ingredients string
+		allergens   []string
+		mayContain  []string
+	}{
+		{
+			"ENRICHED FLOUR (WHEAT FLOUR, NIACIN), SUGAR, COCOA BUTTER, NONFAT MILK, SOY LECITHIN. CONTAINS: WHEAT, MILK, SOY. MAY CONTAIN PEANUTS AND TREE NUTS.",
+			[]string{MILK, WHEAT, SOY},
+			[]string{TREENUT, PEANUT},
+		},
+		{
+			"RICE FLOUR, WATER CHESTNUTS, PEANUT BUTTER (PEANUTS, SALT), EGGS, SHRIMP, TAHINI, BUCKWHEAT. MANUFACTURED IN A FACILITY THAT ALSO PROCESSES MILK AND SHRIMP.",
+			[]string{EGG, SHELLFISH, PEANUT, SESAME},
+			[]string{MILK},
+		},
+		{
+			"WATER, SUGAR, CONTAINS 2% OR LESS OF: ANCHOVIES, ALMONDS",
+			[]string{FISH, TREENUT},
+			[]string{},
+		},
+		{"COCONUT MILK, CREAM OF TARTAR, SHEA BUTTER", []string{}, []string{}},
+	}
+	for _, tt := range tests {
+		r := Detect(&fdc.Food{Ingredients: tt.ingredients})
+		if !reflect.DeepEqual(r.Allergens, tt.allergens) || !reflect.DeepEqual(r.MayContain, tt.mayContain) {
+			t.Errorf("Detect(%q)\n got %v may contain %v\nwant %v may contain %v", tt.ingredients, r.Allergens, r.MayContain, tt.allergens, tt.mayContain)
+		}
+	}
+	r := Detect(&fdc.Food{Ingredients: "SUGAR, WHEY (MILK)"})
+	if len(r.Evidence) == 0 || r.Evidence[0].Source != INGREDIENT || r.Evidence[0].Text != "whey" || r.Evidence[0].Keyword != "whey" {
+		t.Errorf("Expecting evidence from the whey ingredient got %v", r.Evidence)
+	}
+}
+
+func TestTag(t *testing.T) {
+	f := fdc.Food{}
+	if Tag(&f); f.Allergens != nil {
+		t.Errorf("Expecting a food without ingredients to be unchecked got %v", f.Allergens)
+	}
+	f.Ingredients = "WATER, SALT"
+	if Tag(&f); f.Allergens == nil || len(f.Allergens) != 0 {
+		t.Errorf("Expecting an empty list of allergens got %v", f.Allergens)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for name, want := range map[string]string{"Peanuts": PEANUT, "tree nuts": TREENUT, "eggs": EGG, "sesame": SESAME, "shellfish": SHELLFISH} {
+		if a, ok := Lookup(name); !ok || a != want {
+			t.Errorf("Expecting %s for %s got %s", want, name, a)
+		}
+	}
+	if _, ok := Lookup("gluten"); ok {
+		t.Error("Expecting gluten not to be an allergen")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
+	"github.com/prLorence/fdc-api/mail"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// passwordChange is the body of a password change or reset
+type passwordChange struct {
+	Current  string `json:"currentPassword"`
+	Password string `json:"newPassword" binding:"required"`
+	Token    string `json:"token"`
+}
+
+// passwordForgot is the body of a password reset request
+type passwordForgot struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// meGet returns the current user
+func meGet(c *gin.Context) {
+	u, ok := auth.FindUser(owner(c), store(c))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "User not found"})
+		return
+	}
+	u.Password = ""
+	c.JSON(http.StatusOK, u)
+}
+
+// meUpdate changes the current user's email address
+func meUpdate(c *gin.Context) {
+	var p struct {
+		Email string `json:"email"`
+	}
+	if err := c.BindJSON(&p); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	u, err := auth.UpdateProfile(audited(c), owner(c), p.Email)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+// mePassword changes the current user's password
+func mePassword(c *gin.Context) {
+	var p passwordChange
+	if err := c.BindJSON(&p); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	if err := auth.ChangePassword(audited(c), owner(c), p.Current, p.Password); err != nil {
+		status := http.StatusBadRequest
+		if err == auth.ErrFailedAuthentication {
+			status = http.StatusForbidden
+			auth.Record(c, auth.PASSWORDFAILED, owner(c), "wrong current password")
+		}
+		errorout(c, status, gin.H{"status": status, "message": err.Error()})
+		return
+	}
+	auth.Record(c, auth.PASSWORDCHANGED, owner(c), "")
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": "Password changed"})
+}
+
+// passwordForgotPost mails a password reset token to a user identified by
+// name or email address.  The token is made and mailed after responding so
+// neither the response nor its timing shows whether the user exists.
+func passwordForgotPost(c *gin.Context) {
+	var p passwordForgot
+	if err := c.BindJSON(&p); err != nil || (p.Username == "" && p.Email == "") {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "a username or email is required"})
+		return
+	}
+	go forgotten(c.Copy(), p)
+	c.JSON(http.StatusAccepted, gin.H{"status": http.StatusAccepted, "message": "If the account has an email address a reset token has been sent to it"})
+}
+
+// forgotten finds the user of a forgotten password request and mails them a
+// reset token.  c must be a copy of the request's context since it outlives
+// the request.
+func forgotten(c *gin.Context, p passwordForgot) {
+	var (
+		dt    fdc.DocType
+		names []interface{}
+	)
+	name := p.Username
+	if name == "" {
+		q := fmt.Sprintf("SELECT RAW name FROM %s WHERE type=\"%s\" AND email=%s LIMIT 1", cs.CouchDb.Bucket, dt.ToString(fdc.USER), fdc.Quote(p.Email))
+		if err := store(c).Query(q, &names); err != nil {
+			logging.From(c).Error("cannot find the user for a password reset", logging.Fields{"error": err})
+		}
+		if len(names) == 1 {
+			name, _ = names[0].(string)
+		}
+	}
+	if name != "" {
+		if err := sendReset(audited(c), name); err != nil {
+			logging.From(c).Error("cannot send a password reset", logging.Fields{"user": name, "error": err})
+		}
+	}
+}
+
+// passwordResetPost sets a new password with a reset token
+func passwordResetPost(c *gin.Context) {
+	var p passwordChange
+	if err := c.BindJSON(&p); err != nil || p.Token == "" {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "a token and newPassword are required"})
+		return
+	}
+	if err := auth.ResetPassword(audited(c), p.Token, p.Password); err != nil {
+		if errors.Is(err, auth.ErrInvalidReset) {
+			auth.Record(c, auth.PASSWORDFAILED, "", err.Error())
+		}
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": "Password changed"})
+}
+
+// sendReset stores a password reset token in d and mails it to the named
+// user
+func sendReset(d ds.DataSource, name string) error {
+	token, u, err := auth.NewPasswordReset(d, name)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(resetMessage(u, token, cs.Mail.ResetURL))
+}
+
+// resetMessage is the mail which sends a reset token to a user
+func resetMessage(u auth.User, token, link string) mail.Message {
+	body := fmt.Sprintf("A password reset was requested for %s.\n\nYour reset token is %s\n", u.Name, token)
+	if link != "" {
+		body += fmt.Sprintf("\nReset your password at %s?token=%s\n", link, url.QueryEscape(token))
+	}
+	body += fmt.Sprintf("\nThe token expires in %v.  If you didn't request a reset you can ignore this message.\n", auth.ResetTTL)
+	return mail.Message{To: u.Email, Subject: "Password reset", Body: body}
+}
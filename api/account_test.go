@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prLorence/fdc-api/auth"
+)
+
+func TestResetMessage(t *testing.T) {
+	u := auth.User{Name: "tester", Email: "tester@example.com"}
+	m := resetMessage(u, "0a1b", "")
+	if m.To != u.Email || !strings.Contains(m.Body, "0a1b") || strings.Contains(m.Body, "?token=") {
+		t.Errorf("got %+v", m)
+	}
+	m = resetMessage(u, "0a1b", "https://fdc.example.com/reset")
+	if !strings.Contains(m.Body, "https://fdc.example.com/reset?token=0a1b") {
+		t.Errorf("got %s", m.Body)
+	}
+}
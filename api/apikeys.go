@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// apiKeyRequest describes a key to issue.  A zero expiresAt issues a key
+// which never expires.
+type apiKeyRequest struct {
+	Name      string    `json:"name" binding:"required"`
+	Contact   string    `json:"contact"`
+	Scopes    []string  `json:"scopes" binding:"required"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// apiKeyAdd issues an API key to a partner application.  The key is
+// returned only in this response.
+func apiKeyAdd(c *gin.Context) {
+	var kr apiKeyRequest
+	if err := c.BindJSON(&kr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	if !kr.ExpiresAt.IsZero() && kr.ExpiresAt.Before(time.Now()) {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "expiresAt must be in the future"})
+		return
+	}
+	k, key, err := auth.NewAPIKey(kr.Name, kr.Scopes, kr.ExpiresAt)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if store(c).FoodExists(k.ID) {
+		errorout(c, http.StatusConflict, gin.H{"status": http.StatusConflict, "message": "Key collision, please try again"})
+		return
+	}
+	k.Contact = kr.Contact
+	if err := audited(c).Update(k.ID, k); err != nil {
+		logging.From(c).Error("cannot save an API key", logging.Fields{"api_key": k.Prefix, "error": err})
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "Cannot save the key"})
+		return
+	}
+	k.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{"key": key, "apiKey": k})
+}
+
+// apiKeysList returns the issued API keys without their hashes
+func apiKeysList(c *gin.Context) {
+	var (
+		dt    fdc.DocType
+		items []interface{}
+	)
+	q := fmt.Sprintf("SELECT RAW OBJECT_REMOVE(k, \"hash\") FROM %s k WHERE k.type=\"%s\" ORDER BY k.name", cs.CouchDb.Bucket, dt.ToString(fdc.APIKEY))
+	if err := store(c).Query(q, &items); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	results := fdc.BrowseResult{Count: int32(len(items)), Start: 0, Max: int32(len(items)), Items: items}
+	c.JSON(http.StatusOK, results)
+}
+
+// apiKeyRevoke revokes the API key with prefix :id.  Revoked keys are kept
+// so the application they identified is still known.
+func apiKeyRevoke(c *gin.Context) {
+	var k auth.APIKey
+	id := auth.APIKeyID(c.Param("id"))
+	if err := store(c).Get(id, &k); err != nil {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "API key not found"})
+		return
+	}
+	k.Revoked = true
+	if err := audited(c).Update(id, k); err != nil {
+		logging.From(c).Error("cannot revoke an API key", logging.Fields{"id": id, "error": err})
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "Cannot revoke the key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": fmt.Sprintf("API key %s revoked", c.Param("id"))})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/audit"
+	"github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// audited returns the datastore for changes made by a request.  Its updates
+// and removals are recorded in the audit trail.
+func audited(c *gin.Context) ds.DataSource {
+	return trail.For(store(c), actor(c))
+}
+
+// auditedAs returns the datastore for changes a request makes on behalf of
+// the user named name when nobody has logged in yet, such as provisioning a
+// user who logs in through the identity provider
+func auditedAs(c *gin.Context, d ds.DataSource, name string) ds.DataSource {
+	a := actor(c)
+	if a.Name == "" {
+		a.Name = name
+	}
+	return trail.For(instrument(c, d), a)
+}
+
+// actor returns who made a request and from where
+func actor(c *gin.Context) audit.Actor {
+	a := audit.Actor{IP: c.ClientIP(), Method: c.Request.Method, Path: c.Request.URL.Path, RequestID: logging.RequestID(c)}
+	if u, ok := auth.CurrentUser(c); ok {
+		a.Name, a.Role = u.Name, u.Role
+	}
+	if k, ok := auth.CurrentAPIKey(c); ok {
+		a.APIKey = k.Prefix
+	}
+	return a
+}
+
+// record adds an entry for a change which isn't made through audited
+func record(c *gin.Context, action, target string, before, after interface{}) {
+	if trail == nil {
+		return
+	}
+	e := audit.Entry{Actor: actor(c), Action: action, Target: target, Changes: audit.Diff(before, after)}
+	if err := trail.In(store(c)).Record(e); err != nil {
+		logging.From(c).Error("cannot record a change", logging.Fields{"action": action, "target": target, "error": err})
+	}
+}
+
+// auditEvents returns an auth event handler which records events in the
+// trail as well as passing them to next
+func auditEvents(next func(*gin.Context, auth.Event)) func(*gin.Context, auth.Event) {
+	return func(c *gin.Context, e auth.Event) {
+		next(c, e)
+		err := trail.In(store(c)).Record(audit.Entry{
+			Time:   e.Time,
+			Actor:  audit.Actor{Name: e.User, IP: e.IP, Path: e.Path, RequestID: e.RequestID},
+			Action: e.Type,
+			Detail: e.Detail,
+		})
+		if err != nil {
+			logging.From(c).Error("cannot record an auth event", logging.Fields{"type": e.Type, "error": err})
+		}
+	}
+}
+
+// auditList returns audit entries newest first.  Entries can be filtered by
+// actor, action, target, ip and a from and to time.
+func auditList(c *gin.Context) {
+	var (
+		f         audit.Filter
+		max, page int64
+		err       error
+	)
+	f.Actor, f.Action, f.Target, f.IP = c.Query("actor"), c.Query("action"), c.Query("target"), c.Query("ip")
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if v := c.Query(p.name); v != "" {
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("%s must be an RFC 3339 time such as 2026-10-01T00:00:00Z", p.name)})
+				return
+			}
+		}
+	}
+	if max, err = strconv.ParseInt(c.Query("max"), 10, 32); err != nil || max <= 0 {
+		max = defaultListMax
+	}
+	if max > maxListSize {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("max parameter %d exceeds maximum allowed size of %d", max, maxListSize)})
+		return
+	}
+	if page, err = strconv.ParseInt(c.Query("page"), 10, 32); err != nil || page < 0 {
+		page = 0
+	}
+	items, err := trail.In(store(c)).Find(f, page*max, max)
+	if err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, fdc.BrowseResult{Count: int32(len(items)), Start: int32(page), Max: int32(max), Items: items})
+}
@@ -92,3 +92,16 @@ func chkConfig(cs *fdc.Config) (bool, string) {
 	}
 	return true, ""
 }
+
+func TestLockoutDefaults(t *testing.T) {
+	for in, want := range map[string]int{"lockout:\n  attempts: 0\n": 0, "lockout:\n  attempts: 3\n": 3, "couchdb:\n  url: localhost\n": 5} {
+		var cs fdc.Config
+		if err := yaml.Unmarshal([]byte(in), &cs); err != nil {
+			t.Fatal(err)
+		}
+		cs.Defaults()
+		if cs.Lockout.Attempts == nil || *cs.Lockout.Attempts != want {
+			t.Errorf("%s got attempts %v want %d", in, cs.Lockout.Attempts, want)
+		}
+	}
+}
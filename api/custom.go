@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	auth "github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// customList returns the current user's custom foods or recipes
+func customList(t fdc.DocType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			dt    fdc.DocType
+			items []interface{}
+		)
+		q := fmt.Sprintf("SELECT f.* FROM %s f WHERE f.type=\"%s\" AND f.owner=%s ORDER BY f.foodDescription", cs.CouchDb.Bucket, dt.ToString(t), fdc.Quote(owner(c)))
+		if err := store(c).Query(q, &items); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+			return
+		}
+		results := fdc.BrowseResult{Count: int32(len(items)), Start: 0, Max: int32(len(items)), Items: items}
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// customGet returns one of the current user's custom foods or recipes
+func customGet(t fdc.DocType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, err := ownedFood(store(c), c.Param("id"), t, owner(c))
+		if err != nil {
+			errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, f)
+	}
+}
+
+// customSave creates a custom food or recipe or replaces one identified by
+// the id parameter and saves its nutrient data so it can be used wherever
+// foods are.  The nutrient values of recipes are computed from their items
+// when they are saved and again when one of their items is saved.
+func customSave(t fdc.DocType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			dt  fdc.DocType
+			cf  fdc.CustomFoodRequest
+			err error
+		)
+		name := owner(c)
+		if err = c.BindJSON(&cf); err != nil {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+			return
+		}
+		status := http.StatusOK
+		id := c.Param("id")
+		if id != "" {
+			if _, err = ownedFood(store(c), id, t, name); err != nil {
+				errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": err.Error()})
+				return
+			}
+		} else {
+			if id, err = customID(); err != nil {
+				errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": err.Error()})
+				return
+			}
+			status = http.StatusCreated
+		}
+		f := fdc.Food{FdcID: id, Description: cf.Description, Ingredients: cf.Ingredients, Servings: cf.Servings, Source: "USER", Type: dt.ToString(t), Owner: name, UpdatedAt: time.Now()}
+		var nutrients map[int]fdc.NutrientData
+		if t == fdc.RECIPE {
+			nutrients, err = recipeNutrients(c, &f, cf)
+		} else {
+			nutrients, err = customNutrients(store(c), cf)
+		}
+		if err != nil {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+			return
+		}
+		if err = saveNutrients(store(c), &f, nutrients); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot save nutrients %v", err)})
+			return
+		}
+		enrich(store(c), &f)
+		if err = audited(c).Update(id, f); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot save food %s %v", id, err)})
+			return
+		}
+		if err = refreshRecipes(c, id, map[string]bool{id: true}); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot update the recipes using %s %v", id, err)})
+			return
+		}
+		c.JSON(status, f)
+	}
+}
+
+// customDelete removes one of the current user's custom foods or recipes and
+// its nutrient data.  Foods used by a recipe can't be removed.
+func customDelete(t fdc.DocType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := ownedFood(store(c), id, t, owner(c)); err != nil {
+			errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": err.Error()})
+			return
+		}
+		recipes, err := recipesUsing(store(c), id, owner(c))
+		if err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+			return
+		}
+		if len(recipes) > 0 {
+			errorout(c, http.StatusConflict, gin.H{"status": http.StatusConflict, "message": fmt.Sprintf("Food %s is used by recipe %s", id, recipes[0].FdcID)})
+			return
+		}
+		if err := removeNutrients(store(c), id); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot remove nutrients %v", err)})
+			return
+		}
+		if err := audited(c).Remove(id); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot remove food %s %v", id, err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": fmt.Sprintf("Food %s deleted", id)})
+	}
+}
+
+// ownedFood returns a custom food or recipe of a user
+func ownedFood(d ds.DataSource, id string, t fdc.DocType, name string) (fdc.Food, error) {
+	var (
+		dt fdc.DocType
+		f  fdc.Food
+	)
+	if err := d.Get(id, &f); err != nil || f.Type != dt.ToString(t) || f.Owner != name {
+		return f, fmt.Errorf("No %s %s found", strings.ToLower(dt.ToString(t)), id)
+	}
+	return f, nil
+}
+
+// customNutrients validates the nutrient values of a custom food against
+// the nutrient dictionary
+func customNutrients(d ds.DataSource, cf fdc.CustomFoodRequest) (map[int]fdc.NutrientData, error) {
+	if len(cf.Items) > 0 {
+		return nil, errors.New("custom foods have nutrients rather than items, use a recipe")
+	}
+	if len(cf.Nutrients) == 0 {
+		return nil, errors.New("at least one nutrient value is required")
+	}
+	dict, err := nutrientDictionary(d)
+	if err != nil {
+		return nil, err
+	}
+	nutrients := make(map[int]fdc.NutrientData)
+	for _, n := range cf.Nutrients {
+		nut, ok := dict[n.Nutrientno]
+		if !ok {
+			return nil, fmt.Errorf("%d is not a nutrient number", n.Nutrientno)
+		}
+		if n.Value < 0 {
+			return nil, fmt.Errorf("the value of nutrient %d must be >= 0", n.Nutrientno)
+		}
+		nutrients[n.Nutrientno] = fdc.NutrientData{Nutrientno: float64(n.Nutrientno), Nutrient: nut.Name, Unit: nut.Unit, Value: n.Value}
+	}
+	return nutrients, nil
+}
+
+// recipeNutrients sets the input foods of a recipe from its items and
+// returns its nutrient values per 100 g.  Items may be any food the current
+// user can see other than the recipe itself.
+func recipeNutrients(c *gin.Context, f *fdc.Food, cf fdc.CustomFoodRequest) (map[int]fdc.NutrientData, error) {
+	if len(cf.Nutrients) > 0 {
+		return nil, errors.New("the nutrients of recipes are computed from their items")
+	}
+	if len(cf.Items) == 0 || len(cf.Items) > maxRecipeItems {
+		return nil, fmt.Errorf("recipes require between 1 and %d items", maxRecipeItems)
+	}
+	sums := make(map[int]fdc.NutrientData)
+	total := 0.0
+	for i, item := range cf.Items {
+		var food fdc.Food
+		if item.Grams <= 0 {
+			return nil, fmt.Errorf("item %s must weigh more than 0 grams", item.FdcID)
+		}
+		id, err := resolveID(store(c), item.FdcID)
+		if err != nil {
+			return nil, err
+		}
+		if id == f.FdcID {
+			return nil, errors.New("a recipe cannot include itself")
+		}
+		if id == "" || store(c).Get(id, &food) != nil || !canSee(c, &food) {
+			return nil, fmt.Errorf("No food %s found", item.FdcID)
+		}
+		nd, err := foodNutrients(store(c), id)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nd {
+			s := sums[int(n.Nutrientno)]
+			s.Nutrientno, s.Nutrient, s.Unit = n.Nutrientno, n.Nutrient, n.Unit
+			s.Value += n.Value * item.Grams / 100
+			sums[int(n.Nutrientno)] = s
+		}
+		total += item.Grams
+		f.InputFoods = append(f.InputFoods, fdc.InputFood{FdcID: id, Description: food.Description, SeqNo: i + 1, Amount: float32(item.Grams), Unit: "g", Weight: float32(item.Grams)})
+	}
+	for k, s := range sums {
+		s.Value = s.Value * 100 / total
+		sums[k] = s
+	}
+	return sums, nil
+}
+
+// recipesUsing returns a user's recipes which have the food id as an item
+func recipesUsing(d ds.DataSource, id string, name string) ([]fdc.Food, error) {
+	var (
+		dt    fdc.DocType
+		items []interface{}
+	)
+	q := fmt.Sprintf("SELECT RAW r FROM %s r WHERE r.type=\"%s\" AND r.owner=%s AND ANY i IN r.inputfoods SATISFIES i.fdcId=%s END", cs.CouchDb.Bucket, dt.ToString(fdc.RECIPE), fdc.Quote(name), fdc.Quote(id))
+	if err := d.Query(q, &items); err != nil {
+		return nil, err
+	}
+	recipes := make([]fdc.Food, len(items))
+	for i := range items {
+		b, _ := json.Marshal(items[i])
+		if err := json.Unmarshal(b, &recipes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return recipes, nil
+}
+
+// refreshRecipes recomputes the nutrient values of the current user's
+// recipes which use the food id and of the recipes using those in turn.
+// Recipes in seen are skipped.
+func refreshRecipes(c *gin.Context, id string, seen map[string]bool) error {
+	recipes, err := recipesUsing(store(c), id, owner(c))
+	if err != nil {
+		return err
+	}
+	for _, r := range recipes {
+		if seen[r.FdcID] {
+			continue
+		}
+		seen[r.FdcID] = true
+		var cf fdc.CustomFoodRequest
+		for _, in := range r.InputFoods {
+			cf.Items = append(cf.Items, fdc.RecipeItem{FdcID: in.FdcID, Grams: float64(in.Weight)})
+		}
+		r.InputFoods, r.UpdatedAt = nil, time.Now()
+		nutrients, err := recipeNutrients(c, &r, cf)
+		if err != nil {
+			return err
+		}
+		if err = saveNutrients(store(c), &r, nutrients); err != nil {
+			return err
+		}
+		enrich(store(c), &r)
+		if err = audited(c).Update(r.FdcID, r); err != nil {
+			return err
+		}
+		if err = refreshRecipes(c, r.FdcID, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveNutrients replaces the nutrient data of a custom food or recipe.
+// Values per portion use the first serving size.
+func saveNutrients(d ds.DataSource, f *fdc.Food, nutrients map[int]fdc.NutrientData) error {
+	var dt fdc.DocType
+	if err := removeNutrients(d, f.FdcID); err != nil {
+		return err
+	}
+	for no, n := range nutrients {
+		n.ID = fmt.Sprintf("%s_%d", f.FdcID, no)
+		n.FdcID, n.Description, n.Source, n.Owner = f.FdcID, f.Description, f.Source, f.Owner
+		n.Type = dt.ToString(fdc.NUTDATA)
+		if len(f.Servings) > 0 {
+			n.Portion = f.Servings[0].Description
+			n.PortionValue = n.Value * float64(f.Servings[0].Weight) / 100
+		}
+		if err := d.Update(n.ID, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeNutrients removes the nutrient data of a custom food or recipe
+func removeNutrients(d ds.DataSource, fdcID string) error {
+	var (
+		dt  fdc.DocType
+		ids []interface{}
+	)
+	q := fmt.Sprintf("SELECT RAW META().id FROM %s WHERE type=\"%s\" AND fdcId=%s AND owner IS VALUED", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), fdc.Quote(fdcID))
+	if err := d.Query(q, &ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if key, ok := id.(string); ok {
+			if err := d.Remove(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nutrientDictionary returns the nutrients keyed by nutrient number
+func nutrientDictionary(d ds.DataSource) (map[int]fdc.Nutrient, error) {
+	var dt fdc.DocType
+	items, err := d.GetDictionary(cs.CouchDb.Bucket, dt.ToString(fdc.NUT), 0, 1000)
+	if err != nil {
+		return nil, err
+	}
+	dict := make(map[int]fdc.Nutrient)
+	for i := range items {
+		var n fdc.Nutrient
+		b, _ := json.Marshal(items[i])
+		if err = json.Unmarshal(b, &n); err != nil {
+			return nil, err
+		}
+		dict[int(n.Nutrientno)] = n
+	}
+	return dict, nil
+}
+
+// customMatches returns the current user's custom foods and recipes whose
+// descriptions contain a search query
+func customMatches(d ds.DataSource, sr fdc.SearchRequest) ([]interface{}, error) {
+	var (
+		dt    fdc.DocType
+		items []interface{}
+	)
+	q := strings.ToLower(sr.Query)
+	if sr.Owner == "" || strings.TrimSpace(q) == "" {
+		return nil, nil
+	}
+	n1ql := fmt.Sprintf("SELECT f.* FROM %s f WHERE f.type IN [\"%s\",\"%s\"] AND f.owner=%s AND CONTAINS(LOWER(f.foodDescription), %s) LIMIT %d", cs.CouchDb.Bucket, dt.ToString(fdc.CUSTOM), dt.ToString(fdc.RECIPE), fdc.Quote(sr.Owner), fdc.Quote(q), sr.Max)
+	err := d.Query(n1ql, &items)
+	return items, err
+}
+
+// customID returns a new key for a custom food or recipe.  Keys are not
+// numeric so they never collide with fdcIds or barcodes.
+func customID() (string, error) {
+	k, err := randomKey()
+	return "c" + k, err
+}
+
+// randomKey returns 16 random hex digits for use in document keys
+func randomKey() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// owner returns the name of the authenticated user of a request or "" if
+// the request is anonymous
+func owner(c *gin.Context) string {
+	if u, ok := auth.CurrentUser(c); ok {
+		return u.Name
+	}
+	return ""
+}
+
+// canSee reports whether the current user may see a food, which is true of
+// every food except other users' custom foods and recipes
+func canSee(c *gin.Context, f *fdc.Food) bool {
+	if f.Owner == "" {
+		return true
+	}
+	return owner(c) == f.Owner
+}
+
+// ownerWhere returns a N1QL predicate which restricts documents with an
+// owner to those of the current user
+func ownerWhere(c *gin.Context) string {
+	if name := owner(c); name != "" {
+		return fmt.Sprintf(" AND (owner IS MISSING OR owner=%s)", fdc.Quote(name))
+	}
+	return " AND owner IS MISSING"
+}
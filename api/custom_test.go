@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	auth "github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestOwnerScope(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if w := ownerWhere(c); w != " AND owner IS MISSING" {
+		t.Errorf("Unexpected anonymous predicate %s", w)
+	}
+	if canSee(c, &fdc.Food{Owner: "alice"}) || !canSee(c, &fdc.Food{}) {
+		t.Error("Expecting anonymous users to see only public foods")
+	}
+	c.Set("role", &auth.User{Name: "alice", Role: "USER"})
+	if w := ownerWhere(c); w != ` AND (owner IS MISSING OR owner="alice")` {
+		t.Errorf("Unexpected user predicate %s", w)
+	}
+	if !canSee(c, &fdc.Food{Owner: "alice"}) || canSee(c, &fdc.Food{Owner: "bob"}) {
+		t.Error("Expecting alice to see only her own custom foods")
+	}
+	c.Set("role", &auth.User{Name: `x") OR ("1"="1\`, Role: "USER"})
+	if w := ownerWhere(c); w != ` AND (owner IS MISSING OR owner="x\") OR (\"1\"=\"1\\")` {
+		t.Errorf("Owner is not quoted in %s", w)
+	}
+}
+
+func TestCustomValidation(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if _, err := customNutrients(nil, fdc.CustomFoodRequest{Description: "House dressing"}); err == nil {
+		t.Error("Expecting an error for a custom food without nutrients")
+	}
+	if _, err := customNutrients(nil, fdc.CustomFoodRequest{Items: []fdc.RecipeItem{{FdcID: "389714", Grams: 10}}}); err == nil {
+		t.Error("Expecting an error for a custom food with items")
+	}
+	for _, cf := range []fdc.CustomFoodRequest{
+		{},
+		{Items: []fdc.RecipeItem{{FdcID: "389714", Grams: 10}}, Nutrients: []fdc.CustomNutrient{{Nutrientno: 208, Value: 100}}},
+		{Items: []fdc.RecipeItem{{FdcID: "389714", Grams: 0}}},
+		{Items: []fdc.RecipeItem{{FdcID: "cself", Grams: 10}}},
+	} {
+		if _, err := recipeNutrients(c, &fdc.Food{FdcID: "cself"}, cf); err == nil {
+			t.Errorf("Expecting an error for recipe %+v", cf)
+		}
+	}
+}
+
+// recipeDs holds custom foods and answers recipe queries with recipes
+type recipeDs struct {
+	ds.DataSource
+	foods   map[string]fdc.Food
+	recipes []interface{}
+	removed []string
+}
+
+func (d *recipeDs) Get(id string, f interface{}) error {
+	food, ok := d.foods[id]
+	if !ok {
+		return errors.New("key not found")
+	}
+	b, _ := json.Marshal(food)
+	return json.Unmarshal(b, f)
+}
+
+func (d *recipeDs) Query(q string, f *[]interface{}) error {
+	if strings.Contains(q, `type="RECIPE"`) {
+		*f = d.recipes
+	}
+	return nil
+}
+
+func (d *recipeDs) Remove(id string) error {
+	d.removed = append(d.removed, id)
+	return nil
+}
+
+func TestCustomDeleteInRecipe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(d ds.DataSource) { dc = d }(dc)
+	d := &recipeDs{foods: map[string]fdc.Food{"c1": {FdcID: "c1", Type: "CUSTOM", Owner: "alice"}}}
+	dc = d
+	r := gin.New()
+	r.DELETE("/foods/:id", func(c *gin.Context) {
+		c.Set("role", &auth.User{Name: "alice", Role: "USER"})
+	}, customDelete(fdc.CUSTOM))
+	del := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/foods/c1", nil))
+		return w.Code
+	}
+	d.recipes = []interface{}{map[string]interface{}{"fdcId": "r1", "type": "RECIPE", "owner": "alice"}}
+	if code := del(); code != http.StatusConflict || len(d.removed) != 0 {
+		t.Errorf("got %d removing %v, want a conflict for a food used by a recipe", code, d.removed)
+	}
+	d.recipes = nil
+	if code := del(); code != http.StatusOK || len(d.removed) != 1 || d.removed[0] != "c1" {
+		t.Errorf("got %d removing %v", code, d.removed)
+	}
+}
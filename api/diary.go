@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/diary"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// diaryAdd records an amount of a food in the current user's diary with the
+// nutrients it provides
+func diaryAdd(c *gin.Context) {
+	var (
+		dt fdc.DocType
+		dr fdc.DiaryEntryRequest
+		f  fdc.Food
+	)
+	if err := c.BindJSON(&dr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	if err := diary.CheckMeal(dr.Meal); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	id, err := resolveID(store(c), dr.FdcID)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if id == "" || store(c).Get(id, &f) != nil || !canSee(c, &f) {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No food found!"})
+		return
+	}
+	grams, err := diary.Grams(&f, dr.Serving, dr.Amount)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	nd, err := foodNutrients(store(c), id)
+	if err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	if dr.Time.IsZero() {
+		dr.Time = time.Now()
+	}
+	key, err := randomKey()
+	if err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": err.Error()})
+		return
+	}
+	name := owner(c)
+	e := fdc.DiaryEntry{
+		ID:          fmt.Sprintf("%s:%s:%s", dt.ToString(fdc.DIARY), name, key),
+		Type:        dt.ToString(fdc.DIARY),
+		Owner:       name,
+		FdcID:       id,
+		Description: f.Description,
+		Serving:     dr.Serving,
+		Amount:      dr.Amount,
+		Grams:       grams,
+		Time:        dr.Time,
+		Meal:        dr.Meal,
+		Nutrients:   diary.Consumed(nd, grams),
+	}
+	if err = audited(c).Update(e.ID, e); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot save diary entry %v", err)})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// diaryGet returns the current user's diary entries with daily nutrient
+// totals compared against a Daily Value profile.  The from and to dates
+// default to today.
+func diaryGet(c *gin.Context) {
+	var (
+		dt      fdc.DocType
+		rows    []interface{}
+		entries []fdc.DiaryEntry
+	)
+	to := time.Now()
+	from, err := diaryDate(c.Query("from"), to)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if to, err = diaryDate(c.Query("to"), from); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if to.Before(from) || to.Sub(from) >= maxDiaryDays*24*time.Hour {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("to must be on or after from and within %d days", maxDiaryDays)})
+		return
+	}
+	name := c.DefaultQuery("profile", diary.ADULT)
+	p, ok := diary.Profiles[name]
+	if !ok {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("profile must be %s or %s", diary.ADULT, diary.CHILD)})
+		return
+	}
+	// timestamps keep the zone they were recorded in so allow a day either side
+	q := fmt.Sprintf("SELECT d.* FROM %s d WHERE d.type=\"%s\" AND d.owner=%s AND d.timestamp BETWEEN %s AND %s", cs.CouchDb.Bucket, dt.ToString(fdc.DIARY), fdc.Quote(owner(c)), fdc.Quote(from.AddDate(0, 0, -1).Format(diary.DateFormat)), fdc.Quote(to.AddDate(0, 0, 2).Format(diary.DateFormat)))
+	if err = store(c).Query(q, &rows); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	for i := range rows {
+		var e fdc.DiaryEntry
+		b, _ := json.Marshal(rows[i])
+		if err = json.Unmarshal(b, &e); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Invalid diary entry %v", err)})
+			return
+		}
+		entries = append(entries, e)
+	}
+	results := fdc.DiaryReport{From: from.Format(diary.DateFormat), To: to.Format(diary.DateFormat), Profile: p.Name, Days: diary.Days(entries, from, to, p)}
+	c.JSON(http.StatusOK, results)
+}
+
+// diaryDelete removes an entry from the current user's diary
+func diaryDelete(c *gin.Context) {
+	var e fdc.DiaryEntry
+	id := c.Param("id")
+	if err := store(c).Get(id, &e); err != nil || e.Owner != owner(c) {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No diary entry found!"})
+		return
+	}
+	if err := audited(c).Remove(id); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot remove diary entry %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": fmt.Sprintf("Diary entry %s deleted", id)})
+}
+
+// diaryProfiles returns the Daily Value profiles
+func diaryProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, diary.Profiles)
+}
+
+// diaryDate parses a date parameter or returns the date of def if it is empty
+func diaryDate(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Date(def.Year(), def.Month(), def.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	d, err := time.Parse(diary.DateFormat, s)
+	if err != nil {
+		return d, fmt.Errorf("date %s must be of the form YYYY-MM-DD", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiaryDate(t *testing.T) {
+	def := time.Date(2020, 3, 1, 15, 4, 5, 0, time.UTC)
+	if d, err := diaryDate("", def); err != nil || !d.Equal(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expecting the default date got %v %v", d, err)
+	}
+	if d, err := diaryDate("2020-02-29", def); err != nil || d.Day() != 29 {
+		t.Errorf("Expecting Feb 29 got %v %v", d, err)
+	}
+	if _, err := diaryDate("03/01/2020", def); err == nil {
+		t.Error("Expecting an error for a badly formed date")
+	}
+}
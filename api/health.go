@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// readyMaxAge is how long a readiness check is reused so probes and other
+// callers can't make the datastore answer a ping for every request
+const readyMaxAge = 5 * time.Second
+
+// started is when the server process began
+var started = time.Now()
+
+// lastReady is the last readiness check
+var lastReady struct {
+	sync.Mutex
+	at   time.Time
+	code int
+	r    fdc.Readiness
+}
+
+// healthz reports the process is alive.  It never touches the datastore so
+// an outage there doesn't get the server restarted.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "uptime": time.Since(started).Round(time.Second).String()})
+}
+
+// readyz reports whether the server can take traffic: the datastore answers
+// and has the indexes the queries use.  It returns 503 with the failed
+// checks until then.  A check is reused for readyMaxAge.
+func readyz(c *gin.Context) {
+	lastReady.Lock()
+	if time.Since(lastReady.at) >= readyMaxAge {
+		lastReady.code, lastReady.r = readiness(store(c))
+		lastReady.at = time.Now()
+	}
+	code, r := lastReady.code, lastReady.r
+	lastReady.Unlock()
+	c.JSON(code, r)
+}
+
+// readiness checks d and returns the status code and body of a readiness
+// response
+func readiness(d ds.DataSource) (int, fdc.Readiness) {
+	checks, err := d.Ping(cs)
+	r := fdc.Readiness{Status: "ready", Checks: checks}
+	if r.Checks == nil {
+		r.Checks = []fdc.Check{}
+	}
+	ready := err == nil
+	for _, ch := range checks {
+		ready = ready && ch.Status == "ok"
+	}
+	if !ready {
+		r.Status = "unavailable"
+		return http.StatusServiceUnavailable, r
+	}
+	return http.StatusOK, r
+}
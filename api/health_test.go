@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// pingDs answers Ping with fixed checks
+type pingDs struct {
+	ds.DataSource
+	checks []fdc.Check
+	err    error
+	pings  int
+}
+
+func (p *pingDs) Ping(cs fdc.Config) ([]fdc.Check, error) {
+	p.pings++
+	return p.checks, p.err
+}
+
+func TestReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(d ds.DataSource) { dc = d }(dc)
+	ok := fdc.Check{Name: "couchbase kv", Status: "ok", Latency: "1ms"}
+	missing := fdc.Check{Name: "n1ql indexes", Status: "fail", Message: "idx_fd_desc missing"}
+	for _, tc := range []struct {
+		d      *pingDs
+		code   int
+		status string
+		checks int
+	}{
+		{&pingDs{checks: []fdc.Check{ok}}, http.StatusOK, "ready", 1},
+		{&pingDs{checks: []fdc.Check{ok, missing}}, http.StatusServiceUnavailable, "unavailable", 2},
+		{&pingDs{err: errors.New("cannot reach bucket")}, http.StatusServiceUnavailable, "unavailable", 0},
+	} {
+		dc = tc.d
+		lastReady.at = time.Time{}
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			readyz(c)
+			var r fdc.Readiness
+			if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tc.code || r.Status != tc.status || len(r.Checks) != tc.checks {
+				t.Errorf("got %d %+v, want %d %s with %d checks", w.Code, r, tc.code, tc.status, tc.checks)
+			}
+		}
+		if tc.d.pings != 1 {
+			t.Errorf("pinged %d times for two checks", tc.d.pings)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/ratelimit"
+)
+
+// limiter applies the configured rate limit of a route group
+func limiter(group string) gin.HandlerFunc {
+	return ratelimit.Middleware(limits, group, ratelimit.Limit(cs.RateLimits[group]), clientKey)
+}
+
+// clientKey identifies the client of a request by its API key, the user
+// named in its token or else its IP address
+func clientKey(c *gin.Context) string {
+	if k, ok := auth.CurrentAPIKey(c); ok {
+		return "key:" + k.Prefix
+	}
+	if u, ok := auth.CurrentUser(c); ok && u.Name != "" {
+		return "user:" + u.Name
+	}
+	return "ip:" + c.ClientIP()
+}
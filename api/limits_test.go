@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/auth"
+)
+
+func TestClientKey(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	c.Request.RemoteAddr = "10.0.0.1:1234"
+	if k := clientKey(c); k != "ip:10.0.0.1" {
+		t.Errorf("got %s for an anonymous request", k)
+	}
+	c.Set("role", &auth.User{Name: "tester"})
+	if k := clientKey(c); k != "user:tester" {
+		t.Errorf("got %s for a user", k)
+	}
+	c.Set("apikey", &auth.APIKey{Prefix: "0a1b2c3d"})
+	if k := clientKey(c); k != "key:0a1b2c3d" {
+		t.Errorf("got %s for an API key", k)
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	if _, err := parseProxies([]string{"10.0.0.0/8", "proxy.example.com"}); err == nil {
+		t.Error("parsed a host name as a proxy")
+	}
+	p, err := parseProxies([]string{"10.0.0.0/8", " 192.168.1.5", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.ForwardedByClientIP = false
+	r.Use(realIP(p))
+	r.GET("/", func(c *gin.Context) { c.String(200, clientKey(c)) })
+	for _, tc := range []struct{ peer, forwarded, want string }{
+		{"203.0.113.9:1234", "", "ip:203.0.113.9"},
+		{"203.0.113.9:1234", "198.51.100.7", "ip:203.0.113.9"},
+		{"10.1.2.3:1234", "198.51.100.7", "ip:198.51.100.7"},
+		{"10.1.2.3:1234", "1.1.1.1, 198.51.100.7, 192.168.1.5", "ip:198.51.100.7"},
+		{"10.1.2.3:1234", "junk, 10.9.9.9", "ip:10.9.9.9"},
+		{"10.1.2.3:1234", "", "ip:10.1.2.3"},
+		{"[::1]:1234", "2001:db8::1", "ip:2001:db8::1"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.peer
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Body.String() != tc.want {
+			t.Errorf("peer %s forwarding %s got %s want %s", tc.peer, tc.forwarded, w.Body.String(), tc.want)
+		}
+	}
+}
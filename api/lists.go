@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// listsGet returns the current user's food lists and those shared with them
+func listsGet(c *gin.Context) {
+	var (
+		dt    fdc.DocType
+		items []interface{}
+	)
+	name := owner(c)
+	q := fmt.Sprintf("SELECT l.* FROM %s l WHERE l.type=\"%s\" AND (l.owner=%s OR ARRAY_CONTAINS(IFMISSINGORNULL(l.sharedWith, []), %s)) ORDER BY l.name", cs.CouchDb.Bucket, dt.ToString(fdc.LIST), fdc.Quote(name), fdc.Quote(name))
+	if err := store(c).Query(q, &items); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	results := fdc.BrowseResult{Count: int32(len(items)), Start: 0, Max: int32(len(items)), Items: items}
+	c.JSON(http.StatusOK, results)
+}
+
+// listAdd creates a food list for the current user.  List names are unique
+// for each user.
+func listAdd(c *gin.Context) {
+	var (
+		dt fdc.DocType
+		lr fdc.FoodListRequest
+	)
+	if err := c.BindJSON(&lr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	name := owner(c)
+	if err := checkListName(store(c), name, lr.Name, ""); err != nil {
+		errorout(c, http.StatusConflict, gin.H{"status": http.StatusConflict, "message": err.Error()})
+		return
+	}
+	items, err := listItems(c, nil, lr.Items)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	key, err := randomKey()
+	if err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": err.Error()})
+		return
+	}
+	l := fdc.FoodList{ID: "l" + key, Type: dt.ToString(fdc.LIST), Owner: name, Name: lr.Name, Description: lr.Description, SharedWith: lr.SharedWith, Items: items, UpdatedAt: time.Now()}
+	if err = audited(c).Update(l.ID, l); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot save list %v", err)})
+		return
+	}
+	c.JSON(http.StatusCreated, l)
+}
+
+// listGet returns a food list the current user owns or which is shared
+// with them
+func listGet(c *gin.Context) {
+	l, ok := readableList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	c.JSON(http.StatusOK, l)
+}
+
+// listUpdate changes the name, description or sharing of one of the current
+// user's lists
+func listUpdate(c *gin.Context) {
+	var lr fdc.FoodListRequest
+	l, ok := ownedList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	if err := c.BindJSON(&lr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	if len(lr.Items) > 0 {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "change items with the items endpoints"})
+		return
+	}
+	if err := checkListName(store(c), l.Owner, lr.Name, l.ID); err != nil {
+		errorout(c, http.StatusConflict, gin.H{"status": http.StatusConflict, "message": err.Error()})
+		return
+	}
+	l.Name, l.Description, l.SharedWith = lr.Name, lr.Description, lr.SharedWith
+	saveList(c, l)
+}
+
+// listDelete removes one of the current user's lists
+func listDelete(c *gin.Context) {
+	l, ok := ownedList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	if err := audited(c).Remove(l.ID); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot remove list %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": fmt.Sprintf("List %s deleted", l.ID)})
+}
+
+// listItemsAdd appends foods identified by fdcId or UPC to one of the current
+// user's lists.  Foods already in the list are skipped.
+func listItemsAdd(c *gin.Context) {
+	var ir fdc.ListItemsRequest
+	l, ok := ownedList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	if err := c.BindJSON(&ir); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	items, err := listItems(c, l.Items, ir.IDs)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	l.Items = items
+	saveList(c, l)
+}
+
+// listItemRemove removes a food identified by fdcId or UPC from one of the
+// current user's lists
+func listItemRemove(c *gin.Context) {
+	l, ok := ownedList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	id, err := resolveID(store(c), c.Param("item"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	for i := range l.Items {
+		if l.Items[i].FdcID == id {
+			l.Items = append(l.Items[:i], l.Items[i+1:]...)
+			saveList(c, l)
+			return
+		}
+	}
+	errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": fmt.Sprintf("%s is not in the list", c.Param("item"))})
+}
+
+// listReorder puts the items of one of the current user's lists in the
+// order of a list of their fdcIds or UPCs
+func listReorder(c *gin.Context) {
+	var ir fdc.ListItemsRequest
+	l, ok := ownedList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	if err := c.BindJSON(&ir); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	ids, err := getFdcIDs(store(c), ir.IDs)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	items, err := reorder(l.Items, ids)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	l.Items = items
+	saveList(c, l)
+}
+
+// listNutrients returns the nutrient data of a page of the foods in a list
+// in list order using the same form as /nutrients/foods.  Pages hold at most
+// as many foods as /nutrients/foods accepts.
+func listNutrients(c *gin.Context) {
+	var (
+		ids       []string
+		max, page int64
+		err       error
+	)
+	if max, err = strconv.ParseInt(c.Query("max"), 10, 32); err != nil || max <= 0 || max > maxNutrientFoods {
+		max = maxNutrientFoods
+	}
+	if page, err = strconv.ParseInt(c.Query("page"), 10, 32); err != nil || page < 0 {
+		page = 0
+	}
+	l, ok := readableList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	pos := make(map[string]int)
+	for i := page * max; i < int64(len(l.Items)) && i < (page+1)*max; i++ {
+		ids = append(ids, l.Items[i].FdcID)
+		pos[l.Items[i].FdcID] = int(i)
+	}
+	nfbs := []fdc.NutrientFoodBrowse{}
+	if len(ids) > 0 {
+		if nfbs, err = foodsNutrients(c, ids, c.QueryArray("n")); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+			return
+		}
+		sortByPosition(nfbs, pos)
+	}
+	c.JSON(http.StatusOK, nfbs)
+}
+
+// listCSV exports the foods in a list as CSV
+func listCSV(c *gin.Context) {
+	l, ok := readableList(c, c.Param("id"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No list found!"})
+		return
+	}
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", l.Name+".csv"))
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"fdcId", "upc", "foodDescription", "company"})
+	for _, item := range l.Items {
+		w.Write([]string{item.FdcID, item.Upc, item.Description, item.Manufacturer})
+	}
+	w.Flush()
+}
+
+// saveList updates a list and returns it
+func saveList(c *gin.Context, l fdc.FoodList) {
+	l.UpdatedAt = time.Now()
+	if err := audited(c).Update(l.ID, l); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot save list %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, l)
+}
+
+// readableList returns a list which the current user owns or which is shared
+// with them or with everyone
+func readableList(c *gin.Context, id string) (fdc.FoodList, bool) {
+	var (
+		dt fdc.DocType
+		l  fdc.FoodList
+	)
+	if err := store(c).Get(id, &l); err != nil || l.Type != dt.ToString(fdc.LIST) {
+		return l, false
+	}
+	return l, canRead(l, owner(c))
+}
+
+// canRead reports whether a user may read a list
+func canRead(l fdc.FoodList, name string) bool {
+	if name != "" && name == l.Owner {
+		return true
+	}
+	for _, s := range l.SharedWith {
+		if s == fdc.EVERYONE || name != "" && s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedList returns one of the current user's lists
+func ownedList(c *gin.Context, id string) (fdc.FoodList, bool) {
+	l, ok := readableList(c, id)
+	return l, ok && l.Owner == owner(c)
+}
+
+// checkListName returns an error if a user has a list other than the list
+// with id named name
+func checkListName(d ds.DataSource, user string, name string, id string) error {
+	var (
+		dt  fdc.DocType
+		ids []interface{}
+	)
+	q := fmt.Sprintf("SELECT RAW META().id FROM %s WHERE type=\"%s\" AND owner=%s AND name=%s", cs.CouchDb.Bucket, dt.ToString(fdc.LIST), fdc.Quote(user), fdc.Quote(name))
+	if err := d.Query(q, &ids); err != nil {
+		return err
+	}
+	for _, i := range ids {
+		if i != id {
+			return fmt.Errorf("a list named %s already exists", name)
+		}
+	}
+	return nil
+}
+
+// listItems appends foods identified by fdcId or UPC to a list's items,
+// skipping foods already in the list.  Requests which could take a list past
+// maxListItems are refused before any food is looked up.
+func listItems(c *gin.Context, items []fdc.ListItem, ids []string) ([]fdc.ListItem, error) {
+	if len(items)+len(ids) > maxListItems {
+		return nil, fmt.Errorf("lists may have at most %d items", maxListItems)
+	}
+	seen := make(map[string]bool)
+	for _, item := range items {
+		seen[item.FdcID] = true
+	}
+	for _, code := range ids {
+		var f fdc.Food
+		id, err := resolveID(store(c), code)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			continue
+		}
+		if id == "" || store(c).Get(id, &f) != nil || !canSee(c, &f) {
+			return nil, fmt.Errorf("No food %s found", code)
+		}
+		seen[id] = true
+		items = append(items, fdc.ListItem{FdcID: f.FdcID, Upc: f.Upc, Description: f.Description, Manufacturer: f.Manufacturer})
+	}
+	if items == nil {
+		items = []fdc.ListItem{}
+	}
+	return items, nil
+}
+
+// reorder returns items in the order of ids which must list each item once
+func reorder(items []fdc.ListItem, ids []string) ([]fdc.ListItem, error) {
+	byID := make(map[string]fdc.ListItem)
+	for _, item := range items {
+		byID[item.FdcID] = item
+	}
+	if len(ids) != len(items) {
+		return nil, errors.New("the new order must list every item once")
+	}
+	var ordered []fdc.ListItem
+	for _, id := range ids {
+		item, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the list or is repeated", id)
+		}
+		delete(byID, id)
+		ordered = append(ordered, item)
+	}
+	return ordered, nil
+}
+
+// sortByPosition orders nutrient data by the position of each food in a list
+func sortByPosition(nfbs []fdc.NutrientFoodBrowse, pos map[string]int) {
+	sort.SliceStable(nfbs, func(i, j int) bool {
+		return pos[nfbs[i].FdcID] < pos[nfbs[j].FdcID]
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestReorder(t *testing.T) {
+	items := []fdc.ListItem{{FdcID: "1"}, {FdcID: "2"}, {FdcID: "3"}}
+	r, err := reorder(items, []string{"3", "1", "2"})
+	if err != nil || r[0].FdcID != "3" || r[1].FdcID != "1" || r[2].FdcID != "2" {
+		t.Errorf("Unexpected order %v %v", r, err)
+	}
+	for _, ids := range [][]string{{"3", "1"}, {"3", "3", "1"}, {"3", "1", "4"}} {
+		if _, err = reorder(items, ids); err == nil {
+			t.Errorf("Expecting an error reordering by %v", ids)
+		}
+	}
+}
+
+func TestCanRead(t *testing.T) {
+	l := fdc.FoodList{Owner: "alice", SharedWith: []string{"bob"}}
+	if !canRead(l, "alice") || !canRead(l, "bob") || canRead(l, "carol") || canRead(l, "") {
+		t.Error("Expecting only alice and bob to read the list")
+	}
+	l.SharedWith = append(l.SharedWith, fdc.EVERYONE)
+	if !canRead(l, "") {
+		t.Error("Expecting anyone to read a list shared with everyone")
+	}
+}
+
+func TestSortByPosition(t *testing.T) {
+	nfbs := []fdc.NutrientFoodBrowse{{FdcID: "1"}, {FdcID: "2"}, {FdcID: "3"}}
+	sortByPosition(nfbs, map[string]int{"3": 0, "1": 1, "2": 2})
+	if nfbs[0].FdcID != "3" || nfbs[2].FdcID != "2" {
+		t.Errorf("Expecting list order got %v", nfbs)
+	}
+}
+
+func TestListItemsCap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// any datastore call panics so an oversized request must be refused
+	// before foods are looked up
+	defer func(d ds.DataSource) { dc = d }(dc)
+	dc = &pingDs{}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	items := []fdc.ListItem{{FdcID: "1"}}
+	var ids []string
+	for i := 0; i < maxListItems; i++ {
+		ids = append(ids, fmt.Sprint(i+2))
+	}
+	if _, err := listItems(c, items, ids); err == nil {
+		t.Errorf("Expecting %d items to be refused", len(items)+len(ids))
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
+)
+
+// store returns the datastore for a request.  Its calls are spans of the
+// request's trace, logged with the request's id and added to the request's
+// datastore timings.
+func store(c *gin.Context) ds.DataSource {
+	return instrument(c, dc)
+}
+
+// instrument returns d with its calls traced and logged as part of a request
+func instrument(c *gin.Context, d ds.DataSource) ds.DataSource {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	return logging.From(c).Instrument(traces.Instrument(ctx, d))
+}
@@ -4,101 +4,248 @@ package main
 // @APITitle Brand Foods Product Database
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/fvbock/endless"
 	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/audit"
 	auth "github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/diet"
 	"github.com/prLorence/fdc-api/ds"
 	"github.com/prLorence/fdc-api/ds/cb"
+	"github.com/prLorence/fdc-api/logging"
+	"github.com/prLorence/fdc-api/mail"
+	"github.com/prLorence/fdc-api/metrics"
 	fdc "github.com/prLorence/fdc-api/model"
+	"github.com/prLorence/fdc-api/ratelimit"
+	"github.com/prLorence/fdc-api/tracing"
 )
 
 const (
-	maxListSize    = 150
-	defaultListMax = 50
-	apiVersion     = "1.0.0 Beta"
-	JSONSPEC       = "./dist/apiDoc.json"
-	YAMLSPEC       = "./dist/apiDoc.yaml"
+	maxListSize          = 150
+	defaultListMax       = 50
+	maxSuggestSize       = 25
+	defaultSuggestMax    = 10
+	maxFacetSize         = 50
+	defaultFacetSize     = 10
+	maxFuzziness         = 2
+	defaultFuzziness     = 1
+	maxClauses           = 10
+	maxIngredientFilters = 10
+	maxRecipeItems       = 50
+	maxDiaryDays         = 31
+	maxListItems         = 500
+	maxNutrientFoods     = 24
+	apiVersion           = "1.0.0 Beta"
+	JSONSPEC             = "./dist/apiDoc.json"
+	YAMLSPEC             = "./dist/apiDoc.yaml"
 )
 
 var (
-	s   = flag.String("s", "dist", "Path for static files")
-	i   = flag.String("i", "", "Initialize the authentication store")
-	c   = flag.String("c", "config.yml", "YAML Config file")
-	l   = flag.String("l", "/tmp/bfpd.out", "send log output to this file -- defaults to /tmp/bfpd.out")
-	p   = flag.String("p", "8000", "TCP port to used")
-	r   = flag.String("r", "v1", "root path to deploy -- defaults to 'v1'")
-	cs  fdc.Config
-	err error
-	dc  ds.DataSource
+	s      = flag.String("s", "dist", "Path for static files")
+	i      = flag.String("i", "", "Initialize the authentication store")
+	c      = flag.String("c", "config.yml", "YAML Config file")
+	l      = flag.String("l", "/tmp/bfpd.out", "send log output to this file -- defaults to /tmp/bfpd.out")
+	d      = flag.Bool("d", false, "output debugging messages")
+	p      = flag.String("p", "8000", "TCP port to used")
+	r      = flag.String("r", "v1", "root path to deploy -- defaults to 'v1'")
+	cs     fdc.Config
+	dc     ds.DataSource
+	diets  diet.Rules
+	limits ratelimit.Store
+	tokens *auth.Tokens
+	roles  *auth.Roles
+	mailer mail.Sender
+	sso    *auth.OIDC
+	trail  *audit.Trail
+	stats  = metrics.New()
+	traces = tracing.Off()
 )
 
-// process cli flags; build the config and init an Mongo client and a logger
-func init() {
-	var lfile *os.File
-	lfile, err = os.OpenFile(*l, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalln("Failed to open log file", *l, ":", err)
-	}
-	m := io.MultiWriter(lfile, os.Stdout)
-	log.SetOutput(m)
-}
-
 func main() {
-	var cb cb.Cb
+	var (
+		cb     cb.Cb
+		logger *logging.Logger
+		err    error
+	)
 	flag.Parse()
 	// get configuration
 	cs.GetConfig(c)
+	// log JSON entries to the configured sinks, else the -l file and stdout
+	if len(cs.Logging.Sinks) == 0 {
+		cs.Logging.Sinks = []string{*l, "stdout"}
+	}
+	if logger, err = logging.Open(cs.Logging, *d); err != nil {
+		logging.Fatal("cannot open the log", logging.Fields{"error": err})
+	}
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.INFO))
+	if !*d {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	gin.DefaultWriter = logger.Writer(logging.DEBUG)
+	gin.DefaultErrorWriter = logger.Writer(logging.ERROR)
+	if traces, err = tracing.Open(cs.Tracing); err != nil {
+		logging.Fatal("cannot start tracing", logging.Fields{"error": err})
+	}
+	if diets, err = diet.Load(cs.Diet.Rules); err != nil {
+		logging.Fatal("cannot load diet rules", logging.Fields{"error": err})
+	}
 	// Create a datastore and connect to it
-	dc = &cb
+	dc = stats.Instrument(&cb)
 	err = dc.ConnectDs(cs)
 	if err != nil {
-		log.Fatalf("Cannot get datastore connection %v.", err)
+		logging.Fatal("cannot get datastore connection", logging.Fields{"error": err})
 	}
 	defer dc.CloseDs()
+	// record changes and auth events in the audit trail
+	trail = audit.NewTrail(dc, cs.CouchDb.Bucket)
+	auth.Events = auditEvents(auth.Events)
+	auth.Store = instrument
+	auth.Audited = auditedAs
 	// initialize our jwt authentication
+	var policy *auth.Policy
+	if policy, err = auth.NewPolicy(cs.Passwords, cs.Lockout); err != nil {
+		logging.Fatal("cannot load password policy", logging.Fields{"error": err})
+	}
+	auth.SetPolicy(policy)
 	var u *auth.User
 	if *i != "" {
-		if err = u.BootstrapUsers(i, dc); err != nil {
-			log.Fatalf("cannot bootstrap user %v", err)
+		if err = u.BootstrapUsers(i, trail.For(dc, audit.Actor{Name: "system", Path: "-i"})); err != nil {
+			logging.Fatal("cannot bootstrap user", logging.Fields{"error": err})
+		}
+	}
+	limits = ratelimit.NewMemoryStore()
+	if tokens, err = auth.NewTokens(cs.JWT); err != nil {
+		logging.Fatal("cannot load JWT keys", logging.Fields{"error": err})
+	}
+	if mailer, err = mail.New(cs.Mail); err != nil {
+		logging.Fatal("cannot create mail sender", logging.Fields{"error": err})
+	}
+	roles = auth.NewRoles(dc, cs.CouchDb.Bucket)
+	userMiddleware := u.UserMiddleware(tokens, dc)
+	if cs.OIDC.Issuer != "" {
+		if sso, err = auth.NewOIDC(context.Background(), cs.OIDC, userMiddleware); err != nil {
+			logging.Fatal("cannot discover the OIDC provider", logging.Fields{"error": err})
 		}
 	}
-	authMiddleware := u.AuthMiddleware(cs.CouchDb.Bucket, dc)
+	var trusted proxies
+	if trusted, err = parseProxies(cs.TrustedProxies); err != nil {
+		logging.Fatal("cannot parse the trusted proxies", logging.Fields{"error": err})
+	}
 	// router := gin.Default()
 	router := gin.New()
-	router.Use(gin.Logger())
+	// only realIP decides which forwarded addresses to believe
+	router.ForwardedByClientIP = false
+	router.Use(realIP(trusted))
+	router.Use(logging.Middleware(logger))
+	router.Use(traces.Middleware())
 	router.Use(gin.Recovery())
+	router.Use(stats.Middleware())
+	router.GET("/metrics", gin.WrapH(stats.Handler()))
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
 	doc := router.Group("/doc")
 	router.LoadHTMLGlob(*s + "/*.html")
 	v1 := router.Group(fmt.Sprintf("%s", *r))
+	// identify users on public routes so they see their own custom foods
+	v1.Use(auth.Optional(userMiddleware))
 	{
+		// admin routes are open to users whose role grants their permission
 		ag := v1.Group("/")
-		ag.Use(authMiddleware.MiddlewareFunc())
-		v1.POST("/login", authMiddleware.LoginHandler)
-		ag.PUT("/user", userAdd)
-		ag.DELETE("/user/:id", userDelete)
-		ag.GET("/user/:id", userList)
-		ag.GET("/users", userList)
-		v1.GET("/nutrients/food/:id", nutrientFdcID)
-		v1.GET("/nutrients/foods", nutrientFdcIDs)
-		v1.GET("/food/:id", foodFdcID)
-		v1.GET("/foods", foodFdcIds)
-		v1.GET("/foods/browse", foodsBrowse)
-		v1.GET("/foods/search", foodsSearchGet)
-		v1.POST("/foods/search", foodsSearchPost)
-		v1.GET("/foods/count/:doctype", countsGet)
-		v1.GET("/dictionary/:type", dictionaryBrowse)
+		ag.Use(userMiddleware.MiddlewareFunc(), limiter("admin"))
+		ug := v1.Group("/custom")
+		ug.Use(userMiddleware.MiddlewareFunc(), limiter("user"))
+		dg := v1.Group("/diary")
+		dg.Use(userMiddleware.MiddlewareFunc(), limiter("user"))
+		lg := v1.Group("/lists")
+		lg.Use(userMiddleware.MiddlewareFunc(), limiter("user"))
+		mg := v1.Group("/me")
+		mg.Use(userMiddleware.MiddlewareFunc(), limiter("user"))
+		// read endpoints identify partner applications by API key
+		rg := v1.Group("/")
+		rg.Use(auth.APIKeyMiddleware(dc, cs.APIKeys), limiter("read"))
+		v1.POST("/login", limiter("login"), userMiddleware.LoginHandler)
+		v1.GET("/refresh", limiter("login"), userMiddleware.RefreshHandler)
+		if sso != nil {
+			v1.GET("/oidc/login", limiter("login"), sso.LoginHandler)
+			v1.GET("/oidc/callback", limiter("login"), sso.CallbackHandler)
+		}
+		v1.POST("/password/forgot", limiter("login"), passwordForgotPost)
+		v1.POST("/password/reset", limiter("login"), passwordResetPost)
+		mg.GET("", meGet)
+		mg.PUT("", meUpdate)
+		mg.PUT("/password", mePassword)
+		ag.PUT("/user", roles.Permit(auth.USERSWRITE), userAdd)
+		ag.DELETE("/user/:id", roles.Permit(auth.USERSWRITE), userDelete)
+		ag.GET("/user/:id", roles.Permit(auth.USERSREAD), userList)
+		ag.GET("/users", roles.Permit(auth.USERSREAD), userList)
+		ag.GET("/audit", roles.Permit(auth.AUDITREAD), auditList)
+		ag.GET("/roles", roles.Permit(auth.USERSREAD), rolesList)
+		ag.GET("/roles/:name", roles.Permit(auth.USERSREAD), roleGet)
+		ag.PUT("/roles/:name", roles.Permit(auth.USERSWRITE), roleSave)
+		ag.DELETE("/roles/:name", roles.Permit(auth.USERSWRITE), roleDelete)
+		ag.POST("/foods/ingredients/tokenize", roles.Permit(auth.FOODSWRITE), foodsTokenize)
+		ag.PUT("/food/:id", roles.Permit(auth.FOODSWRITE), foodUpdate)
+		ag.POST("/apikeys", roles.Permit(auth.USERSWRITE), apiKeyAdd)
+		ag.GET("/apikeys", roles.Permit(auth.USERSREAD), apiKeysList)
+		ag.DELETE("/apikeys/:id", roles.Permit(auth.USERSWRITE), apiKeyRevoke)
+		ug.GET("/foods", customList(fdc.CUSTOM))
+		ug.POST("/foods", customSave(fdc.CUSTOM))
+		ug.GET("/foods/:id", customGet(fdc.CUSTOM))
+		ug.PUT("/foods/:id", customSave(fdc.CUSTOM))
+		ug.DELETE("/foods/:id", customDelete(fdc.CUSTOM))
+		ug.GET("/recipes", customList(fdc.RECIPE))
+		ug.POST("/recipes", customSave(fdc.RECIPE))
+		ug.GET("/recipes/:id", customGet(fdc.RECIPE))
+		ug.PUT("/recipes/:id", customSave(fdc.RECIPE))
+		ug.DELETE("/recipes/:id", customDelete(fdc.RECIPE))
+		dg.GET("", diaryGet)
+		dg.POST("", diaryAdd)
+		dg.DELETE("/:id", diaryDelete)
+		rg.GET("/diary/profiles", auth.Scope(auth.DICTIONARYSCOPE), diaryProfiles)
+		lg.GET("", listsGet)
+		lg.POST("", listAdd)
+		lg.PUT("/:id", listUpdate)
+		lg.DELETE("/:id", listDelete)
+		lg.POST("/:id/items", listItemsAdd)
+		lg.PUT("/:id/items", listReorder)
+		lg.DELETE("/:id/items/:item", listItemRemove)
+		rg.GET("/lists/:id", auth.Scope(auth.FOODSCOPE), listGet)
+		rg.GET("/lists/:id/nutrients", auth.Scope(auth.NUTRIENTSCOPE), listNutrients)
+		rg.GET("/lists/:id/csv", auth.Scope(auth.FOODSCOPE), listCSV)
+		rg.GET("/nutrients/food/:id", auth.Scope(auth.NUTRIENTSCOPE), nutrientFdcID)
+		rg.GET("/nutrients/foods", auth.Scope(auth.NUTRIENTSCOPE), nutrientFdcIDs)
+		rg.GET("/food/:id", auth.Scope(auth.FOODSCOPE), foodFdcID)
+		rg.GET("/food/:id/allergens", auth.Scope(auth.FOODSCOPE), foodAllergens)
+		rg.GET("/food/:id/ingredients/expanded", auth.Scope(auth.FOODSCOPE), foodIngredientsExpanded)
+		rg.GET("/diets", auth.Scope(auth.FOODSCOPE), dietList)
+		rg.GET("/crosswalk", auth.Scope(auth.FOODSCOPE), crosswalkGet)
+		rg.GET("/foods", auth.Scope(auth.FOODSCOPE), foodFdcIds)
+		rg.GET("/foods/browse", auth.Scope(auth.FOODSCOPE), foodsBrowse)
+		rg.GET("/foods/search", auth.Scope(auth.FOODSCOPE), foodsSearchGet)
+		rg.GET("/foods/suggest", auth.Scope(auth.FOODSCOPE), foodsSuggest)
+		rg.POST("/foods/search", auth.Scope(auth.FOODSCOPE), foodsSearchPost)
+		rg.GET("/foods/count/:doctype", auth.Scope(auth.DICTIONARYSCOPE), countsGet)
+		rg.GET("/dictionary/:type", auth.Scope(auth.DICTIONARYSCOPE), dictionaryBrowse)
 		v1.GET("/docs/:type", specDoc)
-		v1.POST("/nutrients/report", nutrientReportPost)
+		rg.POST("/nutrients/report", auth.Scope(auth.NUTRIENTSCOPE), nutrientReportPost)
 	}
+	router.GET("/.well-known/jwks.json", jwks)
 	doc.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "apiDoc.html", nil)
 	})
 	endless.ListenAndServe(":"+*p, router)
+	// export the spans still buffered
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := traces.Shutdown(ctx); err != nil {
+		logging.Error("cannot export the last spans", logging.Fields{"error": err})
+	}
 }
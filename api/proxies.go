@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxies are the networks of trusted reverse proxies
+type proxies []*net.IPNet
+
+// parseProxies parses a list of addresses and CIDR ranges
+func parseProxies(list []string) (proxies, error) {
+	var p proxies
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %s is not an IP address or CIDR range", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			p = append(p, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %s is not an IP address or CIDR range", s)
+		}
+		p = append(p, n)
+	}
+	return p, nil
+}
+
+// trusts reports whether addr is the address of a trusted proxy
+func (p proxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the address of the client of a request from the given peer
+// with an X-Forwarded-For header.  Forwarded addresses are believed only
+// while they were added by trusted proxies, so the client is the rightmost
+// address which isn't one.
+func (p proxies) client(peer string, forwarded string) string {
+	if !p.trusts(peer) || forwarded == "" {
+		return peer
+	}
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		peer = hop
+		if !p.trusts(hop) {
+			break
+		}
+	}
+	return peer
+}
+
+// realIP sets the remote address of each request to its client's so that
+// rate limits, logs, traces and the audit trail see the client rather than
+// a proxy.  The router must not believe forwarding headers itself.
+func realIP(p proxies) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if peer, _, err := net.SplitHostPort(c.Request.RemoteAddr); err == nil {
+			if ip := p.client(peer, c.GetHeader("X-Forwarded-For")); ip != peer {
+				c.Request.RemoteAddr = net.JoinHostPort(ip, "0")
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/audit"
+	"github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/gtin"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// rolesList returns every role and the permissions which may be granted
+func rolesList(c *gin.Context) {
+	items, err := roles.In(store(c)).List()
+	if err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": items, "permissions": auth.Permissions})
+}
+
+// roleGet returns a role
+func roleGet(c *gin.Context) {
+	r, ok := roles.In(store(c)).Get(c.Param("name"))
+	if !ok {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "Role not found"})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// roleSave creates or replaces a role.  Names are upper case.
+func roleSave(c *gin.Context) {
+	var r auth.Role
+	if err := c.BindJSON(&r); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	r.Name = strings.ToUpper(c.Param("name"))
+	rs := roles.In(store(c))
+	before, _ := rs.Get(r.Name)
+	if !covered(c, r.Permissions) || !covered(c, before.Permissions) {
+		errorout(c, http.StatusForbidden, gin.H{"status": http.StatusForbidden, "message": "A role can only grant permissions you hold"})
+		return
+	}
+	r, err := rs.Save(r)
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	record(c, audit.UPDATE, r.ID, before, r)
+	c.JSON(http.StatusOK, r)
+}
+
+// roleDelete removes a stored role.  Roles assigned to users can't be
+// removed unless they are default roles, which revert to their defaults.
+func roleDelete(c *gin.Context) {
+	var (
+		dt    fdc.DocType
+		users []interface{}
+	)
+	name := c.Param("name")
+	if _, ok := auth.DefaultRoles[name]; !ok {
+		q := fmt.Sprintf("SELECT RAW META().id FROM %s WHERE type=\"%s\" AND role=%s LIMIT 1", cs.CouchDb.Bucket, dt.ToString(fdc.USER), fdc.Quote(name))
+		if err := store(c).Query(q, &users); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+			return
+		}
+		if len(users) > 0 {
+			errorout(c, http.StatusConflict, gin.H{"status": http.StatusConflict, "message": fmt.Sprintf("Role %s is assigned to users", name)})
+			return
+		}
+	}
+	rs := roles.In(store(c))
+	before, _ := rs.Get(name)
+	if !covered(c, before.Permissions) {
+		errorout(c, http.StatusForbidden, gin.H{"status": http.StatusForbidden, "message": "A role can only grant permissions you hold"})
+		return
+	}
+	if err := rs.Delete(name); err != nil {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "Role not found"})
+		return
+	}
+	record(c, audit.DELETE, auth.RoleID(name), before, nil)
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": fmt.Sprintf("Role %s deleted", name)})
+}
+
+// covered returns true if the current user's role has been granted every one
+// of perms
+func covered(c *gin.Context, perms []string) bool {
+	u, ok := auth.CurrentUser(c)
+	return ok && roles.In(store(c)).Covers(u.Role, perms)
+}
+
+// grantable returns true if the current user may assign role, which is so
+// for roles granting nothing beyond the current user's own
+func grantable(c *gin.Context, role string) bool {
+	u, ok := auth.CurrentUser(c)
+	return ok && roles.In(store(c)).Grants(u.Role, role)
+}
+
+// foodUpdate curates the description, ingredients, company or upc of a
+// FOOD.  The fields derived from them are recomputed.
+func foodUpdate(c *gin.Context) {
+	var (
+		f  fdc.Food
+		er fdc.FoodEditRequest
+		dt fdc.DocType
+	)
+	if err := c.BindJSON(&er); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
+		return
+	}
+	id := c.Param("id")
+	if err := store(c).Get(id, &f); err != nil || f.Type != dt.ToString(fdc.FOOD) {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No food found!"})
+		return
+	}
+	if er.Upc != nil && *er.Upc != "" {
+		if _, err := gtin.Normalize(*er.Upc); err != nil {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+			return
+		}
+	}
+	editFood(&f, er)
+	enrich(store(c), &f)
+	if err := audited(c).Update(id, f); err != nil {
+		logging.From(c).Error("cannot update a food", logging.Fields{"id": id, "error": err})
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot update food %s", id)})
+		return
+	}
+	c.JSON(http.StatusOK, f)
+}
+
+// editFood applies an edit to f and clears the fields derived from those
+// which changed
+func editFood(f *fdc.Food, er fdc.FoodEditRequest) {
+	if er.Description != nil && *er.Description != "" {
+		f.Description = *er.Description
+	}
+	if er.Manufacturer != nil {
+		f.Manufacturer = *er.Manufacturer
+	}
+	if er.Ingredients != nil && *er.Ingredients != f.Ingredients {
+		f.Ingredients = *er.Ingredients
+		f.IngredientTokens, f.Allergens, f.MayContain, f.Diets = nil, nil, nil, nil
+	}
+	if er.Upc != nil && *er.Upc != f.Upc {
+		f.Upc, f.Gtin = *er.Upc, ""
+	}
+	f.UpdatedAt = time.Now().UTC()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestEditFood(t *testing.T) {
+	ingredients := "WATER, SUGAR"
+	blank := ""
+	f := fdc.Food{Description: "SODA", Ingredients: "WATER", Upc: "012345678905", Gtin: "00012345678905",
+		IngredientTokens: []string{"water"}, Allergens: []string{}, Diets: []string{"vegan"}}
+	editFood(&f, fdc.FoodEditRequest{Description: &blank, Ingredients: &ingredients})
+	if f.Description != "SODA" {
+		t.Errorf("blank description replaced %s", f.Description)
+	}
+	if f.Ingredients != ingredients || f.IngredientTokens != nil || f.Allergens != nil || f.Diets != nil {
+		t.Errorf("derived fields kept after an ingredients change: %+v", f)
+	}
+	if f.Gtin == "" {
+		t.Error("gtin cleared without a upc change")
+	}
+	upc := "036000291452"
+	editFood(&f, fdc.FoodEditRequest{Upc: &upc})
+	if f.Upc != upc || f.Gtin != "" {
+		t.Errorf("got upc %s gtin %s", f.Upc, f.Gtin)
+	}
+}
@@ -5,19 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"regexp"
+	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/allergen"
 	auth "github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/diet"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/gtin"
+	"github.com/prLorence/fdc-api/ingredient"
+	"github.com/prLorence/fdc-api/logging"
 	fdc "github.com/prLorence/fdc-api/model"
+	"github.com/prLorence/fdc-api/recipe"
 )
 
-var isUpc = regexp.MustCompile(`^[0-9]+$`)
-
 func countsGet(c *gin.Context) {
 	var counts []interface{}
 	t := c.Param("doctype")
@@ -27,7 +31,7 @@ func countsGet(c *gin.Context) {
 			return
 		}
 	}
-	if err := dc.Counts(cs.CouchDb.Bucket, t, &counts); err != nil {
+	if err := store(c).Counts(cs.CouchDb.Bucket, t, &counts); err != nil {
 		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No counts found!"})
 		return
 	}
@@ -52,19 +56,162 @@ func foodFdcID(c *gin.Context) {
 		return
 	}
 	// convert anything that looks a upc to an fdcId
-	if len(q) > 7 {
-		q, _ = upcTofdcid(q, cs.CouchDb.Bucket)
-	}
-	err := dc.Get(q, &f)
+	q, err := resolveID(store(c), q)
 	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	err = store(c).Get(q, &f)
+	if err != nil || !canSee(c, &f) {
 		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No food found!"})
+		return
 	}
+	enrich(store(c), &f)
 	items = append(items, f)
 	results := fdc.BrowseResult{Count: 1, Start: 0, Max: 1, Items: items}
 	c.JSON(http.StatusOK, results)
 	return
 }
 
+// dietList returns the dietary classification rules
+func dietList(c *gin.Context) {
+	c.JSON(http.StatusOK, diets)
+}
+
+// crosswalkGet maps a list of up to 24 food ids in any scheme to the
+// fdcIds, SR NDB numbers, FNDDS food codes and GTINs of the foods and of
+// their related SR or FNDDS foods
+func crosswalkGet(c *gin.Context) {
+	var cw []fdc.Crosswalk
+	ids := c.QueryArray("id")
+	if len(ids) == 0 || len(ids) > 24 {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "between 1 and 24 id's are required"})
+		return
+	}
+	for _, id := range ids {
+		var f fdc.Food
+		fdcID, err := resolveID(store(c), id)
+		if err != nil {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+			return
+		}
+		if fdcID == "" || store(c).Get(fdcID, &f) != nil || !canSee(c, &f) {
+			continue
+		}
+		x := crosswalk(&f)
+		x.ID = id
+		if x.Related, err = relatedFoods(store(c), &f); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+			return
+		}
+		cw = append(cw, x)
+	}
+	if len(cw) == 0 {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No foods found!"})
+		return
+	}
+	c.JSON(http.StatusOK, cw)
+}
+
+// foodIngredientsExpanded returns the input foods of an FNDDS food, expanded
+// recursively through input foods which are themselves recipes, with the
+// amount of each nutrient they contribute.  An optional n parameter limits
+// the nutrients to those numbered.
+func foodIngredientsExpanded(c *gin.Context) {
+	var (
+		f  fdc.Food
+		ns []int
+	)
+	q, err := resolveID(store(c), c.Param("id"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	for _, n := range c.QueryArray("n") {
+		no, err := strconv.Atoi(n)
+		if err != nil {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("%s is not a nutrient number", n)})
+			return
+		}
+		ns = append(ns, no)
+	}
+	if err = store(c).Get(q, &f); err != nil || !canSee(c, &f) {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No food found!"})
+		return
+	}
+	if len(f.InputFoods) == 0 {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": fmt.Sprintf("Food %s has no input foods", f.FdcID)})
+		return
+	}
+	x, err := recipe.Expand(&f, dsRecipes{store(c)}, ns)
+	if err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot expand food %s %v", f.FdcID, err)})
+		return
+	}
+	c.JSON(http.StatusOK, x)
+}
+
+// foodAllergens returns the allergens of a food identified by fdcId or upc
+// with the ingredients and statements which triggered each one
+func foodAllergens(c *gin.Context) {
+	var f fdc.Food
+	q, err := resolveID(store(c), c.Param("id"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = store(c).Get(q, &f); err != nil || !canSee(c, &f) {
+		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "No food found!"})
+		return
+	}
+	c.JSON(http.StatusOK, allergen.Detect(&f))
+}
+
+// foodsTokenize parses the ingredient statements of foods which have no
+// ingredient tokens, allergens or diets and saves the tokens, allergens and
+// dietary classifications along with the normalized GTIN of foods which
+// lack one.  Up to max foods are updated per request.  Foods which gain
+// nothing, e.g. whose statements yield no ingredients, are skipped.
+func foodsTokenize(c *gin.Context) {
+	var (
+		dt  fdc.DocType
+		ids []interface{}
+		max int
+		err error
+	)
+	if max, err = strconv.Atoi(c.Query("max")); err != nil || max <= 0 || max > maxListSize {
+		max = maxListSize
+	}
+	q := fmt.Sprintf("SELECT RAW META().id FROM %s WHERE type=\"%s\" AND ((ingredients IS VALUED AND (ingredientTokens IS MISSING OR allergens IS NOT VALUED OR diets IS NOT VALUED)) OR (upc IS VALUED AND gtin IS MISSING)) LIMIT %d", cs.CouchDb.Bucket, dt.ToString(fdc.FOOD), max)
+	if err = store(c).Query(q, &ids); err != nil {
+		errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Query error %v", err)})
+		return
+	}
+	count, skipped := 0, 0
+	for _, id := range ids {
+		var f fdc.Food
+		key, ok := id.(string)
+		if !ok {
+			continue
+		}
+		if err = store(c).Get(key, &f); err != nil {
+			logging.From(c).Warn("cannot get a food to tokenize", logging.Fields{"id": key, "error": err})
+			continue
+		}
+		before := f
+		if enrich(store(c), &f); reflect.DeepEqual(before, f) {
+			skipped++
+			continue
+		}
+		if err = audited(c).Update(key, f); err != nil {
+			errorout(c, http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": fmt.Sprintf("Cannot update food %s %v", key, err)})
+			return
+		}
+		count++
+	}
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "count": count, "skipped": skipped})
+}
+
 // returns foods in a BrowseResult for a list of fdcIds or upcs.  If an id looks like a upc it is converted
 // to a fdcId.
 func foodFdcIds(c *gin.Context) {
@@ -72,13 +219,18 @@ func foodFdcIds(c *gin.Context) {
 		dt fdc.DocType
 		f  []interface{}
 	)
-	qids, err := buildIDList(getFdcIDs(c.QueryArray("id")))
+	ids, err := getFdcIDs(store(c), c.QueryArray("id"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	qids, err := buildIDList(ids)
 	if err != nil {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Cannot request more than 24 id's"})
 		return
 	}
-	q := fmt.Sprintf("SELECT * from %s WHERE type=\"%s\" AND fdcId in %s", cs.CouchDb.Bucket, dt.ToString(fdc.FOOD), qids)
-	dc.Query(q, &f)
+	q := fmt.Sprintf("SELECT * from %s WHERE type IN [\"%s\",\"%s\",\"%s\"] AND fdcId in %s%s", cs.CouchDb.Bucket, dt.ToString(fdc.FOOD), dt.ToString(fdc.CUSTOM), dt.ToString(fdc.RECIPE), qids, ownerWhere(c))
+	store(c).Query(q, &f)
 	results := fdc.BrowseResult{Count: int32(len(f)), Start: 0, Max: int32(len(f)), Items: f}
 	c.JSON(http.StatusOK, results)
 
@@ -91,6 +243,7 @@ func dictionaryBrowse(c *gin.Context) {
 		dt        fdc.DocType
 		t         string
 		max, page int64
+		err       error
 	)
 	t = c.Param("type")
 	if t == "" {
@@ -110,7 +263,7 @@ func dictionaryBrowse(c *gin.Context) {
 		page = 0
 	}
 	offset := page * max
-	items, err := dc.GetDictionary(cs.CouchDb.Bucket, t, offset, max)
+	items, err := store(c).GetDictionary(cs.CouchDb.Bucket, t, offset, max)
 	if err != nil {
 		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "Error."})
 		return
@@ -131,6 +284,7 @@ func nutrientFdcID(c *gin.Context) {
 		ndb []fdc.NutrientFoodBrowseItem
 		ndi fdc.NutrientFoodBrowseItem
 		ndd fdc.NutrientFoodBrowse
+		err error
 	)
 
 	if q = c.Param("id"); q == "" {
@@ -138,8 +292,9 @@ func nutrientFdcID(c *gin.Context) {
 		return
 	}
 	// replace UPC with fdcId
-	if len(q) > 7 {
-		q, _ = upcTofdcid(q, cs.CouchDb.Bucket)
+	if q, err = resolveID(store(c), q); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
 	}
 	// build query for one or more nutrient #'s otherwise build a query to return all nutrients
 	if n := c.QueryArray("n"); len(n) > 0 {
@@ -149,11 +304,11 @@ func nutrientFdcID(c *gin.Context) {
 			nids = append(nids, fmt.Sprintf("%s_%s", q, n[i]))
 		}
 		qids, _ := buildIDList(nids)
-		q = fmt.Sprintf("SELECT fdcId,upc,portion,portionValue as valuePerPortion,foodDescription,company,category,valuePer100UnitServing,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND meta(nutrient).id in %s", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), qids)
+		q = fmt.Sprintf("SELECT fdcId,upc,portion,portionValue as valuePerPortion,foodDescription,company,category,valuePer100UnitServing,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND meta(nutrient).id in %s%s", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), qids, ownerWhere(c))
 	} else {
-		q = fmt.Sprintf("SELECT fdcId,upc,portion,portionValue as valuePerPortion,foodDescription,company,category,valuePer100UnitServing,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND fdcId = \"%s\"", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), q)
+		q = fmt.Sprintf("SELECT fdcId,upc,portion,portionValue as valuePerPortion,foodDescription,company,category,valuePer100UnitServing,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND fdcId = %s%s", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), fdc.Quote(q), ownerWhere(c))
 	}
-	dc.Query(q, &nd)
+	store(c).Query(q, &nd)
 	haveFood := false
 	for i := range nd {
 		b, _ := json.Marshal(nd[i])
@@ -175,6 +330,28 @@ func nutrientFdcID(c *gin.Context) {
 // if an optional n parameter is provided then limit nutrients returned to the
 // nutrientno in the n paramter
 func nutrientFdcIDs(c *gin.Context) {
+	// replace any UPC's with FdcID's
+	ids, err := getFdcIDs(store(c), c.QueryArray("id"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if len(ids) > maxNutrientFoods {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Cannot request more than %d id's", maxNutrientFoods)})
+		return
+	}
+	nfbs, err := foodsNutrients(c, ids, c.QueryArray("n"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nfbs)
+	return
+}
+
+// foodsNutrients returns the nutrient data of a list of foods ordered by
+// fdcId.  If n lists nutrient numbers only those nutrients are returned.
+func foodsNutrients(c *gin.Context, ids []string, n []string) ([]fdc.NutrientFoodBrowse, error) {
 	var (
 		q       string
 		dt      fdc.DocType
@@ -184,28 +361,22 @@ func nutrientFdcIDs(c *gin.Context) {
 		nfb, nf fdc.NutrientFoodBrowse
 		nfbs    []fdc.NutrientFoodBrowse
 	)
-	// replace any UPC's with FdcID's
-	ids := getFdcIDs(c.QueryArray("id"))
-	if err != nil {
-		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Cannot request more than 24 id's"})
-		return
-	}
 	// create nutrient data ids
-	if n := c.QueryArray("n"); len(n) > 0 {
+	if len(n) > 0 {
 		var nids []string
 		for id := range ids {
 			for i := range n {
 				nids = append(nids, fmt.Sprintf("%s_%s", ids[id], n[i]))
 			}
 		}
-		qids, _ := buildIDList(nids)
-		q = fmt.Sprintf("SELECT fdcId,upc,servingSizes,foodDescription,company,category,derivation,valuePer100UnitServing,portion,portionValue as valuePerPortion,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND meta(nutrient).id in %s order by fdcId", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), qids)
+		q = fmt.Sprintf("SELECT fdcId,upc,servingSizes,foodDescription,company,category,derivation,valuePer100UnitServing,portion,portionValue as valuePerPortion,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND meta(nutrient).id in %s%s order by fdcId", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), idList(nids), ownerWhere(c))
 
 	} else {
-		qids, _ := buildIDList(ids)
-		q = fmt.Sprintf("SELECT fdcId,upc,servingSizes,foodDescription,company,category,derivation,valuePer100UnitServing,portion,portionValue as valuePerPortion,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND fdcId in %s order by fdcId", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), qids)
+		q = fmt.Sprintf("SELECT fdcId,upc,servingSizes,foodDescription,company,category,derivation,valuePer100UnitServing,portion,portionValue as valuePerPortion,unit,nutrientNumber,nutrientName from %s as nutrient WHERE type=\"%s\" AND fdcId in %s%s order by fdcId", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), idList(ids), ownerWhere(c))
+	}
+	if err := store(c).Query(q, &nd); err != nil {
+		return nil, err
 	}
-	dc.Query(q, &nd)
 	// convert each row to the types NutrientFoodBrowse and NutrientFoodBrowseItem
 	for i := range nd {
 		b, _ := json.Marshal(nd[i])
@@ -238,8 +409,7 @@ func nutrientFdcIDs(c *gin.Context) {
 	}
 	nfb.Nutrients = ndb
 	nfbs = append(nfbs, nfb)
-	c.JSON(http.StatusOK, nfbs)
-	return
+	return nfbs, nil
 }
 
 // foodsBrowse returns a BrowseResult
@@ -288,13 +458,31 @@ func foodsBrowse(c *gin.Context) {
 		if i, err := strconv.ParseInt(fg, 0, 32); err == nil {
 			where += fmt.Sprintf(" AND foodGroup.id=%d", i)
 		} else {
-			where += fmt.Sprintf(" AND foodGroup.description=\"%s\"", fg)
+			where += fmt.Sprintf(" AND foodGroup.description=%s", fdc.Quote(fg))
 		}
 	}
 	if source != "" {
 		where = where + sourceFilter(source)
 	}
-	foods, err := dc.Browse(cs.CouchDb.Bucket, where, offset, max, sort, order)
+	include, exclude := ingredientParams(c)
+	if len(include)+len(exclude) > maxIngredientFilters {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Cannot filter on more than %d ingredients", maxIngredientFilters)})
+		return
+	}
+	where += ingredientFilter(include, exclude)
+	free, err := checkAllergens(listParam(c, "allergenFree"))
+	if err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	where += allergenFilter(free)
+	d := listParam(c, "diet")
+	if err = checkDiets(d); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	where += dietFilter(d)
+	foods, err := store(c).Browse(cs.CouchDb.Bucket, where, offset, max, sort, order)
 	if err != nil {
 		errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": fmt.Sprintf("Query error %v", err)})
 		return
@@ -329,8 +517,64 @@ func foodsSearchGet(c *gin.Context) {
 		page = 0
 	}
 	offset := page * max
-
-	results, err := search(fdc.SearchRequest{Query: q, IndexName: cs.CouchDb.Fts, Max: max, Page: offset})
+	sr := fdc.SearchRequest{Query: q, IndexName: cs.CouchDb.Fts, Max: max, Page: offset, SearchType: strings.ToUpper(c.Query("searchtype"))}
+	if sr.SearchType == fdc.FUZZY {
+		sr.Fuzziness, _ = strconv.Atoi(c.Query("fuzziness"))
+		sr.PrefixLength, _ = strconv.Atoi(c.Query("prefixLength"))
+	}
+	if err = checkFuzzy(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	// field boosts for COMPOUND searches are given as boost=company:2
+	for _, b := range c.QueryArray("boost") {
+		fb := strings.SplitN(b, ":", 2)
+		v, err := strconv.ParseFloat(fb[len(fb)-1], 32)
+		if len(fb) != 2 || err != nil {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("boost parameter %s must be of the form field:number", b)})
+			return
+		}
+		if sr.Boosts == nil {
+			sr.Boosts = make(map[string]float32)
+		}
+		sr.Boosts[fb[0]] = float32(v)
+	}
+	if err = checkClauses(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	// facets and filters are given as facet=company and filter=company:value
+	for _, f := range c.QueryArray("facet") {
+		sr.Facets = append(sr.Facets, fdc.FacetRequest{Name: f})
+	}
+	for _, f := range c.QueryArray("filter") {
+		nv := strings.SplitN(f, ":", 2)
+		if len(nv) != 2 {
+			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("filter parameter %s must be of the form facet:value", f)})
+			return
+		}
+		if sr.Filters == nil {
+			sr.Filters = make(map[string][]string)
+		}
+		sr.Filters[nv[0]] = append(sr.Filters[nv[0]], nv[1])
+	}
+	if err = checkFacets(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	sr.Include, sr.Exclude = ingredientParams(c)
+	sr.AllergenFree = listParam(c, "allergenFree")
+	sr.Diets = listParam(c, "diet")
+	if err = checkIngredients(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = checkDiets(sr.Diets); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	sr.Owner = owner(c)
+	results, err := search(store(c), sr)
 	if err != nil {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Search query failed %v", err)})
 		return
@@ -347,8 +591,8 @@ func foodsSearchPost(c *gin.Context) {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Invalid JSON in request: %v", err)})
 		return
 	}
-	if sr.Query == "" {
-		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Search query is required."})
+	if sr.Query == "" && len(sr.Clauses) == 0 {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Search query or clauses are required."})
 		return
 	}
 	if sr.Max == 0 {
@@ -360,13 +604,34 @@ func foodsSearchPost(c *gin.Context) {
 	if sr.Page < 0 {
 		sr.Page = 0
 	}
+	if err = checkFacets(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = checkFuzzy(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = checkClauses(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = checkIngredients(&sr); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = checkDiets(sr.Diets); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
 	// only run REGEX searches against a keyword index
 	if sr.SearchType == fdc.REGEX {
 		sr.SearchField += "_kw"
 	}
 	sr.Page = sr.Page * sr.Max
 	sr.IndexName = cs.CouchDb.Fts
-	results, err := search(sr)
+	sr.Owner = owner(c)
+	results, err := search(store(c), sr)
 	if err != nil {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Search query failed %v", err)})
 		return
@@ -374,6 +639,46 @@ func foodsSearchPost(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// foodsSuggest returns typeahead completions for a partial food description,
+// company or ingredient
+func foodsSuggest(c *gin.Context) {
+	var (
+		max int
+		err error
+		s   []fdc.Suggestion
+	)
+	q := c.Query("q")
+	if q == "" {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "A search string in the q parameter is required"})
+		return
+	}
+	if max, err = strconv.Atoi(c.Query("max")); err != nil || max <= 0 {
+		max = defaultSuggestMax
+	}
+	if max > maxSuggestSize {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("max parameter %d exceeds maximum allowed size of %d", max, maxSuggestSize)})
+		return
+	}
+	f := c.Query("field")
+	if f != "" && f != fdc.DESCRIPTION && f != fdc.COMPANY && f != fdc.INGREDIENTS {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Unrecognized field parameter.  Must be %s, %s or %s", fdc.DESCRIPTION, fdc.COMPANY, fdc.INGREDIENTS)})
+		return
+	}
+	if err = store(c).Suggest(fdc.SuggestRequest{Query: q, Field: f, Max: max, IndexName: cs.CouchDb.Fts}, &s); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Suggest query failed %v", err)})
+		return
+	}
+	if s == nil {
+		s = []fdc.Suggestion{}
+	}
+	c.JSON(http.StatusOK, fdc.SuggestResult{Query: q, Count: int32(len(s)), Items: s})
+}
+
+// jwks publishes the public keys which verify login tokens
+func jwks(c *gin.Context) {
+	c.JSON(http.StatusOK, tokens.JWKS())
+}
+
 // returns openapi spec in either json or yaml format
 func specDoc(c *gin.Context) {
 	t := c.Param("type")
@@ -384,7 +689,7 @@ func specDoc(c *gin.Context) {
 	if t == "yaml" {
 		raw, err := ioutil.ReadFile(YAMLSPEC)
 		if err != nil {
-			log.Println(err.Error())
+			logging.From(c).Error("cannot read the YAML spec", logging.Fields{"error": err})
 			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Cannot retrieve YAML doc"})
 			return
 		}
@@ -392,7 +697,7 @@ func specDoc(c *gin.Context) {
 	} else {
 		raw, err := ioutil.ReadFile(JSONSPEC)
 		if err != nil {
-			log.Println(err.Error())
+			logging.From(c).Error("cannot read the JSON spec", logging.Fields{"error": err})
 			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Cannot retrieve JSON doc"})
 			return
 		}
@@ -402,19 +707,190 @@ func specDoc(c *gin.Context) {
 }
 
 // search performs a SearchRequest on a datastore search and returns the result
-func search(sr fdc.SearchRequest) (fdc.BrowseResult, error) {
+func search(d ds.DataSource, sr fdc.SearchRequest) (fdc.BrowseResult, error) {
 	var (
 		r   []interface{}
+		f   []fdc.Facet
 		err error
 	)
 	count := 0
-	if count, err = dc.Search(sr, &r); err != nil {
+	if count, err = d.Search(sr, &r, &f); err != nil {
 		return fdc.BrowseResult{}, err
 	}
-	results := fdc.BrowseResult{Count: int32(count), Start: int32(sr.Page), Max: int32(sr.Max), Items: r}
+	// the user's own foods which match lead the first page
+	if sr.Page == 0 {
+		custom, err := customMatches(d, sr)
+		if err != nil {
+			return fdc.BrowseResult{}, err
+		}
+		r = append(custom, r...)
+		count += len(custom)
+	}
+	results := fdc.BrowseResult{Count: int32(count), Start: int32(sr.Page), Max: int32(sr.Max), Items: r, Facets: f}
 	return results, nil
 }
 
+// checkFuzzy validates the edit distance and prefix length of a FUZZY
+// SearchRequest and sets the default edit distance
+func checkFuzzy(sr *fdc.SearchRequest) error {
+	if sr.SearchType != fdc.FUZZY {
+		return nil
+	}
+	if sr.Fuzziness == 0 {
+		sr.Fuzziness = defaultFuzziness
+	} else if sr.Fuzziness < 0 || sr.Fuzziness > maxFuzziness {
+		return fmt.Errorf("fuzziness %d must be > 0 or <= %d", sr.Fuzziness, maxFuzziness)
+	}
+	if sr.PrefixLength < 0 {
+		return fmt.Errorf("prefixLength %d must be >= 0", sr.PrefixLength)
+	}
+	return nil
+}
+
+// checkClauses parses the query of a COMPOUND SearchRequest into clauses,
+// validates each clause and applies any field boosts.  A query given along
+// with clauses in a request of another type becomes a MUST clause.
+func checkClauses(sr *fdc.SearchRequest) error {
+	if sr.SearchType == fdc.COMPOUND {
+		c, err := fdc.ParseQuery(sr.Query)
+		if err != nil {
+			return fmt.Errorf("Invalid compound query: %v", err)
+		}
+		sr.Clauses = append(c, sr.Clauses...)
+	} else if len(sr.Clauses) > 0 && sr.Query != "" {
+		sr.Clauses = append([]fdc.SearchClause{{Occur: fdc.MUST, Field: sr.SearchField, Query: sr.Query, SearchType: sr.SearchType}}, sr.Clauses...)
+	}
+	if len(sr.Clauses) == 0 {
+		return nil
+	}
+	if len(sr.Clauses) > maxClauses {
+		return fmt.Errorf("%d clauses exceeds the maximum of %d", len(sr.Clauses), maxClauses)
+	}
+	boosts := make(map[string]float32)
+	for f, b := range sr.Boosts {
+		field, ok := fdc.QueryFields[f]
+		if !ok {
+			return fmt.Errorf("Unrecognized boost field %s", f)
+		}
+		if b <= 0 {
+			return fmt.Errorf("boost %v for %s must be > 0", b, f)
+		}
+		boosts[field] = b
+	}
+	sr.SearchType = fdc.COMPOUND
+	for i := range sr.Clauses {
+		c := &sr.Clauses[i]
+		if c.Query == "" {
+			return errors.New("each clause requires a q")
+		}
+		c.Occur = strings.ToUpper(c.Occur)
+		switch c.Occur {
+		case "":
+			c.Occur = fdc.MUST
+		case fdc.MUST, fdc.SHOULD, fdc.MUSTNOT:
+		default:
+			return fmt.Errorf("Unrecognized occur %s.  Must be %s, %s or %s", c.Occur, fdc.MUST, fdc.SHOULD, fdc.MUSTNOT)
+		}
+		if c.Field != "" {
+			field, ok := fdc.QueryFields[c.Field]
+			if !ok {
+				return fmt.Errorf("Unrecognized clause field %s", c.Field)
+			}
+			c.Field = field
+		}
+		if c.Boost < 0 {
+			return fmt.Errorf("clause boost %v must be >= 0", c.Boost)
+		}
+		if c.Boost == 0 {
+			c.Boost = boosts[c.Field]
+		}
+		c.SearchType = strings.ToUpper(c.SearchType)
+		switch c.SearchType {
+		case "", fdc.PHRASE, fdc.WILDCARD, fdc.PREFIX:
+		case fdc.FUZZY:
+			if sr.Fuzziness == 0 {
+				sr.Fuzziness = defaultFuzziness
+			}
+		case fdc.REGEX:
+			// only run REGEX searches against a keyword index
+			if c.Field == "" {
+				return errors.New("REGEX clauses require a field")
+			}
+			c.Field += "_kw"
+		default:
+			return fmt.Errorf("Unrecognized clause searchtype %s", c.SearchType)
+		}
+	}
+	return nil
+}
+
+// checkIngredients normalizes the ingredients included or excluded by a
+// SearchRequest and the allergens it must be free of
+func checkIngredients(sr *fdc.SearchRequest) error {
+	var err error
+	if len(sr.Include)+len(sr.Exclude) > maxIngredientFilters {
+		return fmt.Errorf("Cannot filter on more than %d ingredients", maxIngredientFilters)
+	}
+	sr.Include = normalizeIngredients(sr.Include)
+	sr.Exclude = normalizeIngredients(sr.Exclude)
+	sr.AllergenFree, err = checkAllergens(sr.AllergenFree)
+	return err
+}
+
+// checkDiets validates a list of dietary classifications
+func checkDiets(names []string) error {
+	for _, name := range names {
+		if _, ok := diets.Find(name); !ok {
+			return fmt.Errorf("Unrecognized diet %s.  Must be one of %s", name, strings.Join(diets.Names(), ", "))
+		}
+	}
+	return nil
+}
+
+// checkAllergens converts a list of allergen names or aliases to allergen
+// names
+func checkAllergens(names []string) ([]string, error) {
+	var a []string
+	for _, name := range names {
+		n, ok := allergen.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("Unrecognized allergen %s.  Must be one of milk, egg, fish, shellfish, treenut, peanut, wheat, soy or sesame", name)
+		}
+		a = append(a, n)
+	}
+	return a, nil
+}
+
+// checkFacets validates the facets and filters of a SearchRequest and sets
+// default facet sizes
+func checkFacets(sr *fdc.SearchRequest) error {
+	for i := range sr.Facets {
+		f := &sr.Facets[i]
+		if _, ok := fdc.FacetFields[f.Name]; !ok && f.Name != fdc.NUTRIENT {
+			return fmt.Errorf("Unrecognized facet %s.  Must be %s, %s, %s, %s or %s", f.Name, fdc.FOODGROUP, fdc.COMPANY, fdc.SOURCE, fdc.COUNTRY, fdc.NUTRIENT)
+		}
+		if f.Size == 0 {
+			f.Size = defaultFacetSize
+		} else if f.Size < 0 || f.Size > maxFacetSize {
+			return fmt.Errorf("facet size %d must be > 0 or <= %d", f.Size, maxFacetSize)
+		}
+		if f.Name == fdc.NUTRIENT && (f.Nutrient <= 0 || len(f.Ranges) == 0) {
+			return errors.New("nutrient facets require a nutrientno and a list of ranges")
+		}
+	}
+	for name := range sr.Filters {
+		if _, ok := fdc.FacetFields[name]; !ok {
+			return fmt.Errorf("Unrecognized filter %s.  Must be %s, %s, %s or %s", name, fdc.FOODGROUP, fdc.COMPANY, fdc.SOURCE, fdc.COUNTRY)
+		}
+	}
+	for _, nf := range sr.NutrientFilters {
+		if nf.Nutrient <= 0 || len(nf.Ranges) == 0 {
+			return errors.New("nutrient filters require a nutrientno and a list of ranges")
+		}
+	}
+	return nil
+}
+
 // nutrientReportPost produces a report of nutrient values and returns a BrowseResult
 func nutrientReportPost(c *gin.Context) {
 	var (
@@ -422,7 +898,7 @@ func nutrientReportPost(c *gin.Context) {
 		nr      fdc.NutrientReportRequest
 	)
 	// check for a query
-	err = c.BindJSON(&nr)
+	err := c.BindJSON(&nr)
 	if err != nil {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err})
 		return
@@ -465,8 +941,9 @@ func nutrientReportPost(c *gin.Context) {
 		return
 	}
 	nr.Page = nr.Page * nr.Max
+	nr.Owner = owner(c)
 
-	if err = dc.NutrientReport(cs.CouchDb.Bucket, nr, &nutdata); err != nil {
+	if err = store(c).NutrientReport(cs.CouchDb.Bucket, nr, &nutdata); err != nil {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Data error %v", err)})
 		return
 	}
@@ -486,20 +963,48 @@ func userAdd(c *gin.Context) {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err})
 		return
 	}
+	if err = auth.CheckUsername(u.Name); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if err = auth.CurrentPolicy().Check(u.Name, u.Password); err != nil {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
 	u.Password, err = auth.HashPassword(u.Password)
 	if err != nil {
-		log.Println(err)
+		logging.From(c).Error("cannot hash a password", logging.Fields{"user": u.Name, "error": err})
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err})
 		return
 	}
 	if u.Role == "" {
 		u.Role = rt.ToString(auth.USER)
 	}
+	if _, ok := roles.In(store(c)).Get(u.Role); !ok {
+		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": fmt.Sprintf("Unknown role %s", u.Role)})
+		return
+	}
 	u.ID = fmt.Sprintf("%s:%s", dt.ToString(fdc.USER), u.Name)
+	var old auth.User
+	exists := store(c).Get(u.ID, &old) == nil
+	if !grantable(c, u.Role) || exists && !grantable(c, old.Role) {
+		errorout(c, http.StatusForbidden, gin.H{"status": http.StatusForbidden, "message": "You can only assign or replace roles which grant no more than yours"})
+		return
+	}
+	// replacing a user sets a new password so their tokens are revoked
+	u.TokenVersion = 0
+	if exists {
+		u.TokenVersion = old.TokenVersion + 1
+	}
 	u.Type = dt.ToString(fdc.USER)
-	err = dc.Update(u.ID, u)
+	// saving a user unlocks the account
+	u.LockedUntil = nil
+	if err = auth.ClearFailures(store(c), u.Name); err != nil {
+		logging.From(c).Error("cannot reset failed logins", logging.Fields{"user": u.Name, "error": err})
+	}
+	err = audited(c).Update(u.ID, u)
 	if err != nil {
-		log.Println(err)
+		logging.From(c).Error("cannot save a user", logging.Fields{"user": u.Name, "error": err})
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err})
 		return
 
@@ -518,31 +1023,38 @@ func userDelete(c *gin.Context) {
 		return
 	}
 	uid := fmt.Sprintf("%s:%s", dt.ToString(fdc.USER), id)
-	err = dc.Remove(uid)
-	if err != nil {
+	var old auth.User
+	if store(c).Get(uid, &old) == nil && !grantable(c, old.Role) {
+		errorout(c, http.StatusForbidden, gin.H{"status": http.StatusForbidden, "message": "You can only remove users whose role grants no more than yours"})
+		return
+	}
+	if err := audited(c).Remove(uid); err != nil {
 		errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "User name name not found"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": fmt.Sprintf("User %s deleted ", id)})
 }
 
-// userList returns one user or a list of users
+// userList returns one user or a list of users without their password
+// hashes
 func userList(c *gin.Context) {
 	var (
-		dt fdc.DocType
-		u  auth.User
+		dt    fdc.DocType
+		u     auth.User
+		items []interface{}
 	)
 	q := c.Param("id")
 	if q != "" {
 		uid := fmt.Sprintf("%s:%s", dt.ToString(fdc.USER), q)
-		if err := dc.Get(uid, &u); err != nil {
+		if err := store(c).Get(uid, &u); err != nil {
 			errorout(c, http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "User name name not found"})
 			return
 		}
+		u.Password = ""
 		c.JSON(http.StatusOK, u)
 	} else {
-		items, err := dc.GetDictionary(cs.CouchDb.Bucket, dt.ToString(fdc.USER), 0, 100)
-		if err != nil {
+		q := fmt.Sprintf("SELECT RAW OBJECT_REMOVE(u, \"password\") FROM %s u WHERE u.type=\"%s\" ORDER BY u.name LIMIT 100", cs.CouchDb.Bucket, dt.ToString(fdc.USER))
+		if err := store(c).Query(q, &items); err != nil {
 			errorout(c, http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "Error."})
 			return
 		}
@@ -567,7 +1079,7 @@ func sourceFilter(s string) string {
 		if s == "BFPD" {
 			w = fmt.Sprintf(" AND ( dataSource = '%s' OR dataSource='%s' )", "LI", "GDSN")
 		} else {
-			w = fmt.Sprintf(" AND dataSource = '%s'", s)
+			w = " AND dataSource = " + fdc.Quote(s)
 		}
 	}
 	return w
@@ -586,42 +1098,290 @@ func sortOrder(o string) (string, error) {
 
 // converts an array of ids to a query string of the form ["12345",23456",...]
 func buildIDList(ids []string) (string, error) {
+	if len(ids) > maxNutrientFoods {
+		return "", fmt.Errorf("Cannot request more than %d id's", maxNutrientFoods)
+	}
+	return idList(ids), nil
+}
+
+// idList returns ids as a N1QL array of strings
+func idList(ids []string) string {
+	return fdc.QuoteList(ids)
+}
+
+// ingredientParams returns the ingredients listed in include and exclude
+// query parameters which may be repeated or comma separated
+func ingredientParams(c *gin.Context) ([]string, []string) {
+	return normalizeIngredients(listParam(c, "include")), normalizeIngredients(listParam(c, "exclude"))
+}
+
+// listParam returns the values of a query parameter which may be repeated
+// or comma separated
+func listParam(c *gin.Context, name string) []string {
+	var l []string
+	for _, v := range c.QueryArray(name) {
+		for _, i := range strings.Split(v, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				l = append(l, i)
+			}
+		}
+	}
+	return l
+}
+
+// normalizeIngredients normalizes a list of ingredient names for comparison
+// with food ingredient tokens and drops any which are empty
+func normalizeIngredients(names []string) []string {
+	var n []string
+	for _, name := range names {
+		if name = ingredient.Normalize(name); name != "" {
+			n = append(n, name)
+		}
+	}
+	return n
+}
+
+// ingredientFilter returns a N1QL predicate selecting foods with an
+// ingredient token containing each of the included ingredients and none of
+// the excluded ones
+func ingredientFilter(include []string, exclude []string) string {
+	var w string
+	contains := "ANY t IN IFMISSINGORNULL(ingredientTokens, []) SATISFIES CONTAINS(\" \" || t || \" \", %s) END"
+	for _, in := range include {
+		w += " AND " + fmt.Sprintf(contains, fdc.Quote(" "+in+" "))
+	}
+	for _, ex := range exclude {
+		w += " AND NOT " + fmt.Sprintf(contains, fdc.Quote(" "+ex+" "))
+	}
+	return w
+}
+
+// allergenFilter returns a N1QL predicate selecting foods which have been
+// checked for allergens and neither contain nor may contain any of the
+// listed allergens
+func allergenFilter(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	l := fdc.QuoteList(names)
+	return fmt.Sprintf(" AND allergens IS VALUED AND NOT ANY a IN allergens SATISFIES a IN %s END AND NOT ANY a IN IFMISSINGORNULL(mayContain, []) SATISFIES a IN %s END", l, l)
+}
+
+// dietFilter returns a N1QL predicate selecting foods classified in all of
+// the listed diets
+func dietFilter(names []string) string {
+	var w string
+	for _, name := range names {
+		w += fmt.Sprintf(" AND ARRAY_CONTAINS(IFMISSINGORNULL(diets, []), %s)", fdc.Quote(name))
+	}
+	return w
+}
+
+// classify sets the dietary classifications of a food from its ingredients
+// and nutrient values
+func classify(d ds.DataSource, f *fdc.Food) error {
+	nd, err := foodNutrients(d, f.FdcID)
+	if err != nil {
+		return err
+	}
+	values := make(map[int]diet.Values)
+	for _, n := range nd {
+		values[int(n.Nutrientno)] = diet.Values{Per100: n.Value, Serving: n.PortionValue, HasServing: n.Portion != ""}
+	}
+	f.Diets = diets.Classify(f, values)
+	return nil
+}
+
+// foodNutrients returns the nutrient data of a food
+func foodNutrients(d ds.DataSource, fdcID string) ([]fdc.NutrientData, error) {
 	var (
-		err  error
-		qids string
+		dt  fdc.DocType
+		nd  []interface{}
+		nds []fdc.NutrientData
 	)
-	if len(ids) > 24 {
-		err = errors.New("Cannot request more than 24 id's")
-	} else {
-		qids = "["
-		for id := range ids {
-			qids += fmt.Sprintf("\"%s\",", ids[id])
+	q := fmt.Sprintf("SELECT nutrientNumber,nutrientName,unit,valuePer100UnitServing,portion,portionValue FROM %s WHERE type=\"%s\" AND fdcId=%s", cs.CouchDb.Bucket, dt.ToString(fdc.NUTDATA), fdc.Quote(fdcID))
+	if err := d.Query(q, &nd); err != nil {
+		return nil, err
+	}
+	for i := range nd {
+		var n fdc.NutrientData
+		b, _ := json.Marshal(nd[i])
+		if err := json.Unmarshal(b, &n); err != nil {
+			return nil, err
+		}
+		nds = append(nds, n)
+	}
+	return nds, nil
+}
+
+// dsRecipes looks up the input foods of recipes and their nutrients in the
+// datastore
+type dsRecipes struct {
+	d ds.DataSource
+}
+
+// Food returns the food with an SR NDB number or FNDDS food code
+func (r dsRecipes) Food(id fdc.FoodID) (*fdc.Food, error) {
+	var f fdc.Food
+	fdcID, err := codeTofdcid(r.d, id, cs.CouchDb.Bucket)
+	if err != nil || fdcID == "" {
+		return nil, err
+	}
+	if err = r.d.Get(fdcID, &f); err != nil {
+		logging.FromStore(r.d).Warn("cannot get an input food", logging.Fields{"id": fdcID, "error": err})
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// Nutrients returns the nutrient data of a food
+func (r dsRecipes) Nutrients(fdcID string) ([]fdc.NutrientData, error) {
+	return foodNutrients(r.d, fdcID)
+}
+
+// enrich adds the data derived from a food which it does not yet have:
+// ingredient tokens, allergens, dietary classifications and a normalized GTIN
+func enrich(d ds.DataSource, f *fdc.Food) {
+	if len(f.IngredientTokens) == 0 {
+		ingredient.Tokenize(f)
+	}
+	if f.Allergens == nil {
+		allergen.Tag(f)
+	}
+	if f.Diets == nil {
+		if err := classify(d, f); err != nil {
+			logging.FromStore(d).Warn("cannot classify a food", logging.Fields{"id": f.FdcID, "error": err})
 		}
-		qids = strings.Trim(qids, ",")
-		qids += "]"
 	}
-	return qids, err
+	if f.Gtin == "" && f.Upc != "" {
+		f.Gtin, _ = gtin.Normalize(f.Upc)
+	}
 }
 
 // convert UPC codes to fdc ids as necessary and return transformed array
-func getFdcIDs(ids []string) []string {
+func getFdcIDs(d ds.DataSource, ids []string) ([]string, error) {
+	var ids2 []string
+	for id := range ids {
+		nid, err := resolveID(d, ids[id])
+		if err != nil {
+			return nil, err
+		}
+		ids2 = append(ids2, nid)
+	}
+	return ids2, nil
+}
+
+// resolveID returns the fdcId of a food identified by fdcId, by a GTIN in
+// any of its UPC-A, EAN-13 or GTIN-14 forms or by a prefixed id such as
+// ndb:01001 or fndds:11111000.  Malformed ids and barcodes with an invalid
+// check digit are an error.
+func resolveID(d ds.DataSource, id string) (string, error) {
+	fid, err := fdc.ParseFoodID(id)
+	if err != nil {
+		return "", err
+	}
+	switch fid.Scheme {
+	case fdc.UPC:
+		code, err := gtin.Normalize(fid.Value)
+		if err != nil {
+			return "", err
+		}
+		return upcTofdcid(d, code, cs.CouchDb.Bucket)
+	case fdc.NDBNO, fdc.FOODCODE:
+		return codeTofdcid(d, fid, cs.CouchDb.Bucket)
+	}
+	return fid.Value, nil
+}
+
+// codeWhere returns the N1QL predicate for foods with an SR NDB number or an
+// FNDDS food code.  Both are stored in the ndbno field of their foods.
+func codeWhere(fid fdc.FoodID) string {
+	if fid.Scheme == fdc.FOODCODE {
+		return fmt.Sprintf("dataSource=\"FNDDS\" AND ndbno=%s", fdc.Quote(fid.Value))
+	}
+	forms, _ := buildIDList(fdc.NdbForms(fid.Value))
+	return fmt.Sprintf("dataSource=\"SR\" AND ndbno IN %s", forms)
+}
+
+// return fdcId from an SR NDB number or FNDDS food code look-up
+func codeTofdcid(d ds.DataSource, fid fdc.FoodID, bucket string) (string, error) {
+	var r []interface{}
+	q := fmt.Sprintf("SELECT RAW fdcId FROM %s WHERE type=\"FOOD\" AND %s", bucket, codeWhere(fid))
+	if err := d.Query(q, &r); err != nil {
+		logging.FromStore(d).Error("cannot look up a food code", logging.Fields{"code": fid.Value, "error": err})
+		return "", err
+	}
+	for i := range r {
+		if id, ok := r[i].(string); ok {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// crosswalk returns the identifiers of a food
+func crosswalk(f *fdc.Food) fdc.Crosswalk {
+	x := fdc.Crosswalk{FdcID: f.FdcID, Source: f.Source, Description: f.Description, Gtin: f.Gtin}
+	switch f.Source {
+	case "SR":
+		x.NdbNo = f.NdbNo
+	case "FNDDS":
+		x.FoodCode = f.NdbNo
+	}
+	if x.Gtin == "" && f.Upc != "" {
+		x.Gtin, _ = gtin.Normalize(f.Upc)
+	}
+	return x
+}
+
+// relatedFoods returns the SR foods used as ingredients by an FNDDS food or
+// the FNDDS foods which use an SR food
+func relatedFoods(d ds.DataSource, f *fdc.Food) ([]fdc.Crosswalk, error) {
 	var (
-		ids2 []string
-		nid  string
+		rf []interface{}
+		w  string
 	)
-	for id := range ids {
-		if len(ids[id]) > 7 && isUpc.MatchString(ids[id]) {
-			nid, _ = upcTofdcid(ids[id], cs.CouchDb.Bucket)
-			ids2 = append(ids2, nid)
-		} else {
-			ids2 = append(ids2, ids[id])
+	switch f.Source {
+	case "FNDDS":
+		var codes []string
+		for _, i := range f.InputFoods {
+			if i.SrCode > 0 {
+				codes = append(codes, fdc.NdbForms(fmt.Sprintf("%05d", i.SrCode))...)
+			}
+		}
+		if len(codes) == 0 {
+			return nil, nil
 		}
+		// list the codes directly since recipes may have more than 24
+		w = fmt.Sprintf("dataSource=\"SR\" AND ndbno IN %s", fdc.QuoteList(codes))
+	case "SR":
+		ndb, err := strconv.Atoi(f.NdbNo)
+		if err != nil {
+			return nil, nil
+		}
+		w = fmt.Sprintf("dataSource=\"FNDDS\" AND ANY i IN inputfoods SATISFIES i.srcode = %d END", ndb)
+	default:
+		return nil, nil
+	}
+	q := fmt.Sprintf("SELECT fdcId,dataSource,foodDescription,ndbno,upc FROM %s WHERE type=\"FOOD\" AND %s LIMIT %d", cs.CouchDb.Bucket, w, maxListSize)
+	if err := d.Query(q, &rf); err != nil {
+		return nil, err
+	}
+	var related []fdc.Crosswalk
+	for i := range rf {
+		var r fdc.Food
+		b, _ := json.Marshal(rf[i])
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, err
+		}
+		related = append(related, crosswalk(&r))
 	}
-	return ids2
+	return related, nil
 }
 
-// return fdcId from UPC look-up
-func upcTofdcid(upc string, bucket string) (string, error) {
+// return fdcId from a look-up of a normalized GTIN.  Foods which have not been
+// given a gtin are matched on any form of their upc.
+func upcTofdcid(d ds.DataSource, upc string, bucket string) (string, error) {
 	type f struct {
 		FdcID string `json:"fdcId" binding:"required"`
 	}
@@ -630,18 +1390,22 @@ func upcTofdcid(upc string, bucket string) (string, error) {
 		fid f
 		j   []byte
 	)
-	q := fmt.Sprintf("SELECT fdcId from %s where upc = \"%s\" AND type=\"FOOD\"", bucket, upc)
-	if err := dc.Query(q, &r); err != nil {
-		log.Printf("%v\n", err)
+	forms, err := gtin.Forms(upc)
+	if err != nil {
+		return "", err
+	}
+	q := fmt.Sprintf("SELECT fdcId from %s where type=\"FOOD\" AND (gtin = %s OR upc IN %s)", bucket, fdc.Quote(upc), fdc.QuoteList(forms))
+	if err := d.Query(q, &r); err != nil {
+		logging.FromStore(d).Error("cannot look up a UPC", logging.Fields{"upc": upc, "error": err})
 		return "", err
 	}
 	for i := range r {
 		if j, err = json.Marshal(r[i]); err != nil {
-			log.Printf("%s %v %v\n", upc, j, err)
+			logging.FromStore(d).Error("cannot encode a UPC look-up", logging.Fields{"upc": upc, "error": err})
 			return "", err
 		}
 		if err = json.Unmarshal(j, &fid); err != nil {
-			log.Printf("%s %s %v\n", upc, string(j), err)
+			logging.FromStore(d).Error("cannot decode a UPC look-up", logging.Fields{"upc": upc, "result": string(j), "error": err})
 			return "", err
 		}
 
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/diet"
 	fdc "github.com/prLorence/fdc-api/model"
 )
 
@@ -118,3 +119,133 @@ func TestFoodsSearchPostHandler(t *testing.T) {
 		t.Errorf("Expecting %d status is %d message is %s", http.StatusOK, parsed["status"], parsed["message"])
 	}
 }
+
+func TestCheckFacets(t *testing.T) {
+	sr := fdc.SearchRequest{Query: "cereal", Facets: []fdc.FacetRequest{{Name: fdc.COMPANY}}, Filters: map[string][]string{fdc.FOODGROUP: {"Cereal"}}}
+	if err := checkFacets(&sr); err != nil {
+		t.Errorf("Expecting valid facets got %v", err)
+	} else if sr.Facets[0].Size != defaultFacetSize {
+		t.Errorf("Expecting default facet size %d got %d", defaultFacetSize, sr.Facets[0].Size)
+	}
+	bad := []fdc.SearchRequest{
+		{Facets: []fdc.FacetRequest{{Name: "color"}}},
+		{Facets: []fdc.FacetRequest{{Name: fdc.COMPANY, Size: maxFacetSize + 1}}},
+		{Facets: []fdc.FacetRequest{{Name: fdc.NUTRIENT, Nutrient: 208}}},
+		{Filters: map[string][]string{"color": {"red"}}},
+		{NutrientFilters: []fdc.NutrientFilter{{Nutrient: 208}}},
+	}
+	for i := range bad {
+		if err := checkFacets(&bad[i]); err == nil {
+			t.Errorf("Expecting an error for %v", bad[i])
+		}
+	}
+}
+
+func TestCheckFuzzy(t *testing.T) {
+	sr := fdc.SearchRequest{Query: "brocoli", SearchType: fdc.FUZZY}
+	if err := checkFuzzy(&sr); err != nil || sr.Fuzziness != defaultFuzziness {
+		t.Errorf("Expecting default fuzziness %d got %d %v", defaultFuzziness, sr.Fuzziness, err)
+	}
+	sr = fdc.SearchRequest{Query: "brocoli", SearchType: fdc.FUZZY, Fuzziness: maxFuzziness + 1}
+	if err := checkFuzzy(&sr); err == nil {
+		t.Errorf("Expecting an error for fuzziness %d", sr.Fuzziness)
+	}
+	sr = fdc.SearchRequest{Query: "brocoli", SearchType: fdc.FUZZY, PrefixLength: -1}
+	if err := checkFuzzy(&sr); err == nil {
+		t.Error("Expecting an error for a negative prefix length")
+	}
+}
+
+func TestCheckClauses(t *testing.T) {
+	sr := fdc.SearchRequest{Query: "company:kellogg AND description:flakes NOT ingredients:sugar", SearchType: fdc.COMPOUND, Boosts: map[string]float32{"description": 3}}
+	if err := checkClauses(&sr); err != nil || len(sr.Clauses) != 3 {
+		t.Fatalf("Expecting 3 clauses got %v %v", sr.Clauses, err)
+	}
+	if sr.Clauses[1].Boost != 3 || sr.Clauses[0].Boost != 0 {
+		t.Errorf("Expecting the description boost to apply to the description clause only got %v", sr.Clauses)
+	}
+	sr = fdc.SearchRequest{Query: "flakes", Clauses: []fdc.SearchClause{{Occur: "mustnot", Field: "ingredients", Query: "sugar"}}}
+	if err := checkClauses(&sr); err != nil || sr.SearchType != fdc.COMPOUND || len(sr.Clauses) != 2 || sr.Clauses[1].Occur != fdc.MUSTNOT {
+		t.Errorf("Expecting the query to become a MUST clause got %v %v", sr, err)
+	}
+	for _, c := range []fdc.SearchClause{{Occur: "MAYBE", Query: "x"}, {Field: "color", Query: "red"}, {Query: ""}, {Query: "^k", SearchType: fdc.REGEX}} {
+		sr = fdc.SearchRequest{Clauses: []fdc.SearchClause{c}}
+		if err := checkClauses(&sr); err == nil {
+			t.Errorf("Expecting an error for clause %v", c)
+		}
+	}
+}
+
+func TestIngredientFilter(t *testing.T) {
+	sr := fdc.SearchRequest{Include: []string{"Sugar*"}, Exclude: []string{"PALM OIL", " "}}
+	if err := checkIngredients(&sr); err != nil || len(sr.Include) != 1 || sr.Include[0] != "sugar" || len(sr.Exclude) != 1 || sr.Exclude[0] != "palm oil" {
+		t.Errorf("Expecting normalized ingredients got %v %v %v", sr.Include, sr.Exclude, err)
+	}
+	w := ingredientFilter(sr.Include, sr.Exclude)
+	if !strings.Contains(w, "AND ANY t IN") || !strings.Contains(w, "AND NOT ANY t IN") || !strings.Contains(w, "\" palm oil \"") {
+		t.Errorf("Unexpected ingredient filter %s", w)
+	}
+}
+
+func TestAllergenFilter(t *testing.T) {
+	a, err := checkAllergens([]string{"Peanuts", "tree nuts"})
+	if err != nil || len(a) != 2 || a[0] != "peanut" || a[1] != "treenut" {
+		t.Errorf("Expecting allergen names got %v %v", a, err)
+	}
+	if _, err = checkAllergens([]string{"gluten"}); err == nil {
+		t.Error("Expecting an error for an unrecognized allergen")
+	}
+	w := allergenFilter(a)
+	if !strings.Contains(w, "allergens IS VALUED") || !strings.Contains(w, `["peanut","treenut"]`) {
+		t.Errorf("Unexpected allergen filter %s", w)
+	}
+	if allergenFilter(nil) != "" {
+		t.Error("Expecting no filter for an empty list")
+	}
+}
+
+func TestDietFilter(t *testing.T) {
+	var err error
+	if diets, err = diet.Load(""); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkDiets([]string{"vegan", "low-sodium"}); err != nil {
+		t.Errorf("Expecting valid diets got %v", err)
+	}
+	if err = checkDiets([]string{"paleo"}); err == nil {
+		t.Error("Expecting an error for an unrecognized diet")
+	}
+	if w := dietFilter([]string{"vegan"}); !strings.Contains(w, `ARRAY_CONTAINS(IFMISSINGORNULL(diets, []), "vegan")`) {
+		t.Errorf("Unexpected diet filter %s", w)
+	}
+}
+
+func TestResolveID(t *testing.T) {
+	for _, id := range []string{"389714", "1234567", "fdc:389714"} {
+		if got, err := resolveID(nil, id); err != nil || got != strings.TrimPrefix(id, "fdc:") {
+			t.Errorf("Expecting fdcId %s unchanged got %s %v", id, got, err)
+		}
+	}
+	for _, id := range []string{"011150548886", "0-11150-54888-6", "0111505488851", "upc:011150548886", "ndb:123456", "sr:01001"} {
+		if _, err := resolveID(nil, id); err == nil {
+			t.Errorf("Expecting an error for invalid GTIN %s", id)
+		}
+	}
+	for _, id := range []string{"USER:admin", "abc", `389714" OR type="USER`, "../389714"} {
+		if _, err := resolveID(nil, id); err == nil {
+			t.Errorf("Expecting an error for unprefixed id %s", id)
+		}
+	}
+	if _, err := getFdcIDs(nil, []string{"389714", "011150548886"}); err == nil {
+		t.Error("Expecting an error for a list with an invalid GTIN")
+	}
+}
+
+func TestCodeWhere(t *testing.T) {
+	if w := codeWhere(fdc.FoodID{Scheme: fdc.NDBNO, Value: "01001"}); w != `dataSource="SR" AND ndbno IN ["01001","1001"]` {
+		t.Errorf("Unexpected NDB predicate %s", w)
+	}
+	if w := codeWhere(fdc.FoodID{Scheme: fdc.FOODCODE, Value: "11111000"}); w != `dataSource="FNDDS" AND ndbno="11111000"` {
+		t.Errorf("Unexpected FNDDS predicate %s", w)
+	}
+}
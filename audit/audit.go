@@ -0,0 +1,237 @@
+// Package audit records who changed what in the datastore, when and from
+// where.  Entries are stored as AUDIT documents alongside the data.
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Actions recorded for datastore changes.  Auth events are recorded with
+// their own types.
+const (
+	CREATE = "create"
+	UPDATE = "update"
+	DELETE = "delete"
+)
+
+// redacted replaces the values of secret fields in diffs
+const redacted = "[redacted]"
+
+// secrets are the fields whose values are never recorded
+var secrets = map[string]bool{"password": true, "hash": true, "verifier": true, "nonce": true, "secret": true}
+
+// Actor is who made a change and from where.  RequestID is the id the
+// logging middleware gave the request.
+type Actor struct {
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role,omitempty"`
+	APIKey    string `json:"apiKey,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Change is the value of a field before and after a change
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Entry is a record of an action
+type Entry struct {
+	ID      string            `json:"_id"`
+	Type    string            `json:"type"`
+	Time    time.Time         `json:"time"`
+	Actor   Actor             `json:"actor"`
+	Action  string            `json:"action"`
+	Target  string            `json:"target,omitempty"`
+	Changes map[string]Change `json:"changes,omitempty"`
+	Detail  string            `json:"detail,omitempty"`
+}
+
+// Filter selects entries.  Empty fields match every entry.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string // document id or the prefix of one ending in *
+	IP     string
+	From   time.Time
+	To     time.Time
+}
+
+// Trail stores audit entries
+type Trail struct {
+	d      ds.DataSource
+	bucket string
+}
+
+// NewTrail returns a trail which stores entries in bucket of d
+func NewTrail(d ds.DataSource, bucket string) *Trail {
+	return &Trail{d: d, bucket: bucket}
+}
+
+// Record stores e, setting its id, type and time
+func (t *Trail) Record(e Entry) error {
+	var dt fdc.DocType
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	// whole seconds in UTC keep the stored times in order as strings
+	e.Time = e.Time.UTC().Truncate(time.Second)
+	e.Type = dt.ToString(fdc.AUDIT)
+	e.ID = fmt.Sprintf("%s:%s-%s", e.Type, e.Time.Format("20060102T150405"), hex.EncodeToString(b))
+	return t.d.Update(e.ID, e)
+}
+
+// Find returns the entries matched by f, newest first
+func (t *Trail) Find(f Filter, offset, limit int64) ([]interface{}, error) {
+	var (
+		dt    fdc.DocType
+		items []interface{}
+	)
+	w := fmt.Sprintf("type=%s", fdc.Quote(dt.ToString(fdc.AUDIT)))
+	if f.Actor != "" {
+		w += " AND actor.name=" + fdc.Quote(f.Actor)
+	}
+	if f.Action != "" {
+		w += " AND action=" + fdc.Quote(f.Action)
+	}
+	if strings.HasSuffix(f.Target, "*") {
+		w += " AND target LIKE " + fdc.Quote(likeEscape(strings.TrimSuffix(f.Target, "*"))+"%")
+	} else if f.Target != "" {
+		w += " AND target=" + fdc.Quote(f.Target)
+	}
+	if f.IP != "" {
+		w += " AND actor.ip=" + fdc.Quote(f.IP)
+	}
+	if !f.From.IsZero() {
+		w += " AND `time`>=" + fdc.Quote(f.From.UTC().Format(time.RFC3339))
+	}
+	if !f.To.IsZero() {
+		w += " AND `time`<" + fdc.Quote(f.To.UTC().Format(time.RFC3339))
+	}
+	q := fmt.Sprintf("SELECT RAW a FROM %s a WHERE %s ORDER BY `time` DESC, META(a).id DESC OFFSET %d LIMIT %d", t.bucket, w, offset, limit)
+	err := t.d.Query(q, &items)
+	return items, err
+}
+
+// In returns a trail which stores and finds entries through d.  A nil trail
+// returns nil.
+func (t *Trail) In(d ds.DataSource) *Trail {
+	if t == nil {
+		return nil
+	}
+	return &Trail{d: d, bucket: t.bucket}
+}
+
+// For returns d wrapped so its changes are recorded as made by a.  A nil
+// trail returns d.
+func (t *Trail) For(d ds.DataSource, a Actor) ds.DataSource {
+	if t == nil {
+		return d
+	}
+	return &DataSource{DataSource: d, trail: t.In(d), actor: a}
+}
+
+// DataSource is a DataSource which records its updates and removals
+type DataSource struct {
+	ds.DataSource
+	trail *Trail
+	actor Actor
+}
+
+// Update updates a document and records what changed
+func (d *DataSource) Update(id string, r interface{}) error {
+	var before interface{}
+	action := UPDATE
+	if err := d.DataSource.Get(id, &before); err != nil {
+		action, before = CREATE, nil
+	}
+	if err := d.DataSource.Update(id, r); err != nil {
+		return err
+	}
+	d.record(action, id, Diff(before, r))
+	return nil
+}
+
+// Remove removes a document and records what it held
+func (d *DataSource) Remove(id string) error {
+	var before interface{}
+	d.DataSource.Get(id, &before)
+	if err := d.DataSource.Remove(id); err != nil {
+		return err
+	}
+	d.record(DELETE, id, Diff(before, nil))
+	return nil
+}
+
+func (d *DataSource) record(action, id string, changes map[string]Change) {
+	if err := d.trail.Record(Entry{Actor: d.actor, Action: action, Target: id, Changes: changes}); err != nil {
+		logging.Error("cannot record a change", logging.Fields{"action": action, "target": id, "actor": d.actor.Name, "request_id": d.actor.RequestID, "error": err})
+	}
+}
+
+// Diff returns the top level fields of two documents which differ.  Either
+// may be nil.  Values of secret fields are redacted.
+func Diff(before, after interface{}) map[string]Change {
+	b, a := fields(before), fields(after)
+	changes := make(map[string]Change)
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !reflect.DeepEqual(v, w) {
+			changes[k] = Change{Before: v, After: w}
+		}
+	}
+	for k, w := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = Change{After: w}
+		}
+	}
+	for k, c := range changes {
+		if secrets[strings.ToLower(k)] {
+			if c.Before != nil {
+				c.Before = redacted
+			}
+			if c.After != nil {
+				c.After = redacted
+			}
+			changes[k] = c
+		}
+	}
+	return changes
+}
+
+// fields returns the JSON fields of a document
+func fields(v interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+	if v == nil {
+		return m
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	if err = json.Unmarshal(b, &m); err != nil {
+		m = map[string]interface{}{"value": v}
+	}
+	return m
+}
+
+// likeEscape escapes the wildcards of a LIKE pattern
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prLorence/fdc-api/ds"
+)
+
+// mapDs keeps documents in a map and remembers the last query
+type mapDs struct {
+	ds.DataSource
+	docs  map[string][]byte
+	query string
+}
+
+func (m *mapDs) Get(id string, f interface{}) error {
+	b, ok := m.docs[id]
+	if !ok {
+		return errors.New("key not found")
+	}
+	return json.Unmarshal(b, f)
+}
+
+func (m *mapDs) Update(id string, r interface{}) error {
+	b, err := json.Marshal(r)
+	m.docs[id] = b
+	return err
+}
+
+func (m *mapDs) Remove(id string) error {
+	if _, ok := m.docs[id]; !ok {
+		return errors.New("key not found")
+	}
+	delete(m.docs, id)
+	return nil
+}
+
+func (m *mapDs) Query(q string, f *[]interface{}) error {
+	m.query = q
+	return nil
+}
+
+func (m *mapDs) entries() []Entry {
+	var es []Entry
+	for k, b := range m.docs {
+		if strings.HasPrefix(k, "AUDIT:") {
+			var e Entry
+			json.Unmarshal(b, &e)
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+type user struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Email    string `json:"email,omitempty"`
+}
+
+func TestDiff(t *testing.T) {
+	c := Diff(user{Name: "u", Password: "h1", Role: "USER"}, user{Name: "u", Password: "h2", Role: "EDITOR", Email: "u@example.com"})
+	if len(c) != 3 {
+		t.Errorf("got %d changes %v", len(c), c)
+	}
+	if c["role"].Before != "USER" || c["role"].After != "EDITOR" || c["email"].Before != nil || c["email"].After != "u@example.com" {
+		t.Errorf("got %v", c)
+	}
+	if c["password"].Before != redacted || c["password"].After != redacted {
+		t.Errorf("password wasn't redacted: %v", c["password"])
+	}
+	if c = Diff(nil, user{Name: "u"}); c["name"].After != "u" || c["name"].Before != nil {
+		t.Errorf("got %v for a new document", c)
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	m := &mapDs{docs: make(map[string][]byte)}
+	tr := NewTrail(m, "test")
+	if d := (*Trail)(nil).For(m, Actor{}); d != m {
+		t.Error("a nil trail wrapped the datastore")
+	}
+	d := tr.For(m, Actor{Name: "admin", IP: "10.0.0.1"})
+	d.Update("USER:u", user{Name: "u", Password: "h1", Role: "USER"})
+	d.Update("USER:u", user{Name: "u", Password: "h1", Role: "EDITOR"})
+	if err := d.Remove("USER:u"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("USER:u"); err == nil {
+		t.Error("removed a missing document")
+	}
+	es := m.entries()
+	if len(es) != 3 {
+		t.Fatalf("got %d entries", len(es))
+	}
+	actions := map[string]Entry{}
+	for _, e := range es {
+		if e.Actor.Name != "admin" || e.Actor.IP != "10.0.0.1" || e.Target != "USER:u" || e.Type != "AUDIT" {
+			t.Errorf("got entry %+v", e)
+		}
+		actions[e.Action] = e
+	}
+	if u := actions[UPDATE].Changes; len(u) != 1 || u["role"].After != "EDITOR" {
+		t.Errorf("got update changes %v", u)
+	}
+	if c := actions[CREATE].Changes; c["name"].After != "u" {
+		t.Errorf("got create changes %v", c)
+	}
+	if c := actions[DELETE].Changes; c["role"].Before != "EDITOR" || c["password"].Before != redacted {
+		t.Errorf("got delete changes %v", c)
+	}
+}
+
+func TestFind(t *testing.T) {
+	m := &mapDs{docs: make(map[string][]byte)}
+	from := time.Date(2026, 10, 1, 2, 0, 0, 0, time.FixedZone("", 2*3600))
+	NewTrail(m, "test").Find(Filter{Actor: `a"b`, Target: "USER:100%*", From: from}, 20, 10)
+	for _, want := range []string{
+		`type="AUDIT"`,
+		`actor.name="a\"b"`,
+		`target LIKE "USER:100\\%%"`,
+		"`time`>=\"2026-10-01T00:00:00Z\"",
+		"OFFSET 20 LIMIT 10",
+	} {
+		if !strings.Contains(m.query, want) {
+			t.Errorf("query lacks %s:\n%s", want, m.query)
+		}
+	}
+}
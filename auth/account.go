@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// ResetTTL is how long a password reset token may be used
+const ResetTTL = time.Hour
+
+var (
+	// ErrInvalidReset is returned for reset tokens which are unknown, used
+	// or expired
+	ErrInvalidReset = errors.New("reset token is invalid or has expired")
+	// ErrNoEmail is returned when a password reset is requested for a user
+	// without an email address
+	ErrNoEmail = errors.New("user has no email address")
+)
+
+// PasswordReset is a single use token which lets a user set a new password.
+// Only a hash of the token is stored.
+type PasswordReset struct {
+	ID        string    `json:"_id"`
+	Type      string    `json:"type"`
+	User      string    `json:"user"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ResetID returns the document key of a password reset token
+func ResetID(token string) string {
+	var dt fdc.DocType
+	return fmt.Sprintf("%s:%s", dt.ToString(fdc.RESET), hashSecret(token))
+}
+
+// ChangePassword sets the password of a user who knows their current one
+func ChangePassword(d ds.DataSource, name, current, password string) error {
+	u, ok := FindUser(name, d)
+	if !ok || !CheckPasswordHash(current, u.Password) {
+		return ErrFailedAuthentication
+	}
+	return setPassword(d, u, password)
+}
+
+// NewPasswordReset stores a reset token for a user and returns it along with
+// the user to mail it to
+func NewPasswordReset(d ds.DataSource, name string) (string, User, error) {
+	var dt fdc.DocType
+	u, ok := FindUser(name, d)
+	if !ok {
+		return "", u, fmt.Errorf("user %s not found", name)
+	}
+	if u.Email == "" {
+		return "", u, ErrNoEmail
+	}
+	token, err := randomHex(16)
+	if err != nil {
+		return "", u, err
+	}
+	r := PasswordReset{ID: ResetID(token), Type: dt.ToString(fdc.RESET), User: u.Name, ExpiresAt: time.Now().Add(ResetTTL)}
+	return token, u, d.Update(r.ID, r)
+}
+
+// ResetPassword sets a user's password with a reset token and uses the token
+// up.  The token is removed before the password is set so concurrent
+// requests can't both use it, but not for a password the policy rejects.
+func ResetPassword(d ds.DataSource, token, password string) error {
+	var r PasswordReset
+	id := ResetID(token)
+	if err := d.Get(id, &r); err != nil {
+		return ErrInvalidReset
+	}
+	if time.Now().After(r.ExpiresAt) {
+		if err := d.Remove(id); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidReset, err)
+		}
+		return ErrInvalidReset
+	}
+	u, ok := FindUser(r.User, d)
+	if !ok {
+		return ErrInvalidReset
+	}
+	if err := checkPassword(u, password); err != nil {
+		return err
+	}
+	if err := d.Remove(id); err != nil {
+		return ErrInvalidReset
+	}
+	return setPassword(d, u, password)
+}
+
+// UpdateProfile changes a user's email address
+func UpdateProfile(d ds.DataSource, name, email string) (User, error) {
+	u, ok := FindUser(name, d)
+	if !ok {
+		return u, fmt.Errorf("user %s not found", name)
+	}
+	if email != "" {
+		a, err := mail.ParseAddress(email)
+		if err != nil || a.Name != "" {
+			return u, fmt.Errorf("invalid email address %s", email)
+		}
+		email = a.Address
+	}
+	u.Email = email
+	if err := d.Update(u.ID, u); err != nil {
+		return u, err
+	}
+	u.Password = ""
+	return u, nil
+}
+
+// checkPassword returns an error for a password the policy rejects for u
+func checkPassword(u User, password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	return CurrentPolicy().Check(u.Name, password)
+}
+
+func setPassword(d ds.DataSource, u User, password string) error {
+	var err error
+	if err = checkPassword(u, password); err != nil {
+		return err
+	}
+	if u.Password, err = HashPassword(password); err != nil {
+		return err
+	}
+	// a new password unlocks the account and revokes its tokens
+	u.LockedUntil = nil
+	u.TokenVersion++
+	if err = ClearFailures(d, u.Name); err != nil {
+		return err
+	}
+	return d.Update(u.ID, u)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prLorence/fdc-api/ds"
+)
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	d := newMemDs()
+	hash, _ := HashPassword("old password")
+	d.Update("USER:tester", User{ID: "USER:tester", Name: "tester", Password: hash, Role: "USER", Type: "USER"})
+	if err := ChangePassword(d, "tester", "wrong", "new password"); err != ErrFailedAuthentication {
+		t.Errorf("got %v for the wrong current password", err)
+	}
+	if err := ChangePassword(d, "tester", "old password", "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewPasswordReset(d, "tester"); err != ErrNoEmail {
+		t.Errorf("got %v for a user without email", err)
+	}
+	u, err := UpdateProfile(d, "tester", "Tester <tester@example.com>")
+	if err == nil {
+		t.Errorf("accepted a named address %v", u.Email)
+	}
+	if u, err = UpdateProfile(d, "tester", "tester@example.com"); err != nil || u.Email != "tester@example.com" || u.Password != "" {
+		t.Fatalf("got %+v %v", u, err)
+	}
+	token, u, err := NewPasswordReset(d, "tester")
+	if err != nil || u.Email != "tester@example.com" {
+		t.Fatalf("got %v %v", u, err)
+	}
+	if _, ok := d.docs["RESET:"+token]; ok {
+		t.Error("reset token is stored in the clear")
+	}
+	if err := ResetPassword(d, token+"0", "reset password"); err != ErrInvalidReset {
+		t.Errorf("got %v for an unknown token", err)
+	}
+	if err := ResetPassword(d, token, ""); err == nil || err == ErrInvalidReset {
+		t.Errorf("got %v for an empty password", err)
+	}
+	if err := ResetPassword(d, token, "reset password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetPassword(d, token, "again"); err != ErrInvalidReset {
+		t.Errorf("token was used twice: %v", err)
+	}
+	u, _ = FindUser("tester", d)
+	if !CheckPasswordHash("reset password", u.Password) || u.Email != "tester@example.com" {
+		t.Errorf("reset didn't set the password of %+v", u)
+	}
+}
+
+func TestResetExpired(t *testing.T) {
+	d := newMemDs()
+	d.Update(ResetID("abc"), PasswordReset{User: "tester", ExpiresAt: time.Now().Add(-time.Minute)})
+	if err := ResetPassword(d, "abc", "new password"); err != ErrInvalidReset {
+		t.Errorf("got %v for an expired token", err)
+	}
+	if d.FoodExists(ResetID("abc")) {
+		t.Error("expired token wasn't removed")
+	}
+}
+
+// claimedDs fails to remove documents as when another request has removed
+// them first
+type claimedDs struct {
+	ds.DataSource
+}
+
+func (c claimedDs) Remove(id string) error { return errors.New("key not found") }
+
+func TestResetClaimed(t *testing.T) {
+	d := newMemDs()
+	hash, _ := HashPassword("old password")
+	d.Update("USER:tester", User{ID: "USER:tester", Name: "tester", Password: hash, Role: "USER", Type: "USER"})
+	d.Update(ResetID("abc"), PasswordReset{User: "tester", ExpiresAt: time.Now().Add(time.Minute)})
+	if err := ResetPassword(claimedDs{d}, "abc", "new password"); err != ErrInvalidReset {
+		t.Errorf("got %v for a token claimed by another request", err)
+	}
+	if u, _ := FindUser("tester", d); !CheckPasswordHash("old password", u.Password) {
+		t.Error("password was set with a claimed token")
+	}
+	d.Update(ResetID("def"), PasswordReset{User: "tester", ExpiresAt: time.Now().Add(-time.Minute)})
+	if err := ResetPassword(claimedDs{d}, "def", "new password"); !errors.Is(err, ErrInvalidReset) || err == ErrInvalidReset {
+		t.Errorf("got %v when an expired token can't be removed", err)
+	}
+}
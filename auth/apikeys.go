@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Scopes which may be granted to an API key.  ALLSCOPES grants every scope.
+const (
+	FOODSCOPE       = "foods"
+	NUTRIENTSCOPE   = "nutrients"
+	DICTIONARYSCOPE = "dictionary"
+	ALLSCOPES       = "*"
+)
+
+var apiKeyKey = "apikey"
+
+// APIKey identifies a partner application.  Only a hash of the secret part
+// of a key is stored.
+type APIKey struct {
+	ID        string    `json:"_id"`
+	Type      string    `json:"type"`
+	Prefix    string    `json:"prefix"`
+	Hash      string    `json:"hash,omitempty"`
+	Name      string    `json:"name" binding:"required"`
+	Contact   string    `json:"contact,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+// NewAPIKey creates an API key for the named application and returns it
+// along with the key itself, which is not stored and can't be recovered.
+// A zero expires creates a key which never expires.
+func NewAPIKey(name string, scopes []string, expires time.Time) (APIKey, string, error) {
+	var dt fdc.DocType
+	if name == "" {
+		return APIKey{}, "", errors.New("name is required")
+	}
+	if len(scopes) == 0 {
+		return APIKey{}, "", errors.New("at least one scope is required")
+	}
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return APIKey{}, "", fmt.Errorf("unknown scope %s", s)
+		}
+	}
+	p, err := randomHex(4)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := randomHex(16)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	k := APIKey{
+		ID:        APIKeyID(p),
+		Type:      dt.ToString(fdc.APIKEY),
+		Prefix:    p,
+		Hash:      hashSecret(secret),
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expires,
+	}
+	return k, p + "." + secret, nil
+}
+
+// APIKeyID returns the document key of the API key with prefix p
+func APIKeyID(p string) string {
+	var dt fdc.DocType
+	return fmt.Sprintf("%s:%s", dt.ToString(fdc.APIKEY), p)
+}
+
+// ValidScope returns true if s may be granted to a key
+func ValidScope(s string) bool {
+	switch s {
+	case FOODSCOPE, NUTRIENTSCOPE, DICTIONARYSCOPE, ALLSCOPES:
+		return true
+	}
+	return false
+}
+
+// Allows returns true if the key has been granted scope
+func (k *APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ALLSCOPES {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns true if the key has an expiry before t
+func (k *APIKey) Expired(t time.Time) bool {
+	return !k.ExpiresAt.IsZero() && t.After(k.ExpiresAt)
+}
+
+// Matches compares the secret part of a key with the stored hash
+func (k *APIKey) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) == 1
+}
+
+// APIKeyMiddleware authenticates requests which carry an API key in the
+// configured header or query parameter.  Requests with a key which is
+// unknown, revoked or expired are rejected.  When keys are required, requests
+// without one are rejected unless a login token has identified the user.
+func APIKeyMiddleware(d ds.DataSource, cfg fdc.APIKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(cfg.Header)
+		if key == "" {
+			key = c.Query(cfg.Query)
+		}
+		if key == "" {
+			if _, ok := CurrentUser(c); cfg.Required && !ok {
+				unauthorized(c, "an API key is required")
+				return
+			}
+			c.Next()
+			return
+		}
+		k, err := findAPIKey(key, Store(c, d))
+		if err != nil {
+			Record(c, APIKEYINVALID, "", err.Error())
+			unauthorized(c, err.Error())
+			return
+		}
+		logging.Annotate(c, logging.Fields{"api_key": k.Prefix, "api_key_name": k.Name})
+		c.Set(apiKeyKey, &k)
+		c.Next()
+	}
+}
+
+// Scope rejects requests made with an API key which hasn't been granted
+// scope.  Requests without a key are left to APIKeyMiddleware.
+func Scope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if k, ok := CurrentAPIKey(c); ok && !k.Allows(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": fmt.Sprintf("API key is not granted the %s scope", scope),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentAPIKey returns the API key which authenticated a request
+func CurrentAPIKey(c *gin.Context) (*APIKey, bool) {
+	v, ok := c.Get(apiKeyKey)
+	if !ok {
+		return nil, false
+	}
+	k, ok := v.(*APIKey)
+	return k, ok
+}
+
+// findAPIKey returns the valid stored key for key
+func findAPIKey(key string, d ds.DataSource) (APIKey, error) {
+	var k APIKey
+	bad := errors.New("invalid API key")
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return k, bad
+	}
+	if err := d.Get(APIKeyID(parts[0]), &k); err != nil {
+		return k, bad
+	}
+	if k.Revoked || !k.Matches(parts[1]) {
+		return k, bad
+	}
+	if k.Expired(time.Now()) {
+		return k, errors.New("API key has expired")
+	}
+	return k, nil
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"code":    http.StatusUnauthorized,
+		"message": message,
+	})
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
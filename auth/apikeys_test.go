@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	k, key, err := NewAPIKey("partner", []string{FOODSCOPE}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 || parts[0] != k.Prefix {
+		t.Fatalf("key %s doesn't start with prefix %s", key, k.Prefix)
+	}
+	if k.ID != "APIKEY:"+k.Prefix || k.Type != "APIKEY" {
+		t.Errorf("got id %s type %s", k.ID, k.Type)
+	}
+	if strings.Contains(k.Hash, parts[1]) {
+		t.Error("secret is stored in the clear")
+	}
+	if !k.Matches(parts[1]) || k.Matches(parts[1]+"0") {
+		t.Error("hash doesn't match the secret")
+	}
+	if _, _, err := NewAPIKey("partner", nil, time.Time{}); err == nil {
+		t.Error("expected an error for no scopes")
+	}
+	if _, _, err := NewAPIKey("partner", []string{"write"}, time.Time{}); err == nil {
+		t.Error("expected an error for an unknown scope")
+	}
+	if _, _, err := NewAPIKey("", []string{FOODSCOPE}, time.Time{}); err == nil {
+		t.Error("expected an error for no name")
+	}
+}
+
+func TestAPIKeyAllowsExpired(t *testing.T) {
+	now := time.Now()
+	k := APIKey{Scopes: []string{FOODSCOPE}}
+	if !k.Allows(FOODSCOPE) || k.Allows(NUTRIENTSCOPE) {
+		t.Errorf("wrong scopes allowed for %v", k.Scopes)
+	}
+	k.Scopes = []string{ALLSCOPES}
+	if !k.Allows(DICTIONARYSCOPE) {
+		t.Error("* doesn't allow every scope")
+	}
+	if k.Expired(now) {
+		t.Error("key without an expiry has expired")
+	}
+	k.ExpiresAt = now.Add(-time.Minute)
+	if !k.Expired(now) {
+		t.Error("expected key to have expired")
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := fdc.APIKeys{Header: "X-Api-Key", Query: "api_key"}
+	tests := []struct {
+		required bool
+		user     bool
+		url      string
+		header   string
+		want     int
+	}{
+		{false, false, "/", "", http.StatusOK},
+		{true, false, "/", "", http.StatusUnauthorized},
+		{true, true, "/", "", http.StatusOK},
+		{false, false, "/", "nodot", http.StatusUnauthorized},
+		{false, false, "/?api_key=nodot", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		cfg.Required = tt.required
+		r := gin.New()
+		user := tt.user
+		r.GET("/", func(c *gin.Context) {
+			if user {
+				c.Set(identityKey, &User{Name: "tester"})
+			}
+		}, APIKeyMiddleware(nil, cfg), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.header != "" {
+			req.Header.Set(cfg.Header, tt.header)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%+v: got %d", tt, w.Code)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tt := range []struct {
+		key  *APIKey
+		want int
+	}{
+		{nil, http.StatusOK},
+		{&APIKey{Scopes: []string{NUTRIENTSCOPE}}, http.StatusOK},
+		{&APIKey{Scopes: []string{FOODSCOPE}}, http.StatusForbidden},
+	} {
+		k := tt.key
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) {
+			if k != nil {
+				c.Set(apiKeyKey, k)
+			}
+		}, Scope(NUTRIENTSCOPE), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != tt.want {
+			t.Errorf("%v: got %d want %d", tt.key, w.Code, tt.want)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package auth
 import (
 	"errors"
 	"fmt"
-	"log"
+	"math"
+	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
-	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
 	fdc "github.com/prLorence/fdc-api/model"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -22,7 +25,31 @@ type User struct {
 	Email    string `json:"email,omitempty"`
 	Role     string `json:"role"`
 	Type     string `json:"type"`
+	// Subject is the issuer and subject of users who log in through an
+	// OpenID Connect provider
+	Subject string `json:"subject,omitempty"`
+	// LockedUntil is set when failed logins lock the account.  The failures
+	// are counted in a separate counter document so concurrent logins can't
+	// lose any.
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	// TokenVersion is carried by the user's tokens and incremented when
+	// their password changes, revoking the tokens issued before
+	TokenVersion int `json:"tokenVersion,omitempty"`
 }
+
+// usernames start with a letter or digit followed by up to 63 letters,
+// digits or . _ @ + - so an email address can be a username
+var username = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@+-]{0,63}$`)
+
+// CheckUsername returns an error for names with characters other than those
+// allowed in usernames
+func CheckUsername(name string) error {
+	if !username.MatchString(name) {
+		return fmt.Errorf("%q is not a valid username: use up to 64 letters, digits and . _ @ + -", name)
+	}
+	return nil
+}
+
 type login struct {
 	Username string `form:"username" json:"username" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
@@ -34,6 +61,7 @@ type RoleType int
 const (
 	ADMIN RoleType = iota
 	USER
+	EDITOR
 )
 
 // ToRole converts string to RoleType
@@ -43,6 +71,8 @@ func (r *RoleType) ToRole(t string) RoleType {
 		return ADMIN
 	case "USER":
 		return USER
+	case "EDITOR":
+		return EDITOR
 	default:
 		return 0
 	}
@@ -55,88 +85,279 @@ func (r *RoleType) ToString(rt RoleType) string {
 		return "ADMIN"
 	case USER:
 		return "USER"
+	case EDITOR:
+		return "EDITOR"
 	default:
 		return ""
 	}
 }
 
-var identityKey = "role"
-
-// AuthMiddleware initializes our jwt components
-func (u *User) AuthMiddleware(bucket string, d ds.DataSource) *jwt.GinJWTMiddleware {
-	a, _ := jwt.New(&jwt.GinJWTMiddleware{
-		Realm:       "bfpd zone",
-		Key:         []byte("secret key"),
-		Timeout:     time.Hour,
-		MaxRefresh:  time.Hour,
-		IdentityKey: identityKey,
-		Authenticator: func(c *gin.Context) (interface{}, error) {
-			var (
-				u  User
-				rc bool
-				l  login
-			)
-			if err := c.BindJSON(&l); err != nil {
-				return "", jwt.ErrMissingLoginValues
-			}
+var (
+	identityKey = "role"
+	nameKey     = "name"
+	versionKey  = "ver"
+	// ErrMissingLoginValues is returned for logins without a user name or
+	// password
+	ErrMissingLoginValues = errors.New("missing Username or Password")
+	// ErrFailedAuthentication is returned for logins with the wrong user name
+	// or password
+	ErrFailedAuthentication = errors.New("incorrect Username or Password")
+	// ErrEmptyAuthHeader is returned for requests without a token
+	ErrEmptyAuthHeader = errors.New("auth header is empty")
+	// ErrInvalidAuthHeader is returned for requests without a bearer token
+	ErrInvalidAuthHeader = errors.New("auth header is invalid")
+	// ErrForbidden is returned for users without permission for a route
+	ErrForbidden = errors.New("you don't have permission to access this resource")
+	// ErrLocked is returned for logins to an account locked by failed logins
+	ErrLocked = errors.New("too many failed logins, try again later")
+	// ErrUnknownUser is returned for tokens naming a user who doesn't exist
+	ErrUnknownUser = errors.New("token names an unknown user")
+	// ErrTokenRevoked is returned for tokens issued before the user's
+	// password changed
+	ErrTokenRevoked = errors.New("token was revoked by a password change")
+)
 
-			if u, rc = findUser(l.Username, d); rc == false {
-				return "", jwt.ErrFailedAuthentication
-			}
+// Store returns the datastore for a request given the package's datastore d.
+// It returns d unless it's replaced, for instance so the datastore calls made
+// while authenticating appear in the request's trace and log.
+var Store = func(c *gin.Context, d ds.DataSource) ds.DataSource {
+	return d
+}
 
-			if rc = CheckPasswordHash(l.Password, u.Password); rc == false {
-				return "", jwt.ErrFailedAuthentication
-			}
-			return u, nil
-		},
-		// load user's roles into a []string and put into the claim
-		PayloadFunc: func(data interface{}) jwt.MapClaims {
-			if v, ok := data.(User); ok {
-				// u, _ = findUser(v.ID, d)
-				return jwt.MapClaims{
-					identityKey: v.Role,
-				}
-			}
-			return jwt.MapClaims{}
-		},
-		IdentityHandler: func(c *gin.Context) interface{} {
-			claims := jwt.ExtractClaims(c)
-			return &User{
-				Role: claims[identityKey].(string),
+// Audited returns the datastore for the changes a request makes to accounts
+// on behalf of the user named name, such as provisioning a user who logs in
+// through an identity provider.  It returns Store(c, d) unless it's replaced,
+// for instance so the changes are recorded in an audit trail.
+var Audited = func(c *gin.Context, d ds.DataSource, name string) ds.DataSource {
+	return Store(c, d)
+}
+
+// Middleware authenticates users by password and authorizes requests
+// carrying the tokens it issues
+type Middleware struct {
+	tokens    *Tokens
+	d         ds.DataSource
+	authorize func(*User) bool
+}
+
+// AuthMiddleware initializes our jwt components for routes which require the
+// ADMIN role
+func (u *User) AuthMiddleware(t *Tokens, d ds.DataSource) *Middleware {
+	var rt RoleType
+	return &Middleware{tokens: t, d: d, authorize: func(v *User) bool {
+		return v.Role == rt.ToString(ADMIN)
+	}}
+}
+
+// UserMiddleware initializes our jwt components for routes open to any user
+// with a valid token.  Tokens must name the user.
+func (u *User) UserMiddleware(t *Tokens, d ds.DataSource) *Middleware {
+	return &Middleware{tokens: t, d: d, authorize: func(v *User) bool {
+		return v.Name != ""
+	}}
+}
+
+// Optional authenticates requests which carry a token using mw and lets
+// requests without one through anonymously
+func Optional(mw *Middleware) gin.HandlerFunc {
+	h := mw.MiddlewareFunc()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		h(c)
+	}
+}
+
+// CurrentUser returns the user authenticated by a middleware.  Only the name
+// and role are set.
+func CurrentUser(c *gin.Context) (*User, bool) {
+	v, ok := c.Get(identityKey)
+	if !ok {
+		return nil, false
+	}
+	u, ok := v.(*User)
+	return u, ok
+}
+
+// MiddlewareFunc rejects requests without a valid bearer token for an
+// authorized user and puts the user into the request context.  The user's
+// role is read from the datastore so a changed role, a removed user or a
+// password change takes effect before their tokens expire.
+func (mw *Middleware) MiddlewareFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := bearer(c)
+		if err != nil {
+			unauthorized(c, err.Error())
+			return
+		}
+		claims, err := mw.tokens.Parse(token)
+		if err != nil {
+			Record(c, TOKENINVALID, "", err.Error())
+			unauthorized(c, err.Error())
+			return
+		}
+		stored, err := mw.user(Store(c, mw.d), claims)
+		if err != nil {
+			Record(c, TOKENINVALID, stored.Name, err.Error())
+			unauthorized(c, err.Error())
+			return
+		}
+		u := &User{Name: stored.Name, Role: stored.Role}
+		if !mw.authorize(u) {
+			Record(c, ACCESSDENIED, u.Name, "role "+u.Role)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": ErrForbidden.Error(),
+			})
+			return
+		}
+		c.Set(identityKey, u)
+		c.Next()
+	}
+}
+
+// LoginHandler issues a token to a user who logs in with a name and password.
+// Accounts are locked for a while after repeated failures and passwords
+// hashed at another cost than the policy's are rehashed.
+func (mw *Middleware) LoginHandler(c *gin.Context) {
+	var l login
+	if err := c.BindJSON(&l); err != nil {
+		unauthorized(c, ErrMissingLoginValues.Error())
+		return
+	}
+	p := CurrentPolicy()
+	d := Store(c, mw.d)
+	u, ok := FindUser(l.Username, d)
+	if !ok {
+		// spend as long as a wrong password would so users can't be found
+		// by timing logins
+		p.CheckDummy(l.Password)
+		Record(c, LOGINFAILED, l.Username, "unknown user")
+		unauthorized(c, ErrFailedAuthentication.Error())
+		return
+	}
+	now := time.Now()
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		Record(c, LOGINLOCKED, u.Name, fmt.Sprintf("locked until %s", u.LockedUntil.Format(time.RFC3339)))
+		locked(c, u.LockedUntil.Sub(now))
+		return
+	}
+	if !CheckPasswordHash(l.Password, u.Password) {
+		n, err := d.Counter(failuresID(u.Name), 1)
+		if err != nil {
+			logging.From(c).Error("cannot record a failed login", logging.Fields{"user": u.Name, "error": err})
+		}
+		Record(c, LOGINFAILED, u.Name, fmt.Sprintf("%d consecutive failures", n))
+		if wait := p.LockedFor(int(n)); wait > 0 {
+			until := now.Add(wait)
+			u.LockedUntil = &until
+			Record(c, LOGINLOCKED, u.Name, fmt.Sprintf("locked for %v", wait))
+			if err := d.Update(u.ID, u); err != nil {
+				logging.From(c).Error("cannot lock an account", logging.Fields{"user": u.Name, "error": err})
 			}
-		},
-		// must have ADMIN role from the roles assigned to the current user
-		Authorizator: func(data interface{}, c *gin.Context) bool {
-			var rt RoleType
-			if v, ok := data.(*User); ok && v.Role == rt.ToString(ADMIN) {
-				return true
+		}
+		unauthorized(c, ErrFailedAuthentication.Error())
+		return
+	}
+	if err := ClearFailures(d, u.Name); err != nil {
+		logging.From(c).Error("cannot reset failed logins", logging.Fields{"user": u.Name, "error": err})
+	}
+	if u.LockedUntil != nil || p.NeedsRehash(u.Password) {
+		u.LockedUntil = nil
+		if p.NeedsRehash(u.Password) {
+			if h, err := HashPassword(l.Password); err == nil {
+				u.Password = h
 			}
-			return false
-		},
-		Unauthorized: func(c *gin.Context, code int, message string) {
-			c.JSON(code, gin.H{
-				"code":    code,
-				"message": message,
-			})
-		},
-		// TokenLookup is a string in the form of "<source>:<name>" that is used
-		// to extract token from the request.
-		// Optional. Default value "header:Authorization".
-		// Possible values:
-		// - "header:<name>"
-		// - "query:<name>"
-		// - "cookie:<name>"
-		TokenLookup: "header:Authorization",
-		// TokenLookup: "query:token",
-		// TokenLookup: "cookie:token",
-
-		// TokenHeadName is a string in the header. Default value is "Bearer"
-		TokenHeadName: "Bearer",
-
-		// TimeFunc provides the current time. You can override it to use another time value. This is useful for testing or if your server uses a different time zone than your tokens.
-		TimeFunc: time.Now,
+		}
+		if err := d.Update(u.ID, u); err != nil {
+			logging.From(c).Error("cannot reset failed logins", logging.Fields{"user": u.Name, "error": err})
+		}
+	}
+	Record(c, LOGINSUCCEEDED, u.Name, "password")
+	mw.issue(c, u)
+}
+
+// user returns the user in d named by the claims of a token.  Tokens of
+// users who have been removed or have changed their password since the token
+// was issued are refused.
+func (mw *Middleware) user(d ds.DataSource, claims jwt.MapClaims) (User, error) {
+	name, _ := claims[nameKey].(string)
+	u, ok := FindUser(name, d)
+	if !ok {
+		return User{Name: name}, ErrUnknownUser
+	}
+	if v, _ := claims[versionKey].(float64); int(v) != u.TokenVersion {
+		return u, ErrTokenRevoked
+	}
+	return u, nil
+}
+
+// issue responds with a new token for u
+func (mw *Middleware) issue(c *gin.Context, u User) {
+	token, expire, err := mw.tokens.Generate(jwt.MapClaims{
+		identityKey: u.Role,
+		nameKey:     u.Name,
+		versionKey:  u.TokenVersion,
+		"sub":       u.Name,
+	})
+	tokenResponse(c, token, expire, err)
+}
+
+// RefreshHandler issues a new token for the bearer token of a request.  The
+// token may have expired but must have been issued less than MaxRefresh ago
+// and not been revoked.
+func (mw *Middleware) RefreshHandler(c *gin.Context) {
+	token, err := bearer(c)
+	if err != nil {
+		unauthorized(c, err.Error())
+		return
+	}
+	claims, err := mw.tokens.Refreshable(token)
+	if err == nil {
+		_, err = mw.user(Store(c, mw.d), claims)
+	}
+	if err != nil {
+		unauthorized(c, err.Error())
+		return
+	}
+	token, expire, err := mw.tokens.Refresh(token)
+	tokenResponse(c, token, expire, err)
+}
+
+// locked rejects a login to a locked account
+func locked(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"code":    http.StatusTooManyRequests,
+		"message": ErrLocked.Error(),
+	})
+}
+
+func tokenResponse(c *gin.Context, token string, expire time.Time, err error) {
+	if err != nil {
+		unauthorized(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"token":  token,
+		"expire": expire.Format(time.RFC3339),
 	})
-	return a
+}
+
+// bearer returns the token in the Authorization header of a request
+func bearer(c *gin.Context) (string, error) {
+	h := c.GetHeader("Authorization")
+	if h == "" {
+		return "", ErrEmptyAuthHeader
+	}
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+		return "", ErrInvalidAuthHeader
+	}
+	return parts[1], nil
 }
 
 // add a user named 'bfpdadmin' with ADMIN  role
@@ -155,24 +376,30 @@ func (u *User) BootstrapUsers(defaultuser *string, d ds.DataSource) error {
 		return errors.New("password is required")
 	}
 	user.Name = userinfo[0]
+	if err = CheckUsername(user.Name); err != nil {
+		return err
+	}
+	if err = CurrentPolicy().Check(user.Name, userinfo[1]); err != nil {
+		return err
+	}
 	user.Password, err = HashPassword(userinfo[1])
 	if err != nil {
-		log.Println(err)
+		logging.Error("cannot hash the bootstrap password", logging.Fields{"user": user.Name, "error": err})
 	} else {
 		user.Role = rt.ToString(ADMIN)
 		user.ID = fmt.Sprintf("%s:%s", dt.ToString(fdc.USER), user.Name)
 		user.Type = dt.ToString(fdc.USER)
 		err = d.Update(user.ID, user)
 		if err != nil {
-			log.Println(err)
+			logging.Error("cannot save the bootstrap user", logging.Fields{"user": user.Name, "error": err})
 		}
 	}
 	return err
 }
 
-// generates an encrypted password
+// generates an encrypted password at the cost of the current policy
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), CurrentPolicy().Cost)
 	return string(bytes), err
 }
 
@@ -183,7 +410,24 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func findUser(name string, dc ds.DataSource) (User, bool) {
+// failuresID returns the key of the counter of a user's consecutive failed
+// logins
+func failuresID(name string) string {
+	var dt fdc.DocType
+	return fmt.Sprintf("%s:%s", dt.ToString(fdc.FAILURES), name)
+}
+
+// ClearFailures resets the count of a user's failed logins, unlocking
+// their account along with clearing LockedUntil
+func ClearFailures(d ds.DataSource, name string) error {
+	if id := failuresID(name); d.FoodExists(id) {
+		return d.Remove(id)
+	}
+	return nil
+}
+
+// FindUser returns the named user
+func FindUser(name string, dc ds.DataSource) (User, bool) {
 	var (
 		u  User
 		dt fdc.DocType
@@ -191,7 +435,7 @@ func findUser(name string, dc ds.DataSource) (User, bool) {
 	rc := true
 	id := fmt.Sprintf("%s:%s", dt.ToString(fdc.USER), name)
 	if err := dc.Get(id, &u); err != nil {
-		log.Println(err)
+		logging.Debug("cannot find user", logging.Fields{"user": name, "error": err})
 		rc = false
 	}
 	return u, rc
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/logging"
+)
+
+// Security event types
+const (
+	LOGINSUCCEEDED  = "login.succeeded"
+	LOGINFAILED     = "login.failed"
+	LOGINLOCKED     = "login.locked"
+	TOKENINVALID    = "token.invalid"
+	APIKEYINVALID   = "apikey.invalid"
+	ACCESSDENIED    = "access.denied"
+	PASSWORDFAILED  = "password.failed"
+	PASSWORDCHANGED = "password.changed"
+)
+
+// Event is a security event such as a failed login.  RequestID is the id
+// the logging middleware gave the request.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	User      string    `json:"user,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// Events receives the security events of the package with the request they
+// happened in.  It logs them unless it's replaced, for instance by an audit
+// trail.
+var Events = func(c *gin.Context, e Event) {
+	logging.Warn("auth event", logging.Fields{
+		"type":       e.Type,
+		"user":       e.User,
+		"ip":         e.IP,
+		"path":       e.Path,
+		"detail":     e.Detail,
+		"request_id": e.RequestID,
+	})
+}
+
+// Record sends an event about a request to Events
+func Record(c *gin.Context, typ, user, detail string) {
+	Events(c, Event{
+		Time:      time.Now(),
+		Type:      typ,
+		User:      user,
+		IP:        c.ClientIP(),
+		Path:      c.Request.URL.Path,
+		Detail:    detail,
+		RequestID: logging.RequestID(c),
+	})
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	fdc "github.com/prLorence/fdc-api/model"
+	gocb "gopkg.in/couchbase/gocb.v1"
+)
+
+// memDs is a DataSource which keeps documents in a map
+type memDs struct {
+	docs map[string][]byte
+}
+
+func newMemDs() *memDs {
+	return &memDs{docs: make(map[string][]byte)}
+}
+
+func (m *memDs) ConnectDs(cs fdc.Config) error { return nil }
+
+func (m *memDs) Get(q string, f interface{}) error {
+	b, ok := m.docs[q]
+	if !ok {
+		return errors.New("key not found")
+	}
+	return json.Unmarshal(b, f)
+}
+
+func (m *memDs) Query(q string, f *[]interface{}) error { return nil }
+
+func (m *memDs) Counts(bucket string, doctype string, c *[]interface{}) error { return nil }
+
+func (m *memDs) GetDictionary(dsname string, doctype string, offset int64, limit int64) ([]interface{}, error) {
+	var items []interface{}
+	for k, b := range m.docs {
+		if doctype == "ROLE" && strings.HasPrefix(k, "ROLE:") {
+			var r Role
+			json.Unmarshal(b, &r)
+			items = append(items, r)
+		}
+	}
+	return items, nil
+}
+
+func (m *memDs) Browse(bucket string, where string, offset int64, limit int64, sort string, order string) ([]interface{}, error) {
+	return nil, nil
+}
+
+func (m *memDs) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
+	return 0, nil
+}
+
+func (m *memDs) Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error { return nil }
+
+func (m *memDs) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error {
+	return nil
+}
+
+func (m *memDs) Update(id string, r interface{}) error {
+	b, err := json.Marshal(r)
+	m.docs[id] = b
+	return err
+}
+
+func (m *memDs) Remove(id string) error {
+	if _, ok := m.docs[id]; !ok {
+		return errors.New("key not found")
+	}
+	delete(m.docs, id)
+	return nil
+}
+
+func (m *memDs) Counter(id string, delta int64) (int64, error) {
+	var n int64
+	json.Unmarshal(m.docs[id], &n)
+	n += delta
+	return n, m.Update(id, n)
+}
+
+func (m *memDs) FoodExists(id string) bool {
+	_, ok := m.docs[id]
+	return ok
+}
+
+func (m *memDs) Bulk(n *[]fdc.NutrientData) error { return nil }
+
+func (m *memDs) BulkInsert(v []gocb.BulkOp) error { return nil }
+
+func (m *memDs) CloseDs() {}
+
+func (m *memDs) Ping(cs fdc.Config) ([]fdc.Check, error) { return nil, nil }
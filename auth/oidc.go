@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+	"golang.org/x/oauth2"
+)
+
+// LoginTTL is how long a user has to log in at the identity provider
+const LoginTTL = 10 * time.Minute
+
+// stateCookie binds a login to the browser which started it
+const stateCookie = "fdc_oidc_state"
+
+// OIDC logs users in through an OpenID Connect provider with the
+// authorization code flow and PKCE.  Users are issued the API's own tokens
+// with a role mapped from the provider's claims.
+type OIDC struct {
+	mw       *Middleware
+	d        ds.DataSource
+	cfg      fdc.OIDC
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// LoginState is a login in progress.  It's stored under the state parameter
+// and used up by the callback.
+type LoginState struct {
+	ID        string    `json:"_id"`
+	Type      string    `json:"type"`
+	Verifier  string    `json:"verifier"`
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// NewOIDC discovers the provider configured by cfg.  mw issues tokens to the
+// users who log in.
+func NewOIDC(ctx context.Context, cfg fdc.OIDC, mw *Middleware) (*OIDC, error) {
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC needs a client id and redirect URL")
+	}
+	p, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDC{
+		mw:  mw,
+		d:   mw.d,
+		cfg: cfg,
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     p.Endpoint(),
+			Scopes:       cfg.Scopes,
+		},
+		verifier: p.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// LoginStateID returns the document key of a login state
+func LoginStateID(state string) string {
+	var dt fdc.DocType
+	return fmt.Sprintf("%s:%s", dt.ToString(fdc.OIDCSTATE), hashSecret(state))
+}
+
+// LoginHandler sends the user to the provider to log in
+func (o *OIDC) LoginHandler(c *gin.Context) {
+	var dt fdc.DocType
+	state, err1 := randomHex(16)
+	nonce, err2 := randomHex(16)
+	verifier, err3 := randomHex(32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	s := LoginState{
+		ID:        LoginStateID(state),
+		Type:      dt.ToString(fdc.OIDCSTATE),
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(LoginTTL),
+	}
+	if err := Store(c, o.d).Update(s.ID, s); err != nil {
+		logging.From(c).Error("cannot save the login state", logging.Fields{"error": err})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.SetCookie(stateCookie, state, int(LoginTTL.Seconds()), "", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, o.config.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", challenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	))
+}
+
+// CallbackHandler completes a login when the provider sends the user back
+// and responds with a token like the password login
+func (o *OIDC) CallbackHandler(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		o.fail(c, "", fmt.Sprintf("%s %s", e, c.Query("error_description")))
+		return
+	}
+	state := c.Query("state")
+	cookie, _ := c.Cookie(stateCookie)
+	if state == "" || cookie != state {
+		o.fail(c, "", "state doesn't match the login")
+		return
+	}
+	c.SetCookie(stateCookie, "", -1, "", "", c.Request.TLS != nil, true)
+	var s LoginState
+	d := Store(c, o.d)
+	id := LoginStateID(state)
+	if err := d.Get(id, &s); err != nil {
+		o.fail(c, "", "unknown login state")
+		return
+	}
+	d.Remove(id)
+	if time.Now().After(s.ExpiresAt) {
+		o.fail(c, "", "login has expired")
+		return
+	}
+	ctx := c.Request.Context()
+	t, err := o.config.Exchange(ctx, c.Query("code"), oauth2.SetAuthURLParam("code_verifier", s.Verifier))
+	if err != nil {
+		o.fail(c, "", err.Error())
+		return
+	}
+	raw, ok := t.Extra("id_token").(string)
+	if !ok {
+		o.fail(c, "", "no id_token in the token response")
+		return
+	}
+	idt, err := o.verifier.Verify(ctx, raw)
+	if err != nil {
+		o.fail(c, "", err.Error())
+		return
+	}
+	if idt.Nonce != s.Nonce {
+		o.fail(c, idt.Subject, "nonce doesn't match the login")
+		return
+	}
+	var claims map[string]interface{}
+	if err = idt.Claims(&claims); err != nil {
+		o.fail(c, idt.Subject, err.Error())
+		return
+	}
+	u, err := o.user(c, idt.Issuer+"|"+idt.Subject, claims)
+	if err != nil {
+		o.fail(c, idt.Subject, err.Error())
+		return
+	}
+	Record(c, LOGINSUCCEEDED, u.Name, "oidc")
+	o.mw.issue(c, u)
+}
+
+// user returns the user for the claims of an ID token, adding or updating
+// its document through Audited so the change is recorded as the user's.  Users which the provider doesn't name and local users with
+// the same name are refused.
+func (o *OIDC) user(c *gin.Context, subject string, claims map[string]interface{}) (User, error) {
+	var dt fdc.DocType
+	name, _ := claims[o.cfg.UsernameClaim].(string)
+	if name == "" {
+		return User{}, fmt.Errorf("ID token has no %s claim", o.cfg.UsernameClaim)
+	}
+	if err := CheckUsername(name); err != nil {
+		return User{}, err
+	}
+	role := MapRole(o.cfg, claims)
+	if role == "" {
+		return User{}, errors.New("no role is granted to the user")
+	}
+	u, ok := FindUser(name, Store(c, o.d))
+	if ok && u.Subject != subject {
+		return User{}, fmt.Errorf("user %s isn't linked to the provider", name)
+	}
+	u.ID = fmt.Sprintf("%s:%s", dt.ToString(fdc.USER), name)
+	u.Type = dt.ToString(fdc.USER)
+	u.Name = name
+	u.Subject = subject
+	u.Role = role
+	if email, _ := claims["email"].(string); email != "" && claims["email_verified"] == true {
+		u.Email = email
+	}
+	return u, Audited(c, o.d, name).Update(u.ID, u)
+}
+
+// fail rejects a login and records the failure
+func (o *OIDC) fail(c *gin.Context, user, detail string) {
+	Record(c, LOGINFAILED, user, "oidc: "+detail)
+	unauthorized(c, ErrFailedAuthentication.Error())
+}
+
+// MapRole returns the role of the first mapping in cfg which matches a value
+// of the role claim or else the default role
+func MapRole(cfg fdc.OIDC, claims map[string]interface{}) string {
+	values := map[string]bool{}
+	switch v := claims[cfg.RoleClaim].(type) {
+	case string:
+		values[v] = true
+	case []interface{}:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values[s] = true
+			}
+		}
+	}
+	for _, r := range cfg.Roles {
+		if values[r.Value] {
+			return r.Role
+		}
+	}
+	return cfg.DefaultRole
+}
+
+// challenge returns the S256 PKCE code challenge of verifier
+func challenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
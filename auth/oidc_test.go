@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// stubIdP is an OpenID Connect provider which logs in whoever it's told to
+type stubIdP struct {
+	*httptest.Server
+	tokens    *Tokens
+	claims    jwt.MapClaims
+	challenge string
+	nonce     string
+}
+
+func newStubIdP(t *testing.T, dir string) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := keyFiles(t, dir, "idp", key, &key.PublicKey)
+	p := &stubIdP{}
+	mux := http.NewServeMux()
+	p.Server = httptest.NewServer(mux)
+	if p.tokens, err = NewTokens(fdc.JWT{Algorithm: "RS256", PrivateKey: priv, Timeout: time.Minute, MaxRefresh: time.Minute, Issuer: p.URL, Audience: "fdc"}); err != nil {
+		t.Fatal(err)
+	}
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.tokens.JWKS())
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "fdc" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		p.challenge, p.nonce = q.Get("code_challenge"), q.Get("nonce")
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=c0de&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "c0de" || challenge(r.Form.Get("code_verifier")) != p.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := jwt.MapClaims{"nonce": p.nonce}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		idt, _, _ := p.tokens.Generate(claims)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "token_type": "Bearer", "expires_in": 60, "id_token": idt})
+	})
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	idp := newStubIdP(t, dir)
+	defer idp.Close()
+	d := newMemDs()
+	var audited, events []string
+	defer func(f func(*gin.Context, ds.DataSource, string) ds.DataSource) { Audited = f }(Audited)
+	Audited = func(c *gin.Context, d ds.DataSource, name string) ds.DataSource {
+		audited = append(audited, name)
+		return d
+	}
+	defer func(f func(*gin.Context, Event)) { Events = f }(Events)
+	Events = func(c *gin.Context, e Event) { events = append(events, e.Type+" "+e.User) }
+	tk, _ := NewTokens(hsConfig(newSecret, ""))
+	var u *User
+	o, err := NewOIDC(context.Background(), fdc.OIDC{
+		Issuer:        idp.URL,
+		ClientID:      "fdc",
+		RedirectURL:   "http://api.test/oidc/callback",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		RoleClaim:     "groups",
+		Roles:         []fdc.OIDCRole{{Value: "fdc-admins", Role: "ADMIN"}, {Value: "staff", Role: "EDITOR"}},
+	}, u.UserMiddleware(tk, d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.GET("/oidc/login", o.LoginHandler)
+	r.GET("/oidc/callback", o.CallbackHandler)
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	// login runs the flow and returns the callback's response and request
+	login := func(cookie bool) (*httptest.ResponseRecorder, *http.Request) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("login got %d", w.Code)
+		}
+		resp, err := noRedirect.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		back, _ := url.Parse(resp.Header.Get("Location"))
+		req := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+back.RawQuery, nil)
+		if cookie {
+			for _, c := range w.Result().Cookies() {
+				req.AddCookie(c)
+			}
+		}
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w, req
+	}
+
+	idp.claims = jwt.MapClaims{"sub": "u-1", "preferred_username": "jdoe", "groups": []string{"staff", "fdc-admins"}, "email": "jdoe@example.com", "email_verified": true}
+	w, req := login(true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback got %d %s", w.Code, w.Body)
+	}
+	var res struct{ Token string }
+	json.Unmarshal(w.Body.Bytes(), &res)
+	claims, err := tk.Parse(res.Token)
+	if err != nil || claims[nameKey] != "jdoe" || claims[identityKey] != "ADMIN" {
+		t.Errorf("got claims %v %v", claims, err)
+	}
+	v, _ := FindUser("jdoe", d)
+	if v.Subject != idp.URL+"|u-1" || v.Email != "jdoe@example.com" || v.Password != "" {
+		t.Errorf("got user %+v", v)
+	}
+	if len(audited) != 1 || audited[0] != "jdoe" || len(events) != 1 || events[0] != "login.succeeded jdoe" {
+		t.Errorf("provisioning wasn't audited: %v %v", audited, events)
+	}
+	// the state is used up
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed callback got %d", w.Code)
+	}
+	if w, _ = login(false); w.Code != http.StatusUnauthorized {
+		t.Errorf("callback without the state cookie got %d", w.Code)
+	}
+	// local accounts can't be taken over
+	hash, _ := HashPassword("secret")
+	d.Update("USER:root", User{ID: "USER:root", Name: "root", Password: hash, Role: "ADMIN", Type: "USER"})
+	idp.claims = jwt.MapClaims{"sub": "u-2", "preferred_username": "root", "groups": "fdc-admins"}
+	if w, _ = login(true); w.Code != http.StatusUnauthorized {
+		t.Errorf("local user was taken over: %d", w.Code)
+	}
+	idp.claims = jwt.MapClaims{"sub": "u-3", "preferred_username": "guest"}
+	if w, _ = login(true); w.Code != http.StatusUnauthorized {
+		t.Errorf("user without a role got %d", w.Code)
+	}
+}
+
+func TestMapRole(t *testing.T) {
+	cfg := fdc.OIDC{RoleClaim: "roles", Roles: []fdc.OIDCRole{{Value: "a", Role: "ADMIN"}, {Value: "e", Role: "EDITOR"}}, DefaultRole: "USER"}
+	for _, tt := range []struct {
+		roles interface{}
+		want  string
+	}{
+		{[]interface{}{"e", "a"}, "ADMIN"},
+		{"e", "EDITOR"},
+		{[]interface{}{"x", 1}, "USER"},
+		{nil, "USER"},
+	} {
+		if got := MapRole(cfg, map[string]interface{}{"roles": tt.roles}); got != tt.want {
+			t.Errorf("%v: got %s want %s", tt.roles, got, tt.want)
+		}
+	}
+	if !strings.HasPrefix(LoginStateID("s"), "OIDCSTATE:") {
+		t.Error("login state has the wrong document type")
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	fdc "github.com/prLorence/fdc-api/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPasswordBytes is the most bcrypt hashes, longer passwords are truncated
+const maxPasswordBytes = 72
+
+// ErrBreachedPassword is returned for passwords in the breached password list
+var ErrBreachedPassword = errors.New("password has appeared in a data breach, choose another")
+
+var sha1Line = regexp.MustCompile(`^[0-9A-Fa-f]{40}(:\d+)?$`)
+
+// Policy is the password and login policy.  Passwords must have MinLength
+// characters from at least Classes of lower case, upper case, digits and
+// symbols and mustn't be breached.  Accounts are locked after
+// Lockout.Attempts failed logins, for Lockout.Duration doubled by each
+// further failure up to Lockout.MaxDuration.
+type Policy struct {
+	MinLength int
+	Classes   int
+	Cost      int
+	Lockout   fdc.Lockout
+	breached  map[string]bool
+	dummyOnce sync.Once
+	dummy     []byte
+}
+
+var (
+	policyMu sync.RWMutex
+	policy   = &Policy{MinLength: 1, Cost: 14}
+)
+
+// NewPolicy returns the policy configured by pw and lo.  The breached
+// password file has a password or the upper case hex SHA-1 hash of one on
+// each line; hashes may be followed by :count as in the Pwned Passwords
+// downloads.
+func NewPolicy(pw fdc.Passwords, lo fdc.Lockout) (*Policy, error) {
+	if pw.Cost < bcrypt.MinCost || pw.Cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if pw.Classes > 4 {
+		return nil, errors.New("a password can have at most 4 classes of characters")
+	}
+	p := &Policy{MinLength: pw.MinLength, Classes: pw.Classes, Cost: pw.Cost, Lockout: lo, breached: make(map[string]bool)}
+	if pw.Breached == "" {
+		return p, nil
+	}
+	f, err := os.Open(pw.Breached)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "":
+		case sha1Line.MatchString(line):
+			p.breached[strings.ToUpper(line[:40])] = true
+		default:
+			p.breached[sha1Hex(line)] = true
+		}
+	}
+	return p, s.Err()
+}
+
+// SetPolicy makes p the policy of the package
+func SetPolicy(p *Policy) {
+	policyMu.Lock()
+	policy = p
+	policyMu.Unlock()
+}
+
+// CurrentPolicy returns the policy of the package
+func CurrentPolicy() *Policy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return policy
+}
+
+// Check returns an error describing why the password of the named user
+// doesn't meet the policy
+func (p *Policy) Check(name, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must have at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must have at most %d bytes", maxPasswordBytes)
+	}
+	if n := classes(password); n < p.Classes {
+		return fmt.Errorf("password must mix at least %d of lower case, upper case, digits and symbols", p.Classes)
+	}
+	if len(name) > 2 && strings.Contains(strings.ToLower(password), strings.ToLower(name)) {
+		return errors.New("password mustn't contain the user name")
+	}
+	if p.breached[sha1Hex(password)] {
+		return ErrBreachedPassword
+	}
+	return nil
+}
+
+// NeedsRehash reports whether hash was made with a cost other than the
+// policy's
+func (p *Policy) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost != p.Cost
+}
+
+// CheckDummy compares password with a hash made at the policy's cost, taking
+// as long as checking a real password
+func (p *Policy) CheckDummy(password string) {
+	p.dummyOnce.Do(func() {
+		p.dummy, _ = bcrypt.GenerateFromPassword([]byte("no user has this password"), p.Cost)
+	})
+	bcrypt.CompareHashAndPassword(p.dummy, []byte(password))
+}
+
+// LockedFor returns how long an account is locked after its nth consecutive
+// failed login
+func (p *Policy) LockedFor(n int) time.Duration {
+	lo := p.Lockout
+	if lo.Attempts == nil || *lo.Attempts <= 0 || n < *lo.Attempts {
+		return 0
+	}
+	d := lo.Duration
+	for i := *lo.Attempts; i < n; i++ {
+		if d *= 2; lo.MaxDuration > 0 && d >= lo.MaxDuration {
+			return lo.MaxDuration
+		}
+	}
+	if lo.MaxDuration > 0 && d > lo.MaxDuration {
+		return lo.MaxDuration
+	}
+	return d
+}
+
+// classes counts the kinds of characters in s
+func classes(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+func sha1Hex(s string) string {
+	h := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
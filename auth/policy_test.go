@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	f, err := ioutil.TempFile("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// the second line is the SHA-1 of Tr0ub4dor&3
+	f.WriteString("Password123!\n" + sha1Hex("Tr0ub4dor&3") + ":4021\n\n")
+	f.Close()
+	if _, err = NewPolicy(fdc.Passwords{Cost: 3}, fdc.Lockout{}); err == nil {
+		t.Error("accepted a bcrypt cost below the minimum")
+	}
+	p, err := NewPolicy(fdc.Passwords{MinLength: 10, Classes: 3, Breached: f.Name(), Cost: bcrypt.MinCost}, fdc.Lockout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		password string
+		ok       bool
+	}{
+		{"Sh0rt!", false},
+		{"alllowercaseletters", false},
+		{"lower and UPPER", true},
+		{"Password123!", false},
+		{"Tr0ub4dor&3", false},
+		{"my name is Tester 1", false},
+		{strings.Repeat("aB3", 25), false},
+		{"correct Horse battery", true},
+	} {
+		if err := p.Check("tester", tt.password); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.password, err)
+		}
+	}
+}
+
+// attempts returns a lockout's number of attempts
+func attempts(n int) *int {
+	return &n
+}
+
+func TestLockedFor(t *testing.T) {
+	p := &Policy{Lockout: fdc.Lockout{Attempts: attempts(3), Duration: time.Minute, MaxDuration: 5 * time.Minute}}
+	for n, want := range []time.Duration{0, 0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if got := p.LockedFor(n); got != want {
+			t.Errorf("after %d failures got %v want %v", n, got, want)
+		}
+	}
+	if (&Policy{}).LockedFor(100) != 0 || (&Policy{Lockout: fdc.Lockout{Attempts: attempts(0), Duration: time.Minute}}).LockedFor(100) != 0 {
+		t.Error("locked without a lockout policy")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old := CurrentPolicy()
+	defer SetPolicy(old)
+	SetPolicy(&Policy{MinLength: 1, Cost: bcrypt.MinCost, Lockout: fdc.Lockout{Attempts: attempts(2), Duration: time.Hour}})
+	var events []string
+	defer func(f func(*gin.Context, Event)) { Events = f }(Events)
+	Events = func(c *gin.Context, e Event) { events = append(events, e.Type) }
+	stores := 0
+	defer func(f func(*gin.Context, ds.DataSource) ds.DataSource) { Store = f }(Store)
+	Store = func(c *gin.Context, d ds.DataSource) ds.DataSource {
+		stores++
+		return d
+	}
+
+	d := newMemDs()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost+1)
+	d.Update("USER:tester", User{ID: "USER:tester", Name: "tester", Password: string(hash), Role: "USER", Type: "USER"})
+	tk, err := NewTokens(hsConfig(strings.Repeat("k", 32), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u *User
+	r := gin.New()
+	r.POST("/login", u.UserMiddleware(tk, d).LoginHandler)
+	login := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"tester","password":"`+password+`"}`)))
+		return w
+	}
+	if w := login("secret"); w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	v, _ := FindUser("tester", d)
+	if cost, _ := bcrypt.Cost([]byte(v.Password)); cost != bcrypt.MinCost || !CheckPasswordHash("secret", v.Password) {
+		t.Errorf("password wasn't rehashed at cost %d", bcrypt.MinCost)
+	}
+	for _, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if w := login("guess"); w.Code != want {
+			t.Errorf("got %d want %d", w.Code, want)
+		}
+	}
+	w := login("secret")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("locked account logged in: %d", w.Code)
+	}
+	if got := strings.Join(events, " "); got != "login.succeeded login.failed login.failed login.locked login.locked login.locked" {
+		t.Errorf("got events %s", got)
+	}
+	if stores != 5 {
+		t.Errorf("logins used the request datastore %d times, want 5", stores)
+	}
+	// a reset unlocks the account
+	if err := setPassword(d, v, "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if w := login("new secret"); w.Code != http.StatusOK {
+		t.Errorf("got %d after a password reset", w.Code)
+	}
+}
+
+func TestCheckUsername(t *testing.T) {
+	for _, name := range []string{"alice", "bfpdadmin", "j.doe+fdc@example.com", "user_1-a"} {
+		if err := CheckUsername(name); err != nil {
+			t.Errorf("rejected %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-alice", `al"ice`, `alice\`, "USER:alice", "al ice", "../alice", strings.Repeat("a", 65)} {
+		if err := CheckUsername(name); err == nil {
+			t.Errorf("accepted %q", name)
+		}
+	}
+}
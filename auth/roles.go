@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Permissions which may be granted to a role
+const (
+	USERSREAD   = "users:read"
+	USERSWRITE  = "users:write"
+	FOODSWRITE  = "foods:write"
+	REPORTSRUN  = "reports:run"
+	DATAIMPORT  = "data:import"
+	AUDITREAD   = "audit:read"
+	maxRoles    = 100
+	rolesMaxAge = time.Minute
+)
+
+// Permissions lists every permission
+var Permissions = []string{USERSREAD, USERSWRITE, FOODSWRITE, REPORTSRUN, DATAIMPORT, AUDITREAD}
+
+// Role names a set of permissions.  Users are assigned one role.
+type Role struct {
+	ID          string   `json:"_id"`
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// DefaultRoles apply until a role of the same name is saved.  ADMIN always
+// has every permission so administrators can't lock themselves out.
+var DefaultRoles = map[string]Role{
+	"ADMIN":  {Name: "ADMIN", Description: "manages accounts and data", Permissions: Permissions},
+	"EDITOR": {Name: "EDITOR", Description: "curates foods", Permissions: []string{FOODSWRITE, REPORTSRUN}},
+	"USER":   {Name: "USER", Description: "manages their own foods, diaries and lists", Permissions: []string{}},
+}
+
+// Roles reads roles from the datastore and caches them briefly
+type Roles struct {
+	d      ds.DataSource
+	bucket string
+	cache  *roleCache
+}
+
+// roleCache is shared by the Roles which In returns
+type roleCache struct {
+	sync.Mutex
+	roles map[string]cachedRole
+}
+
+type cachedRole struct {
+	role Role
+	ok   bool
+	read time.Time
+}
+
+// NewRoles returns the roles stored in bucket of d
+func NewRoles(d ds.DataSource, bucket string) *Roles {
+	return &Roles{d: d, bucket: bucket, cache: &roleCache{roles: make(map[string]cachedRole)}}
+}
+
+// In returns roles which are read and written through d, sharing r's cache
+func (r *Roles) In(d ds.DataSource) *Roles {
+	return &Roles{d: d, bucket: r.bucket, cache: r.cache}
+}
+
+// RoleID returns the document key of a role
+func RoleID(name string) string {
+	var dt fdc.DocType
+	return fmt.Sprintf("%s:%s", dt.ToString(fdc.ROLE), name)
+}
+
+// ValidPermission returns true for known permissions
+func ValidPermission(p string) bool {
+	for _, v := range Permissions {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the named role
+func (r *Roles) Get(name string) (Role, bool) {
+	r.cache.Lock()
+	defer r.cache.Unlock()
+	if c, ok := r.cache.roles[name]; ok && time.Since(c.read) < rolesMaxAge {
+		return c.role, c.ok
+	}
+	var role Role
+	ok := true
+	if err := r.d.Get(RoleID(name), &role); err != nil {
+		role, ok = DefaultRoles[name]
+	}
+	if name == "ADMIN" {
+		role.Name, role.Permissions, ok = name, Permissions, true
+	}
+	r.cache.roles[name] = cachedRole{role: role, ok: ok, read: time.Now()}
+	return role, ok
+}
+
+// List returns the stored roles and the default roles which haven't been
+// replaced sorted by name
+func (r *Roles) List() ([]Role, error) {
+	var dt fdc.DocType
+	items, err := r.d.GetDictionary(r.bucket, dt.ToString(fdc.ROLE), 0, maxRoles)
+	if err != nil {
+		return nil, err
+	}
+	roles := []Role{}
+	seen := make(map[string]bool)
+	for _, i := range items {
+		if v, ok := i.(Role); ok {
+			roles = append(roles, v)
+			seen[v.Name] = true
+		}
+	}
+	for n, v := range DefaultRoles {
+		if !seen[n] {
+			roles = append(roles, v)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// Save stores a role.  Permissions must be known and ADMIN can't be changed.
+func (r *Roles) Save(role Role) (Role, error) {
+	var dt fdc.DocType
+	if role.Name == "" {
+		return role, errors.New("role name is required")
+	}
+	if role.Name == "ADMIN" {
+		return role, errors.New("the ADMIN role can't be changed")
+	}
+	for _, p := range role.Permissions {
+		if !ValidPermission(p) {
+			return role, fmt.Errorf("unknown permission %s", p)
+		}
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	role.ID = RoleID(role.Name)
+	role.Type = dt.ToString(fdc.ROLE)
+	if err := r.d.Update(role.ID, role); err != nil {
+		return role, err
+	}
+	r.forget(role.Name)
+	return role, nil
+}
+
+// Delete removes a stored role.  Default roles revert to their defaults.
+func (r *Roles) Delete(name string) error {
+	if err := r.d.Remove(RoleID(name)); err != nil {
+		return err
+	}
+	r.forget(name)
+	return nil
+}
+
+// Allowed returns true if the named role has been granted perm
+func (r *Roles) Allowed(name, perm string) bool {
+	role, ok := r.Get(name)
+	if !ok {
+		return false
+	}
+	for _, p := range role.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers returns true if the named role has been granted every one of perms
+func (r *Roles) Covers(name string, perms []string) bool {
+	for _, p := range perms {
+		if !r.Allowed(name, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Grants returns true if users with the role by may assign the role name,
+// which must grant nothing that by doesn't
+func (r *Roles) Grants(by, name string) bool {
+	role, ok := r.Get(name)
+	return ok && r.Covers(by, role.Permissions)
+}
+
+// Permit rejects requests from users whose role hasn't been granted perm
+func (r *Roles) Permit(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if u, ok := CurrentUser(c); !ok || !r.In(Store(c, r.d)).Allowed(u.Role, perm) {
+			if ok {
+				Record(c, ACCESSDENIED, u.Name, perm+" permission is required")
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": fmt.Sprintf("%s permission is required", perm),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (r *Roles) forget(name string) {
+	r.cache.Lock()
+	delete(r.cache.roles, name)
+	r.cache.Unlock()
+}
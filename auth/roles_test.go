@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoles(t *testing.T) {
+	r := NewRoles(newMemDs(), "test")
+	if !r.Allowed("ADMIN", DATAIMPORT) || !r.Allowed("EDITOR", FOODSWRITE) {
+		t.Error("default roles lack their permissions")
+	}
+	if r.Allowed("EDITOR", USERSWRITE) || r.Allowed("USER", FOODSWRITE) || r.Allowed("NOBODY", USERSREAD) {
+		t.Error("permission granted to the wrong role")
+	}
+	if _, err := r.Save(Role{Name: "ADMIN"}); err == nil {
+		t.Error("ADMIN was changed")
+	}
+	if _, err := r.Save(Role{Name: "AUDITOR", Permissions: []string{"users:delete"}}); err == nil {
+		t.Error("unknown permission was saved")
+	}
+	if _, err := r.Save(Role{Name: "EDITOR", Permissions: []string{USERSREAD}}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Allowed("EDITOR", FOODSWRITE) || !r.Allowed("EDITOR", USERSREAD) {
+		t.Error("saved role doesn't replace the default")
+	}
+	if _, err := r.Save(Role{Name: "AUDITOR", Permissions: []string{USERSREAD, REPORTSRUN}}); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range roles {
+		names = append(names, v.Name)
+	}
+	if len(names) != 4 || names[0] != "ADMIN" || names[1] != "AUDITOR" || names[2] != "EDITOR" {
+		t.Errorf("got roles %v", names)
+	}
+	if err := r.Delete("EDITOR"); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Allowed("EDITOR", FOODSWRITE) {
+		t.Error("deleted default role didn't revert")
+	}
+}
+
+func TestGrants(t *testing.T) {
+	r := NewRoles(newMemDs(), "test")
+	if _, err := r.Save(Role{Name: "USERADMIN", Permissions: []string{USERSREAD, USERSWRITE}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"USERADMIN", "USER"} {
+		if !r.Grants("USERADMIN", name) {
+			t.Errorf("USERADMIN can't grant %s", name)
+		}
+	}
+	for _, name := range []string{"ADMIN", "EDITOR", "NOBODY"} {
+		if r.Grants("USERADMIN", name) {
+			t.Errorf("USERADMIN can grant %s", name)
+		}
+	}
+	if !r.Grants("ADMIN", "EDITOR") || r.Covers("EDITOR", []string{FOODSWRITE, USERSWRITE}) {
+		t.Error("got the wrong cover of permissions")
+	}
+}
+
+func TestPermit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := NewRoles(newMemDs(), "test")
+	for _, tt := range []struct {
+		user *User
+		want int
+	}{
+		{nil, http.StatusForbidden},
+		{&User{Name: "u", Role: "USER"}, http.StatusForbidden},
+		{&User{Name: "e", Role: "EDITOR"}, http.StatusOK},
+		{&User{Name: "a", Role: "ADMIN"}, http.StatusOK},
+	} {
+		u := tt.user
+		g := gin.New()
+		g.GET("/", func(c *gin.Context) {
+			if u != nil {
+				c.Set(identityKey, u)
+			}
+		}, r.Permit(FOODSWRITE), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != tt.want {
+			t.Errorf("%v: got %d want %d", u, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// minSecret is the shortest secret accepted for HS algorithms
+const minSecret = 32
+
+// sampleSecret is the placeholder secret of the sample configurations which
+// is refused so a server is never deployed with a published secret
+const sampleSecret = "change_me_to_at_least_32_characters"
+
+// curves are the curves of the ES algorithms
+var curves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+var (
+	// ErrUnknownKey is returned for tokens signed by a key which isn't
+	// configured
+	ErrUnknownKey = errors.New("token is signed by an unknown key")
+	// ErrInvalidSigningAlgorithm is returned for tokens signed with an
+	// algorithm other than their key's
+	ErrInvalidSigningAlgorithm = errors.New("invalid signing algorithm")
+	// ErrInvalidClaims is returned for tokens with the wrong issuer or
+	// audience
+	ErrInvalidClaims = errors.New("token has an invalid issuer or audience")
+	// ErrRefreshExpired is returned for tokens too old to refresh
+	ErrRefreshExpired = errors.New("token is too old to refresh")
+)
+
+// Key signs or verifies tokens.  Keys which only verify have no signing key.
+type Key struct {
+	ID        string
+	Algorithm string
+	sign      interface{}
+	verify    interface{}
+}
+
+// Tokens issues and verifies JWTs.  Tokens are signed by one key and may
+// be verified by any configured key named by the token's kid header so keys
+// can be rotated without logging out every user.
+type Tokens struct {
+	Timeout    time.Duration
+	MaxRefresh time.Duration
+	Issuer     string
+	Audience   string
+	signer     *Key
+	keys       map[string]*Key
+}
+
+// JWK is a JSON Web Key describing an RSA or ECDSA public key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the JSON Web Key Set of the public keys which verify tokens
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewTokens loads the signing and verification keys of cfg
+func NewTokens(cfg fdc.JWT) (*Tokens, error) {
+	t := Tokens{
+		Timeout:    cfg.Timeout,
+		MaxRefresh: cfg.MaxRefresh,
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		keys:       make(map[string]*Key),
+	}
+	k, err := loadKey(cfg.KeyID, cfg.Algorithm, cfg.Secret, cfg.PrivateKey, true)
+	if err != nil {
+		return nil, err
+	}
+	t.signer = k
+	t.keys[k.ID] = k
+	for _, v := range cfg.VerifyKeys {
+		k, err := loadKey(v.KeyID, v.Algorithm, v.Secret, v.PublicKey, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := t.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %s", k.ID)
+		}
+		t.keys[k.ID] = k
+	}
+	return &t, nil
+}
+
+// Generate signs a token with claims which expires after Timeout
+func (t *Tokens) Generate(claims jwt.MapClaims) (string, time.Time, error) {
+	now := time.Now()
+	expire := now.Add(t.Timeout)
+	claims["exp"] = expire.Unix()
+	claims["iat"] = now.Unix()
+	if _, ok := claims["orig_iat"]; !ok {
+		claims["orig_iat"] = now.Unix()
+	}
+	if t.Issuer != "" {
+		claims["iss"] = t.Issuer
+	}
+	if t.Audience != "" {
+		claims["aud"] = t.Audience
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(t.signer.Algorithm), claims)
+	token.Header["kid"] = t.signer.ID
+	s, err := token.SignedString(t.signer.sign)
+	return s, expire, err
+}
+
+// Parse verifies a token and returns its claims
+func (t *Tokens) Parse(s string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(s, t.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if !t.validClaims(claims) {
+		return nil, ErrInvalidClaims
+	}
+	return claims, nil
+}
+
+// Refreshable verifies a valid or expired token which was first issued less
+// than MaxRefresh ago and returns its claims
+func (t *Tokens) Refreshable(s string) (jwt.MapClaims, error) {
+	p := jwt.Parser{SkipClaimsValidation: true}
+	token, err := p.Parse(s, t.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if !t.validClaims(claims) {
+		return nil, ErrInvalidClaims
+	}
+	orig, ok := claims["orig_iat"].(float64)
+	if !ok || time.Now().After(time.Unix(int64(orig), 0).Add(t.MaxRefresh)) {
+		return nil, ErrRefreshExpired
+	}
+	return claims, nil
+}
+
+// Refresh issues a new token for a Refreshable token.  The new token keeps
+// the orig_iat of the first so a chain of refreshes ends MaxRefresh after
+// the login.
+func (t *Tokens) Refresh(s string) (string, time.Time, error) {
+	claims, err := t.Refreshable(s)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	fresh := jwt.MapClaims{}
+	for k, v := range claims {
+		fresh[k] = v
+	}
+	return t.Generate(fresh)
+}
+
+// JWKS returns the public keys which verify tokens.  Shared secrets are
+// never published.
+func (t *Tokens) JWKS() JWKSet {
+	s := JWKSet{Keys: []JWK{}}
+	for _, k := range t.keys {
+		if j, ok := jwk(k.verify); ok {
+			j.Kid, j.Alg, j.Use = k.ID, k.Algorithm, "sig"
+			s.Keys = append(s.Keys, j)
+		}
+	}
+	sort.Slice(s.Keys, func(i, j int) bool { return s.Keys[i].Kid < s.Keys[j].Kid })
+	return s
+}
+
+// keyFunc finds the key named by a token's kid.  Tokens without a kid are
+// verified by the signing key.
+func (t *Tokens) keyFunc(token *jwt.Token) (interface{}, error) {
+	k := t.signer
+	if kid, ok := token.Header["kid"].(string); ok {
+		if k, ok = t.keys[kid]; !ok {
+			return nil, ErrUnknownKey
+		}
+	}
+	if token.Method.Alg() != k.Algorithm {
+		return nil, ErrInvalidSigningAlgorithm
+	}
+	return k.verify, nil
+}
+
+func (t *Tokens) validClaims(claims jwt.MapClaims) bool {
+	if t.Issuer != "" && !claims.VerifyIssuer(t.Issuer, true) {
+		return false
+	}
+	if t.Audience != "" && !claims.VerifyAudience(t.Audience, true) {
+		return false
+	}
+	return true
+}
+
+// loadKey reads a key for alg from secret or a PEM file.  Signing keys are
+// read from a private key file, others from a public key file.
+func loadKey(id, alg, secret, file string, signing bool) (*Key, error) {
+	if jwt.GetSigningMethod(alg) == nil || strings.HasPrefix(alg, "PS") || alg == "none" {
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	k := Key{ID: id, Algorithm: alg}
+	if strings.HasPrefix(alg, "HS") {
+		if len(secret) < minSecret {
+			return nil, fmt.Errorf("%s needs a secret of at least %d characters", alg, minSecret)
+		}
+		if secret == sampleSecret {
+			return nil, errors.New("the JWT secret is the sample placeholder, set a secret of your own")
+		}
+		k.sign, k.verify = []byte(secret), []byte(secret)
+		if k.ID == "" {
+			h := sha256.Sum256([]byte(secret))
+			k.ID = hex.EncodeToString(h[:8])
+		}
+		return &k, nil
+	}
+	if file == "" {
+		return nil, fmt.Errorf("%s needs a key file", alg)
+	}
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(alg, "RS") && signing:
+		var p *rsa.PrivateKey
+		if p, err = jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+			k.sign, k.verify = p, &p.PublicKey
+		}
+	case strings.HasPrefix(alg, "RS"):
+		k.verify, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+	case signing:
+		var p *ecdsa.PrivateKey
+		if p, err = jwt.ParseECPrivateKeyFromPEM(pem); err == nil {
+			k.sign, k.verify = p, &p.PublicKey
+		}
+	default:
+		k.verify, err = jwt.ParseECPublicKeyFromPEM(pem)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s key %s: %v", alg, file, err)
+	}
+	if e, ok := k.verify.(*ecdsa.PublicKey); ok && curves[alg] != e.Curve.Params().Name {
+		return nil, fmt.Errorf("%s needs a %s key", alg, curves[alg])
+	}
+	if k.ID == "" {
+		k.ID = thumbprint(k.verify)
+	}
+	return &k, nil
+}
+
+// jwk describes an RSA or ECDSA public key
+func jwk(key interface{}) (JWK, bool) {
+	switch p := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(p.N.Bytes()), E: b64(big.NewInt(int64(p.E)).Bytes())}, true
+	case *ecdsa.PublicKey:
+		size := (p.Curve.Params().BitSize + 7) / 8
+		return JWK{Kty: "EC", Crv: p.Curve.Params().Name, X: b64(pad(p.X.Bytes(), size)), Y: b64(pad(p.Y.Bytes(), size))}, true
+	}
+	return JWK{}, false
+}
+
+// thumbprint is the RFC 7638 thumbprint of a public key
+func thumbprint(key interface{}) string {
+	j, _ := jwk(key)
+	var m map[string]string
+	if j.Kty == "RSA" {
+		m = map[string]string{"e": j.E, "kty": j.Kty, "n": j.N}
+	} else {
+		m = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X, "y": j.Y}
+	}
+	// maps are marshaled with sorted keys and no white space
+	b, _ := json.Marshal(m)
+	h := sha256.Sum256(b)
+	return b64(h[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
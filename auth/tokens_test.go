@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+const (
+	oldSecret = "an old secret of at least 32 characters"
+	newSecret = "a new secret of at least 32 characters."
+)
+
+func hsConfig(secret, kid string) fdc.JWT {
+	return fdc.JWT{Algorithm: "HS256", Secret: secret, KeyID: kid, Timeout: time.Hour, MaxRefresh: time.Hour}
+}
+
+// keyFiles writes the PEM private and public keys of p to dir
+func keyFiles(t *testing.T, dir, name string, p interface{}, pub interface{}) (string, string) {
+	var der []byte
+	var err error
+	typ := "RSA PRIVATE KEY"
+	switch k := p.(type) {
+	case *rsa.PrivateKey:
+		der = x509.MarshalPKCS1PrivateKey(k)
+	case *ecdsa.PrivateKey:
+		typ = "EC PRIVATE KEY"
+		if der, err = x509.MarshalECPrivateKey(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	priv := filepath.Join(dir, name+".pem")
+	if err := ioutil.WriteFile(priv, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if der, err = x509.MarshalPKIXPublicKey(pub); err != nil {
+		t.Fatal(err)
+	}
+	public := filepath.Join(dir, name+".pub")
+	if err := ioutil.WriteFile(public, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return priv, public
+}
+
+func TestNewTokensErrors(t *testing.T) {
+	for _, cfg := range []fdc.JWT{
+		hsConfig("secret key", ""),
+		hsConfig(sampleSecret, ""),
+		{Algorithm: "none", Secret: oldSecret},
+		{Algorithm: "RS256"},
+		{Algorithm: "ES256", PrivateKey: "/no/such/file"},
+		{Algorithm: "HS256", Secret: oldSecret, KeyID: "a", VerifyKeys: []fdc.JWTKey{{KeyID: "a", Algorithm: "HS256", Secret: newSecret}}},
+	} {
+		if _, err := NewTokens(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestTokensRotation(t *testing.T) {
+	old, err := NewTokens(hsConfig(oldSecret, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, err := old.Generate(jwt.MapClaims{"name": "tester"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := hsConfig(newSecret, "")
+	rotated, err := NewTokens(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Parse(s); err == nil || err.Error() != ErrUnknownKey.Error() {
+		t.Errorf("got %v for a token signed by an unknown key", err)
+	}
+	cfg.VerifyKeys = []fdc.JWTKey{{Algorithm: "HS256", Secret: oldSecret}}
+	if rotated, err = NewTokens(cfg); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := rotated.Parse(s)
+	if err != nil || claims["name"] != "tester" {
+		t.Errorf("retired key doesn't verify its token: %v %v", claims, err)
+	}
+	fresh, _, err := rotated.Refresh(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Parse(fresh); err == nil {
+		t.Error("refreshed token is signed by the retired key")
+	}
+	if len(rotated.JWKS().Keys) != 0 {
+		t.Error("shared secrets are published")
+	}
+}
+
+func TestTokensClaims(t *testing.T) {
+	cfg := hsConfig(oldSecret, "k1")
+	cfg.Issuer, cfg.Audience = "fdc", "partners"
+	tk, err := NewTokens(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, _ := tk.Generate(jwt.MapClaims{})
+	if _, err := tk.Parse(s); err != nil {
+		t.Errorf("got %v", err)
+	}
+	cfg.Audience = "others"
+	other, _ := NewTokens(cfg)
+	if _, err := other.Parse(s); err != ErrInvalidClaims {
+		t.Errorf("got %v for the wrong audience", err)
+	}
+	tk.Timeout = -time.Minute
+	expired, _, _ := tk.Generate(jwt.MapClaims{"orig_iat": time.Now().Add(-10 * time.Minute).Unix()})
+	if _, err := tk.Parse(expired); err == nil {
+		t.Error("expired token was accepted")
+	}
+	fresh, _, err := tk.Refresh(expired)
+	if err != nil {
+		t.Errorf("expired token wasn't refreshed: %v", err)
+	}
+	first, _ := jwt.NewParser(jwt.WithoutClaimsValidation()).Parse(expired, tk.keyFunc)
+	next, _ := jwt.NewParser(jwt.WithoutClaimsValidation()).Parse(fresh, tk.keyFunc)
+	if next == nil || next.Claims.(jwt.MapClaims)["orig_iat"] != first.Claims.(jwt.MapClaims)["orig_iat"] {
+		t.Error("refresh moved orig_iat so tokens can be refreshed forever")
+	}
+	tk.MaxRefresh = -time.Minute
+	if _, _, err := tk.Refresh(expired); err != ErrRefreshExpired {
+		t.Errorf("got %v for a token too old to refresh", err)
+	}
+}
+
+func TestTokensAsymmetric(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPriv, rsaPub := keyFiles(t, dir, "rsa", r, &r.PublicKey)
+	e, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPriv, ecPub := keyFiles(t, dir, "ec", e, &e.PublicKey)
+
+	if _, err := NewTokens(fdc.JWT{Algorithm: "ES384", PrivateKey: ecPriv}); err == nil {
+		t.Error("expected an error for a P-256 key with ES384")
+	}
+	old, err := NewTokens(fdc.JWT{Algorithm: "RS256", PrivateKey: rsaPriv, Timeout: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, _ := old.Generate(jwt.MapClaims{})
+	tk, err := NewTokens(fdc.JWT{Algorithm: "ES256", PrivateKey: ecPriv, Timeout: time.Hour,
+		VerifyKeys: []fdc.JWTKey{{Algorithm: "RS256", PublicKey: rsaPub}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tk.Parse(s); err != nil {
+		t.Errorf("retired RSA key doesn't verify: %v", err)
+	}
+	es, _, _ := tk.Generate(jwt.MapClaims{})
+	verifier, err := NewTokens(fdc.JWT{Algorithm: "HS256", Secret: oldSecret,
+		VerifyKeys: []fdc.JWTKey{{Algorithm: "ES256", PublicKey: ecPub}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Parse(es); err != nil {
+		t.Errorf("public EC key doesn't verify: %v", err)
+	}
+	set := tk.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys", len(set.Keys))
+	}
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			if k.N == "" || k.E != "AQAB" || k.Alg != "RS256" {
+				t.Errorf("got %+v", k)
+			}
+		case "EC":
+			if k.Crv != "P-256" || len(k.X) != 43 || len(k.Y) != 43 || k.Alg != "ES256" {
+				t.Errorf("got %+v", k)
+			}
+		}
+		if k.Kid == "" || k.Use != "sig" {
+			t.Errorf("got %+v", k)
+		}
+	}
+}
+
+func TestMiddlewareFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tk, err := NewTokens(hsConfig(oldSecret, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u *User
+	d := newMemDs()
+	d.Update("USER:root", User{ID: "USER:root", Name: "root", Role: "ADMIN", Type: "USER"})
+	d.Update("USER:tester", User{ID: "USER:tester", Name: "tester", Role: "USER", Type: "USER"})
+	admin, _, _ := tk.Generate(jwt.MapClaims{identityKey: "ADMIN", nameKey: "root"})
+	user, _, _ := tk.Generate(jwt.MapClaims{identityKey: "USER", nameKey: "tester"})
+	promoted, _, _ := tk.Generate(jwt.MapClaims{identityKey: "ADMIN", nameKey: "tester"})
+	removed, _, _ := tk.Generate(jwt.MapClaims{identityKey: "ADMIN", nameKey: "gone"})
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{identityKey: "ADMIN"}).SignedString([]byte("secret key"))
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Token " + admin, http.StatusUnauthorized},
+		{"Bearer " + forged, http.StatusUnauthorized},
+		{"Bearer " + user, http.StatusForbidden},
+		{"Bearer " + promoted, http.StatusForbidden},
+		{"Bearer " + removed, http.StatusUnauthorized},
+		{"Bearer " + admin, http.StatusOK},
+	}
+	r := gin.New()
+	r.GET("/", u.AuthMiddleware(tk, d).MiddlewareFunc(), func(c *gin.Context) {
+		if v, ok := CurrentUser(c); !ok || v.Name != "root" {
+			t.Errorf("got user %v", v)
+		}
+		c.Status(http.StatusOK)
+	})
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%.20s: got %d want %d", tt.header, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRevokedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tk, err := NewTokens(hsConfig(oldSecret, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u *User
+	d := newMemDs()
+	hash, _ := HashPassword("old password")
+	d.Update("USER:tester", User{ID: "USER:tester", Name: "tester", Password: hash, Role: "USER", Type: "USER"})
+	token, _, _ := tk.Generate(jwt.MapClaims{identityKey: "USER", nameKey: "tester", versionKey: 0})
+	mw := u.UserMiddleware(tk, d)
+	r := gin.New()
+	r.GET("/", mw.MiddlewareFunc(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/refresh", mw.RefreshHandler)
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if get("/") != http.StatusOK || get("/refresh") != http.StatusOK {
+		t.Fatal("token was refused before the password changed")
+	}
+	if err := ChangePassword(d, "tester", "old password", "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/"); code != http.StatusUnauthorized {
+		t.Errorf("got %d for a token issued before a password change", code)
+	}
+	if code := get("/refresh"); code != http.StatusUnauthorized {
+		t.Errorf("refreshed a token issued before a password change: %d", code)
+	}
+}
@@ -0,0 +1,152 @@
+// Package diary computes the nutrients in the foods users record in their
+// food diaries and totals them by day against Daily Value profiles
+package diary
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// ADULT and CHILD name the Daily Value profiles
+const (
+	ADULT = "adult"
+	CHILD = "child"
+)
+
+// DateFormat is the layout of diary dates
+const DateFormat = "2006-01-02"
+
+// Profile is a set of Daily Values keyed by nutrient number
+type Profile struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Values      map[int]float64 `json:"values"`
+}
+
+// Profiles are the FDA Daily Values used for nutrition labeling
+var Profiles = map[string]Profile{
+	ADULT: {ADULT, "adults and children 4 years and older, 2000 kcal diet", map[int]float64{
+		208: 2000, 204: 78, 606: 20, 601: 300, 307: 2300, 205: 275, 291: 28, 539: 50,
+		203: 50, 328: 20, 301: 1300, 303: 18, 306: 4700, 320: 900, 401: 90,
+	}},
+	CHILD: {CHILD, "children 1 through 3 years, 1000 kcal diet", map[int]float64{
+		208: 1000, 204: 39, 606: 10, 601: 300, 307: 1500, 205: 150, 291: 14, 539: 25,
+		203: 13, 328: 15, 301: 700, 303: 7, 306: 3000, 320: 300, 401: 15,
+	}},
+}
+
+// Meals are the valid meals of an entry
+var Meals = []string{fdc.BREAKFAST, fdc.LUNCH, fdc.DINNER, fdc.SNACK}
+
+// Grams returns the weight of an amount of a food.  The amount is a number of
+// the food's named serving or grams if serving is empty.
+func Grams(f *fdc.Food, serving string, amount float64) (float64, error) {
+	if amount <= 0 {
+		return 0, errors.New("amount must be greater than 0")
+	}
+	if serving == "" {
+		return amount, nil
+	}
+	var names []string
+	for _, s := range f.Servings {
+		if strings.EqualFold(s.Description, serving) {
+			units := float64(s.Servingamount)
+			if units <= 0 {
+				units = 1
+			}
+			return amount * float64(s.Weight) / units, nil
+		}
+		names = append(names, s.Description)
+	}
+	return 0, fmt.Errorf("food %s has no %s serving, use one of %v or grams", f.FdcID, serving, names)
+}
+
+// CheckMeal returns an error for a meal which is not one of Meals
+func CheckMeal(meal string) error {
+	if meal == "" {
+		return nil
+	}
+	for _, m := range Meals {
+		if meal == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("meal %s must be one of %v", meal, Meals)
+}
+
+// Consumed returns the amounts of nutrients in grams of a food given its
+// nutrient data per 100 g
+func Consumed(nd []fdc.NutrientData, grams float64) []fdc.DiaryNutrient {
+	m := make(map[int]fdc.DiaryNutrient)
+	for _, n := range nd {
+		m[int(n.Nutrientno)] = fdc.DiaryNutrient{Nutrientno: int(n.Nutrientno), Name: n.Nutrient, Unit: n.Unit, Value: n.Value * grams / 100}
+	}
+	return sorted(m)
+}
+
+// Days groups entries by the date of their timestamps, in the time zone they
+// were recorded in, and totals their nutrients.  Dates from from to to with
+// no entries are included.
+func Days(entries []fdc.DiaryEntry, from time.Time, to time.Time, p Profile) []fdc.DiaryDay {
+	byDate := make(map[string][]fdc.DiaryEntry)
+	for _, e := range entries {
+		d := e.Time.Format(DateFormat)
+		byDate[d] = append(byDate[d], e)
+	}
+	days := []fdc.DiaryDay{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		date := d.Format(DateFormat)
+		day := fdc.DiaryDay{Date: date, Entries: byDate[date]}
+		if day.Entries == nil {
+			day.Entries = []fdc.DiaryEntry{}
+		}
+		sort.Slice(day.Entries, func(i, j int) bool { return day.Entries[i].Time.Before(day.Entries[j].Time) })
+		day.Nutrients = Totals(day.Entries, p)
+		days = append(days, day)
+	}
+	return days
+}
+
+// Totals sums the nutrients of entries and compares them with a profile's
+// Daily Values
+func Totals(entries []fdc.DiaryEntry, p Profile) []fdc.DiaryNutrient {
+	m := make(map[int]fdc.DiaryNutrient)
+	for _, e := range entries {
+		for _, n := range e.Nutrients {
+			t := m[n.Nutrientno]
+			t.Nutrientno, t.Name, t.Unit = n.Nutrientno, n.Name, n.Unit
+			t.Value += n.Value
+			m[n.Nutrientno] = t
+		}
+	}
+	for no, t := range m {
+		if dv, ok := p.Values[no]; ok {
+			t.DailyValue = dv
+			t.PercentDailyValue = round(100 * t.Value / dv)
+			m[no] = t
+		}
+	}
+	return sorted(m)
+}
+
+// sorted returns nutrients ordered by nutrient number with values rounded
+func sorted(m map[int]fdc.DiaryNutrient) []fdc.DiaryNutrient {
+	n := []fdc.DiaryNutrient{}
+	for _, v := range m {
+		v.Value = round(v.Value)
+		n = append(n, v)
+	}
+	sort.Slice(n, func(i, j int) bool { return n[i].Nutrientno < n[j].Nutrientno })
+	return n
+}
+
+// round rounds to 3 decimal places
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,57 @@
+package diary
+
+import (
+	"testing"
+	"time"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestGrams(t *testing.T) {
+	f := &fdc.Food{FdcID: "1", Servings: []fdc.Serving{{Description: "cup", Servingamount: 1, Weight: 240}, {Description: "tbsp", Servingamount: 2, Weight: 30}}}
+	for _, tt := range []struct {
+		serving string
+		amount  float64
+		grams   float64
+	}{{"", 50, 50}, {"cup", 2, 480}, {"TBSP", 1, 15}} {
+		if g, err := Grams(f, tt.serving, tt.amount); err != nil || g != tt.grams {
+			t.Errorf("Grams(%s, %v) = %v %v, want %v", tt.serving, tt.amount, g, err, tt.grams)
+		}
+	}
+	if _, err := Grams(f, "slice", 1); err == nil {
+		t.Error("Expecting an error for an unknown serving")
+	}
+	if _, err := Grams(f, "", 0); err == nil {
+		t.Error("Expecting an error for a zero amount")
+	}
+}
+
+func TestDays(t *testing.T) {
+	nd := []fdc.NutrientData{{Nutrientno: 307, Nutrient: "Sodium, Na", Unit: "mg", Value: 460}, {Nutrientno: 255, Nutrient: "Water", Unit: "g", Value: 50}}
+	est := time.FixedZone("EST", -5*3600)
+	entries := []fdc.DiaryEntry{
+		{FdcID: "1", Time: time.Date(2020, 3, 1, 20, 0, 0, 0, est), Nutrients: Consumed(nd, 200)},
+		{FdcID: "1", Time: time.Date(2020, 3, 1, 8, 0, 0, 0, est), Nutrients: Consumed(nd, 50)},
+	}
+	from := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	days := Days(entries, from, from.AddDate(0, 0, 1), Profiles[ADULT])
+	if len(days) != 2 || len(days[0].Entries) != 2 || len(days[1].Entries) != 0 {
+		t.Fatalf("Expecting 2 entries on the first of 2 days got %+v", days)
+	}
+	if !days[0].Entries[0].Time.Before(days[0].Entries[1].Time) {
+		t.Error("Expecting entries in time order")
+	}
+	sodium := days[0].Nutrients[1]
+	if sodium.Nutrientno != 307 || sodium.Value != 1150 || sodium.DailyValue != 2300 || sodium.PercentDailyValue != 50 {
+		t.Errorf("Expecting 1150 mg sodium, 50%% of the Daily Value, got %+v", sodium)
+	}
+	if water := days[0].Nutrients[0]; water.DailyValue != 0 || water.Value != 125 {
+		t.Errorf("Expecting 125 g water without a Daily Value got %+v", water)
+	}
+}
+
+func TestCheckMeal(t *testing.T) {
+	if CheckMeal(fdc.LUNCH) != nil || CheckMeal("") != nil || CheckMeal("brunch") == nil {
+		t.Error("Unexpected meal validation")
+	}
+}
@@ -0,0 +1,209 @@
+// Package diet classifies foods into dietary categories such as vegan or
+// low sodium using rules which test ingredients, allergens and nutrient
+// values.  Rules are read from YAML so claims can be added without code
+// changes.
+package diet
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/prLorence/fdc-api/allergen"
+	"github.com/prLorence/fdc-api/ingredient"
+	fdc "github.com/prLorence/fdc-api/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SERVING and PER100 are the bases of nutrient thresholds
+const (
+	SERVING = "serving"
+	PER100  = "100g"
+)
+
+// Rule describes a dietary classification.  A food satisfies a rule if it
+// has an ingredient statement which names none of the Exclude ingredients and
+// none of the Allergens, when either list is given, and if it has values
+// within every nutrient Threshold.
+type Rule struct {
+	Name        string      `yaml:"name" json:"name"`
+	Description string      `yaml:"description" json:"description,omitempty"`
+	Exclude     []string    `yaml:"exclude" json:"exclude,omitempty"`
+	Allergens   []string    `yaml:"allergens" json:"allergens,omitempty"`
+	Nutrients   []Threshold `yaml:"nutrients" json:"nutrients,omitempty"`
+}
+
+// Threshold bounds the value of a nutrient per serving or per 100 units.
+// The values of any Minus nutrients are subtracted first, e.g. fiber from
+// carbohydrate for net carbs.
+type Threshold struct {
+	Nutrient int      `yaml:"nutrient" json:"nutrientno"`
+	Minus    []int    `yaml:"minus" json:"minus,omitempty"`
+	Basis    string   `yaml:"basis" json:"basis"`
+	Min      *float64 `yaml:"min" json:"min,omitempty"`
+	Max      *float64 `yaml:"max" json:"max,omitempty"`
+}
+
+// Values are the amounts of a nutrient in a food per 100 units and per
+// serving.  HasServing is false for foods without a serving size.
+type Values struct {
+	Per100     float64
+	Serving    float64
+	HasServing bool
+}
+
+// Rules is an ordered list of dietary classifications
+type Rules []Rule
+
+// Load returns the default rules with any rules in a YAML file added.  A rule
+// in the file replaces a default rule of the same name.
+func Load(path string) (Rules, error) {
+	rules, err := Parse([]byte(Defaults))
+	if err != nil || path == "" {
+		return rules, err
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	more, err := Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, m := range more {
+		replaced := false
+		for i := range rules {
+			if rules[i].Name == m.Name {
+				rules[i] = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			rules = append(rules, m)
+		}
+	}
+	return rules, nil
+}
+
+// Parse reads a list of rules from YAML and validates them
+func Parse(raw []byte) (Rules, error) {
+	var rules Rules
+	if err := yaml.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Name == "" {
+			return nil, errors.New("every rule requires a name")
+		}
+		if len(r.Exclude) == 0 && len(r.Allergens) == 0 && len(r.Nutrients) == 0 {
+			return nil, fmt.Errorf("rule %s has no ingredients, allergens or nutrients", r.Name)
+		}
+		for j, a := range r.Allergens {
+			n, ok := allergen.Lookup(a)
+			if !ok {
+				return nil, fmt.Errorf("rule %s has an unrecognized allergen %s", r.Name, a)
+			}
+			r.Allergens[j] = n
+		}
+		for j := range r.Nutrients {
+			t := &r.Nutrients[j]
+			if t.Basis == "" {
+				t.Basis = SERVING
+			}
+			if t.Nutrient <= 0 || t.Basis != SERVING && t.Basis != PER100 || t.Min == nil && t.Max == nil {
+				return nil, fmt.Errorf("rule %s requires a nutrient, a basis of %s or %s and a min or max for each threshold", r.Name, SERVING, PER100)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Find returns the rule with a name
+func (rules Rules) Find(name string) (Rule, bool) {
+	for _, r := range rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Classify returns the names of the rules a food satisfies given its
+// nutrient values keyed by nutrient number
+func (rules Rules) Classify(f *fdc.Food, nutrients map[int]Values) []string {
+	d := []string{}
+	tokens := f.IngredientTokens
+	if len(tokens) == 0 {
+		tokens = ingredient.Parse(f.Ingredients)
+	}
+	allergens := f.Allergens
+	if allergens == nil {
+		allergens = allergen.Detect(f).Allergens
+	}
+	for _, r := range rules {
+		if r.satisfied(tokens, allergens, nutrients) {
+			d = append(d, r.Name)
+		}
+	}
+	return d
+}
+
+// satisfied reports whether ingredient tokens, allergens and nutrient values
+// meet a rule.  Foods without the data a rule tests never satisfy it.
+func (r Rule) satisfied(tokens []string, allergens []string, nutrients map[int]Values) bool {
+	if len(r.Exclude) > 0 || len(r.Allergens) > 0 {
+		if len(tokens) == 0 {
+			return false
+		}
+		for _, x := range r.Exclude {
+			if ingredient.Contains(tokens, x) {
+				return false
+			}
+		}
+		for _, a := range r.Allergens {
+			for _, fa := range allergens {
+				if a == fa {
+					return false
+				}
+			}
+		}
+	}
+	for _, t := range r.Nutrients {
+		v, ok := t.value(nutrients)
+		if !ok || t.Min != nil && v < *t.Min || t.Max != nil && v > *t.Max {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns the amount of a threshold's nutrient less any Minus nutrients
+func (t Threshold) value(nutrients map[int]Values) (float64, bool) {
+	amount := func(n Values) float64 {
+		if t.Basis == PER100 {
+			return n.Per100
+		}
+		return n.Serving
+	}
+	n, ok := nutrients[t.Nutrient]
+	if !ok || t.Basis == SERVING && !n.HasServing {
+		return 0, false
+	}
+	v := amount(n)
+	for _, m := range t.Minus {
+		if mn, ok := nutrients[m]; ok {
+			v -= amount(mn)
+		}
+	}
+	return v, true
+}
+
+// Names returns the names of the rules
+func (rules Rules) Names() []string {
+	var n []string
+	for _, r := range rules {
+		n = append(n, r.Name)
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package diet
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestClassify(t *testing.T) {
+	rules, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ingredients string
+		nutrients   map[int]Values
+		want        []string
+	}{
+		{
+			"ROLLED OATS, ALMONDS, MAPLE SYRUP, SEA SALT",
+			map[int]Values{205: {60, 24, true}, 291: {10, 4, true}, 307: {100, 40, true}, 204: {12, 5, true}},
+			[]string{"vegan", "vegetarian", "gluten-free", "low-sodium"},
+		},
+		{
+			"CHEESE (PASTEURIZED MILK, SALT, ENZYMES), WHEAT FLOUR, VEGETABLE STOCK",
+			map[int]Values{205: {4, 1, true}, 307: {30, 10, true}, 204: {2, 1, true}, 269: {0, 0, true}},
+			[]string{"vegetarian", "keto", "low-sodium", "very-low-sodium", "low-fat", "sugar-free"},
+		},
+		{
+			"BEEF, HONEY, BARLEY MALT",
+			map[int]Values{205: {10, 2, false}},
+			[]string{},
+		},
+		{"", nil, []string{}},
+	}
+	for _, tt := range tests {
+		f := fdc.Food{Ingredients: tt.ingredients}
+		if got := rules.Classify(&f, tt.nutrients); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Classify(%q)\n got %v\nwant %v", tt.ingredients, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "rules*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+- name: keto
+  nutrients:
+    - nutrient: 205
+      minus: [291]
+      basis: 100g
+      max: 10
+- name: high-protein
+  nutrients:
+    - nutrient: 203
+      min: 10
+`)
+	f.Close()
+	rules, err := Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := rules.Find("keto"); !ok || r.Nutrients[0].Basis != PER100 {
+		t.Errorf("Expecting the file to replace the keto rule got %v", r)
+	}
+	if r, ok := rules.Find("high-protein"); !ok || r.Nutrients[0].Basis != SERVING {
+		t.Errorf("Expecting a new high-protein rule with a serving basis got %v", r)
+	}
+	for _, bad := range []string{"- description: no name", "- name: empty", "- name: x\n  allergens: [gluten]", "- name: x\n  nutrients:\n    - nutrient: 203", "- name: x\n  nutrients:\n    - nutrient: 203\n      basis: cup\n      min: 1"} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Expecting an error parsing %q", bad)
+		}
+	}
+}
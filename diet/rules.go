@@ -0,0 +1,51 @@
+package diet
+
+// Defaults are the built in rules.  Nutrient claims follow the FDA
+// definitions per serving: low sodium is 140 mg or less, very low sodium 35
+// mg or less, low fat 3 g or less, sugar free less than 0.5 g and high fiber
+// 5 g or more.  Keto allows 5 g or less of net carbohydrate per serving.
+const Defaults = `
+- name: vegan
+  description: no meat, fish, dairy, eggs, honey or other animal derived ingredients
+  allergens: [milk, egg, fish, shellfish]
+  exclude: [meat, beef, pork, chicken, turkey, lamb, veal, mutton, goat, venison, duck, bacon, ham, sausage, pepperoni, salami, prosciutto, gelatin, collagen, lard, tallow, suet, chicken broth, beef broth, bone broth, chicken stock, beef stock, fish stock, honey, beeswax, royal jelly, carmine, cochineal, shellac, isinglass, lanolin, rennet, l-cysteine, anchovy, fish, vitamin d3]
+- name: vegetarian
+  description: no meat, poultry, fish or slaughter by-products
+  allergens: [fish, shellfish]
+  exclude: [meat, beef, pork, chicken, turkey, lamb, veal, mutton, goat, venison, duck, bacon, ham, sausage, pepperoni, salami, prosciutto, gelatin, collagen, lard, tallow, suet, chicken broth, beef broth, bone broth, chicken stock, beef stock, fish stock, carmine, cochineal, isinglass, animal rennet, anchovy, fish]
+- name: gluten-free
+  description: no wheat, barley, rye or their derivatives
+  allergens: [wheat]
+  exclude: [barley, rye, malt, malt extract, malt vinegar, malted barley, triticale, brewer's yeast, spelt, kamut, seitan, gluten]
+- name: keto
+  description: 5 g or less net carbohydrate (carbohydrate less fiber) per serving
+  nutrients:
+    - nutrient: 205
+      minus: [291]
+      max: 5
+- name: low-sodium
+  description: 140 mg or less sodium per serving
+  nutrients:
+    - nutrient: 307
+      max: 140
+- name: very-low-sodium
+  description: 35 mg or less sodium per serving
+  nutrients:
+    - nutrient: 307
+      max: 35
+- name: low-fat
+  description: 3 g or less total fat per serving
+  nutrients:
+    - nutrient: 204
+      max: 3
+- name: sugar-free
+  description: less than 0.5 g sugars per serving
+  nutrients:
+    - nutrient: 269
+      max: 0.49
+- name: high-fiber
+  description: 5 g or more dietary fiber per serving
+  nutrients:
+    - nutrient: 291
+      min: 5
+`
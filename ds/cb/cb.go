@@ -4,16 +4,21 @@ package cb
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/prLorence/fdc-api/auth"
+	"github.com/prLorence/fdc-api/ds/prefix"
+	"github.com/prLorence/fdc-api/logging"
 	fdc "github.com/prLorence/fdc-api/model"
 
 	gocb "gopkg.in/couchbase/gocb.v1"
 	"gopkg.in/couchbase/gocb.v1/cbft"
 )
 
+// suggestTimeout bounds typeahead queries which are run on every keystroke
+const suggestTimeout = 500 * time.Millisecond
+
 // Cb implements a DataSource interface to CouchBase
 type Cb struct {
 	Conn *gocb.Bucket
@@ -24,7 +29,7 @@ func (ds *Cb) ConnectDs(cs fdc.Config) error {
 	var err error
 	cluster, err := gocb.Connect(cs.CouchDb.URL)
 	if err != nil {
-		log.Fatalln("Cannot connect to cluster ", err)
+		logging.Fatal("cannot connect to the couchbase cluster", logging.Fields{"url": cs.CouchDb.URL, "error": err})
 	}
 	cluster.Authenticate(gocb.PasswordAuthenticator{
 		Username: cs.CouchDb.User,
@@ -32,7 +37,7 @@ func (ds *Cb) ConnectDs(cs fdc.Config) error {
 	})
 	ds.Conn, err = cluster.OpenBucket(cs.CouchDb.Bucket, "")
 	if err != nil {
-		log.Fatalln("Cannot connect to bucket!", err)
+		logging.Fatal("cannot open the couchbase bucket", logging.Fields{"bucket": cs.CouchDb.Bucket, "error": err})
 	}
 	return err
 }
@@ -45,7 +50,7 @@ func (ds Cb) Get(q string, f interface{}) error {
 
 // Counts returns document counts for a specified document type
 func (ds *Cb) Counts(bucket string, doctype string, c *[]interface{}) error {
-	q := fmt.Sprintf("SELECT dataSource,count(*) AS count from %s WHERE type='FOOD' AND dataSource = '%s' GROUP BY dataSource", bucket, doctype)
+	q := fmt.Sprintf("SELECT dataSource,count(*) AS count from %s WHERE type='FOOD' AND dataSource = %s GROUP BY dataSource", bucket, fdc.Quote(doctype))
 	return ds.Query(q, c)
 }
 
@@ -75,6 +80,11 @@ func (ds *Cb) GetDictionary(bucket string, doctype string, offset int64, limit i
 		for rows.Next(&row) {
 			i = append(i, row)
 		}
+	case "ROLE":
+		var row auth.Role
+		for rows.Next(&row) {
+			i = append(i, row)
+		}
 	case "FGFNDDS":
 		fallthrough
 	case "FGGPC":
@@ -106,31 +116,24 @@ func (ds *Cb) Browse(bucket string, where string, offset int64, limit int64, sor
 	return f, nil
 }
 
-// Search performs a search query, fills out a Foods slice and returns count, error
-func (ds *Cb) Search(sr fdc.SearchRequest, foods *[]interface{}) (int, error) {
+// Search performs a search query, fills out a Foods slice and any requested
+// facets and returns count, error.  Searches which filter on nutrient values
+// or allergens are run as N1QL queries since nutrient data is not held in the
+// FTS index and unchecked foods cannot be told apart from allergen free ones.
+func (ds *Cb) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
 	count := 0
-	var (
-		sq     cbft.FtsQuery
-		err    error
-		result gocb.SearchResults
-	)
 	sr.Query = strings.Replace(sr.Query, "\"", "", -1)
-	switch sr.SearchType {
-	case fdc.PHRASE:
-		sq = cbft.NewMatchPhraseQuery(sr.Query).Field(sr.SearchField)
-	case fdc.WILDCARD:
-		sq = cbft.NewWildcardQuery(sr.Query).Field(sr.SearchField)
-	case fdc.REGEX:
-		sq = cbft.NewRegexpQuery(sr.Query).Field(sr.SearchField)
-	default:
-		sq = cbft.NewMatchQuery(sr.Query).Field(sr.SearchField)
+	sq := searchQuery(sr)
+	if len(sr.NutrientFilters) > 0 || len(sr.AllergenFree) > 0 {
+		return ds.searchN1ql(sr, sq, foods, facets)
 	}
-	// add a foodgroup filter if we have one, otherwise run a standard search
-	if sr.FoodGroup != "" {
-		result, err = ds.Conn.ExecuteSearchQuery(gocb.NewSearchQuery(sr.IndexName, cbft.NewConjunctionQuery(sq, cbft.NewMatchQuery(sr.FoodGroup).Field("foodGroup.description"))).Limit(int(sr.Max)).Skip(sr.Page).Fields("*"))
-	} else {
-		result, err = ds.Conn.ExecuteSearchQuery(gocb.NewSearchQuery(sr.IndexName, sq).Limit(int(sr.Max)).Skip(sr.Page).Fields("*"))
+	query := gocb.NewSearchQuery(sr.IndexName, sq).Limit(int(sr.Max)).Skip(sr.Page).Fields("*")
+	for _, fr := range sr.Facets {
+		if fr.Name != fdc.NUTRIENT {
+			query.AddFacet(fr.Name, cbft.NewTermFacet(fdc.FacetFields[fr.Name]+"_kw", fr.Size))
+		}
 	}
+	result, err := ds.Conn.ExecuteSearchQuery(query)
 	if err != nil {
 		return 0, err
 	}
@@ -146,10 +149,78 @@ func (ds *Cb) Search(sr fdc.SearchRequest, foods *[]interface{}) (int, error) {
 		}
 		*foods = append(*foods, f)
 	}
+	for _, fr := range sr.Facets {
+		if fr.Name == fdc.NUTRIENT {
+			where, err := searchWhere(ds.Conn.Name(), sr, sq)
+			if err != nil {
+				return 0, err
+			}
+			nf, err := ds.nutrientFacet(where, fr)
+			if err != nil {
+				return 0, err
+			}
+			*facets = append(*facets, nf)
+			continue
+		}
+		rf := result.Facets()[fr.Name]
+		tf := fdc.Facet{Name: fr.Name, Field: fdc.FacetFields[fr.Name], Total: rf.Total, Missing: rf.Missing, Other: rf.Other, Buckets: []fdc.FacetBucket{}}
+		for _, t := range rf.Terms {
+			tf.Buckets = append(tf.Buckets, fdc.FacetBucket{Term: t.Term, Count: t.Count})
+		}
+		*facets = append(*facets, tf)
+	}
 
 	return count, nil
 }
 
+// Suggest runs a prefix query on the last word of the request and fills out a
+// list of completions ranked by hit score.  Description and company hits
+// complete to the whole field value, ingredient hits to the matching
+// ingredient.
+func (ds *Cb) Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error {
+	var (
+		fields []string
+		terms  []cbft.FtsQuery
+	)
+	words := strings.Fields(strings.ToLower(strings.Replace(sr.Query, "\"", "", -1)))
+	if len(words) == 0 {
+		return nil
+	}
+	last := words[len(words)-1]
+	if sr.Field != "" {
+		fields = []string{sr.Field}
+	} else {
+		fields = []string{fdc.DESCRIPTION, fdc.COMPANY, fdc.INGREDIENTS}
+	}
+	// each field must match the leading words and a prefix of the last one
+	for _, field := range fields {
+		q := cbft.NewConjunctionQuery(cbft.NewPrefixQuery(last).Field(field))
+		if len(words) > 1 {
+			q.And(cbft.NewMatchQuery(strings.Join(words[:len(words)-1], " ")).Field(field))
+		}
+		terms = append(terms, q)
+	}
+	result, err := ds.Conn.ExecuteSearchQuery(gocb.NewSearchQuery(sr.IndexName, cbft.NewDisjunctionQuery(terms...)).Limit(sr.Max * 5).Fields(fields...).Timeout(suggestTimeout))
+	if err != nil {
+		return err
+	}
+	ix := prefix.New()
+	for _, r := range result.Hits() {
+		for _, field := range fields {
+			if field == fdc.INGREDIENTS {
+				ix.AddList(field, r.Fields[field], r.Score)
+			} else {
+				ix.Add(field, r.Fields[field], r.Score)
+			}
+		}
+	}
+	*s = append(*s, ix.Complete(strings.Join(words, " "), sr.Field, sr.Max)...)
+	if len(*s) == 0 && len(words) > 1 {
+		*s = append(*s, ix.Complete(last, sr.Field, sr.Max)...)
+	}
+	return nil
+}
+
 // NutrientReport Runs a NutrientReportRequest
 func (ds *Cb) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error {
 	w := ""
@@ -157,9 +228,15 @@ func (ds *Cb) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrie
 	sort := "nutdata"
 
 	if nr.FoodGroup != "" {
-		w = fmt.Sprintf(" category=\"%s\" AND ", nr.FoodGroup)
+		w = fmt.Sprintf(" category=%s AND ", fdc.Quote(nr.FoodGroup))
 		sort = "nutdata_fg"
 	}
+	// custom foods are reported only to their owners
+	if nr.Owner != "" {
+		w += fmt.Sprintf(" (n.owner IS MISSING OR n.owner=%s) AND ", fdc.Quote(nr.Owner))
+	} else {
+		w += " n.owner IS MISSING AND "
+	}
 	if strings.ToLower(nr.Sort) == "portion" {
 		sort = sort + "_portion"
 		qfield = "n.portionValue"
@@ -183,6 +260,12 @@ func (ds *Cb) Remove(id string) error {
 	return err
 }
 
+// Counter adds delta to a counter document, starting it at delta
+func (ds *Cb) Counter(id string, delta int64) (int64, error) {
+	n, _, err := ds.Conn.Counter(id, delta, delta, 0)
+	return int64(n), err
+}
+
 // CloseDs is a wrapper for the connection close func
 func (ds *Cb) CloseDs() {
 	ds.Conn.Close()
@@ -226,6 +309,102 @@ func (ds Cb) FoodExists(id string) bool {
 	return rc
 }
 
+// pingServices are the Couchbase services the API uses and their names in
+// readiness checks
+var pingServices = map[gocb.ServiceType]string{
+	gocb.MemdService: "couchbase kv",
+	gocb.N1qlService: "couchbase n1ql",
+	gocb.FtsService:  "couchbase fts",
+}
+
+// indexSorts are the sorts Browse and NutrientReport pass to useIndex
+var indexSorts = []string{"foodDescription", "company", "nutdata", "nutdata_portion", "nutdata_fg_portion", "nutdata_fg", "fdcid"}
+
+// Ping checks that the key-value, query and search services answer, that
+// the N1QL indexes named by useIndex are online and that the full text
+// search index exists.  A service is ok when any of its nodes answers.
+func (ds *Cb) Ping(cs fdc.Config) ([]fdc.Check, error) {
+	services := []gocb.ServiceType{gocb.MemdService, gocb.N1qlService, gocb.FtsService}
+	r, err := ds.Conn.Ping(services)
+	if err != nil {
+		return []fdc.Check{{Name: "couchbase", Status: "fail", Message: err.Error()}}, err
+	}
+	var checks []fdc.Check
+	for _, s := range services {
+		c := fdc.Check{Name: pingServices[s], Status: "fail", Message: "no node answered"}
+		var failed []string
+		for _, e := range r.Services {
+			if e.Service != s {
+				continue
+			}
+			if !e.Success {
+				failed = append(failed, e.Endpoint)
+				continue
+			}
+			if c.Status != "ok" {
+				c.Status, c.Latency, c.Message = "ok", e.Latency.String(), ""
+			}
+		}
+		if c.Status == "ok" && len(failed) > 0 {
+			c.Message = "no answer from " + strings.Join(failed, ", ")
+		}
+		checks = append(checks, c)
+	}
+	if checks[0].Status != "ok" {
+		return checks, fmt.Errorf("cannot reach bucket %s", cs.CouchDb.Bucket)
+	}
+	checks = append(checks, ds.pingIndexes(cs.CouchDb.Bucket), ds.pingFts(cs.CouchDb.Fts))
+	return checks, nil
+}
+
+// pingIndexes checks the N1QL indexes named by useIndex exist in the bucket
+// and are online
+func (ds *Cb) pingIndexes(bucket string) fdc.Check {
+	c := fdc.Check{Name: "n1ql indexes", Status: "ok"}
+	var rows []interface{}
+	start := time.Now()
+	if err := ds.Query(fmt.Sprintf("SELECT name, state FROM system:indexes WHERE keyspace_id=%s", fdc.Quote(bucket)), &rows); err != nil {
+		c.Status, c.Message = "fail", err.Error()
+		return c
+	}
+	c.Latency = time.Since(start).String()
+	states := make(map[string]string)
+	for _, r := range rows {
+		if m, ok := r.(map[string]interface{}); ok {
+			name, _ := m["name"].(string)
+			states[name], _ = m["state"].(string)
+		}
+	}
+	var bad []string
+	for _, sort := range indexSorts {
+		for _, order := range []string{"asc", "desc"} {
+			name := useIndex(sort, order)
+			if state, ok := states[name]; !ok {
+				bad = append(bad, name+" missing")
+			} else if state != "online" {
+				bad = append(bad, name+" "+state)
+			}
+		}
+	}
+	if len(bad) > 0 {
+		c.Status, c.Message = "fail", strings.Join(bad, ", ")
+	}
+	return c
+}
+
+// pingFts checks the full text search index exists by running a search
+// which matches nothing
+func (ds *Cb) pingFts(index string) fdc.Check {
+	c := fdc.Check{Name: "fts index " + index, Status: "ok"}
+	start := time.Now()
+	if _, err := ds.Conn.ExecuteSearchQuery(gocb.NewSearchQuery(index, cbft.NewMatchNoneQuery()).Limit(0)); err != nil {
+		c.Status, c.Message = "fail", err.Error()
+		return c
+	}
+	c.Latency = time.Since(start).String()
+	return c
+}
+
 // Generates a use index phrase for use by Browse
 // to speed up the sort
 func useIndex(sort string, order string) string {
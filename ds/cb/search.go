@@ -0,0 +1,261 @@
+package cb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	fdc "github.com/prLorence/fdc-api/model"
+
+	gocb "gopkg.in/couchbase/gocb.v1"
+	"gopkg.in/couchbase/gocb.v1/cbft"
+)
+
+// ingredientTokens is the food field holding parsed ingredients
+const ingredientTokens = "ingredientTokens"
+
+// searchQuery builds the FTS query for a SearchRequest including any food
+// group, facet, ingredient and diet filters.  Values for a facet are OR'd and
+// facets are AND'd.
+func searchQuery(sr fdc.SearchRequest) cbft.FtsQuery {
+	var (
+		sq      cbft.FtsQuery
+		filters []cbft.FtsQuery
+		names   []string
+	)
+	if sr.SearchType == fdc.COMPOUND {
+		sq = compoundQuery(sr)
+	} else {
+		sq = typedQuery(sr.SearchType, sr.Query, sr.SearchField, sr)
+	}
+	if sr.FoodGroup != "" {
+		filters = append(filters, cbft.NewMatchQuery(sr.FoodGroup).Field("foodGroup.description"))
+	}
+	for name := range sr.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var terms []cbft.FtsQuery
+		for _, v := range sr.Filters[name] {
+			terms = append(terms, cbft.NewTermQuery(v).Field(fdc.FacetFields[name]+"_kw"))
+		}
+		if len(terms) > 0 {
+			filters = append(filters, cbft.NewDisjunctionQuery(terms...))
+		}
+	}
+	for _, in := range sr.Include {
+		filters = append(filters, cbft.NewMatchPhraseQuery(in).Field(ingredientTokens))
+	}
+	for _, d := range sr.Diets {
+		filters = append(filters, cbft.NewMatchPhraseQuery(d).Field("diets"))
+	}
+	if len(filters) > 0 {
+		sq = cbft.NewConjunctionQuery(append([]cbft.FtsQuery{sq}, filters...)...)
+	}
+	if len(sr.Exclude) == 0 {
+		return sq
+	}
+	var ex []cbft.FtsQuery
+	for _, e := range sr.Exclude {
+		ex = append(ex, cbft.NewMatchPhraseQuery(e).Field(ingredientTokens))
+	}
+	return cbft.NewBooleanQuery().Must(sq).MustNot(cbft.NewDisjunctionQuery(ex...))
+}
+
+// typedQuery returns the FTS query for a search type of q in field
+func typedQuery(searchType string, q string, field string, sr fdc.SearchRequest) cbft.FtsQuery {
+	switch searchType {
+	case fdc.PHRASE:
+		return cbft.NewMatchPhraseQuery(q).Field(field)
+	case fdc.WILDCARD:
+		return cbft.NewWildcardQuery(q).Field(field)
+	case fdc.REGEX:
+		return cbft.NewRegexpQuery(q).Field(field)
+	case fdc.FUZZY:
+		return cbft.NewMatchQuery(q).Field(field).Fuzziness(sr.Fuzziness).PrefixLength(sr.PrefixLength)
+	case fdc.PREFIX:
+		// prefix queries are not analyzed so match each lower-cased word
+		var words []cbft.FtsQuery
+		for _, w := range strings.Fields(strings.ToLower(q)) {
+			words = append(words, cbft.NewPrefixQuery(w).Field(field))
+		}
+		return cbft.NewConjunctionQuery(words...)
+	default:
+		return cbft.NewMatchQuery(q).Field(field)
+	}
+}
+
+// compoundQuery maps the clauses of a SearchRequest onto a boolean query
+// with MUST clauses conjoined and SHOULD clauses disjoined.  A query of only
+// MUSTNOT clauses excludes foods from all foods.
+func compoundQuery(sr fdc.SearchRequest) cbft.FtsQuery {
+	var must, should, mustNot []cbft.FtsQuery
+	for _, c := range sr.Clauses {
+		q := typedQuery(c.SearchType, c.Query, c.Field, sr)
+		if c.Boost > 0 {
+			q = cbft.NewConjunctionQuery(q).Boost(c.Boost)
+		}
+		switch c.Occur {
+		case fdc.SHOULD:
+			should = append(should, q)
+		case fdc.MUSTNOT:
+			mustNot = append(mustNot, q)
+		default:
+			must = append(must, q)
+		}
+	}
+	bq := cbft.NewBooleanQuery()
+	if len(must) > 0 {
+		bq.Must(cbft.NewConjunctionQuery(must...))
+	}
+	if len(should) > 0 {
+		bq.Should(cbft.NewDisjunctionQuery(should...))
+		// with no MUST clauses at least one SHOULD clause has to match
+		if len(must) == 0 {
+			bq.ShouldMin(1)
+		}
+	}
+	if len(must) == 0 && len(should) == 0 {
+		bq.Must(cbft.NewMatchAllQuery())
+	}
+	if len(mustNot) > 0 {
+		bq.MustNot(cbft.NewDisjunctionQuery(mustNot...))
+	}
+	return bq
+}
+
+// searchWhere returns a N1QL predicate which selects the food documents f
+// matching an FTS query and the nutrient and allergen filters of a
+// SearchRequest
+func searchWhere(bucket string, sr fdc.SearchRequest, sq cbft.FtsQuery) (string, error) {
+	q, err := json.Marshal(sq)
+	if err != nil {
+		return "", err
+	}
+	w := fmt.Sprintf("f.type=\"FOOD\" AND SEARCH(f, {\"query\":%s}, {\"index\":%s})", q, fdc.Quote(sr.IndexName))
+	for _, nf := range sr.NutrientFilters {
+		var r []string
+		for _, nr := range nf.Ranges {
+			r = append(r, "("+rangeClause("n.valuePer100UnitServing", nr)+")")
+		}
+		w += fmt.Sprintf(" AND f.fdcId IN (SELECT RAW n.fdcId FROM %s n WHERE n.type=\"NUTDATA\" AND n.nutrientNumber=%d AND (%s))", bucket, nf.Nutrient, strings.Join(r, " OR "))
+	}
+	if len(sr.AllergenFree) > 0 {
+		a, err := json.Marshal(sr.AllergenFree)
+		if err != nil {
+			return "", err
+		}
+		w += fmt.Sprintf(" AND f.allergens IS VALUED AND NOT ANY a IN f.allergens SATISFIES a IN %s END AND NOT ANY a IN IFMISSINGORNULL(f.mayContain, []) SATISFIES a IN %s END", a, a)
+	}
+	return w, nil
+}
+
+// rangeClause returns a N1QL predicate testing field against a NumericRange
+func rangeClause(field string, r fdc.NumericRange) string {
+	var c []string
+	if r.Min != nil {
+		c = append(c, fmt.Sprintf("%s >= %f", field, *r.Min))
+	}
+	if r.Max != nil {
+		c = append(c, fmt.Sprintf("%s < %f", field, *r.Max))
+	}
+	if len(c) == 0 {
+		return fmt.Sprintf("%s IS VALUED", field)
+	}
+	return strings.Join(c, " AND ")
+}
+
+// searchN1ql runs a search which filters on nutrient values using the N1QL
+// SEARCH function and computes any requested facets with aggregate queries
+func (ds *Cb) searchN1ql(sr fdc.SearchRequest, sq cbft.FtsQuery, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
+	var count []int
+	bucket := ds.Conn.Name()
+	where, err := searchWhere(bucket, sr, sq)
+	if err != nil {
+		return 0, err
+	}
+	q := fmt.Sprintf("SELECT f.fdcId,f.upc,f.foodDescription,f.ingredients,f.dataSource,f.company,f.type,f.foodGroup.description AS `foodgroup.description` FROM %s f WHERE %s ORDER BY SEARCH_SCORE() DESC OFFSET %d LIMIT %d", bucket, where, sr.Page, sr.Max)
+	rows, err := ds.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), nil)
+	if err != nil {
+		return 0, err
+	}
+	var f fdc.FoodMeta
+	for rows.Next(&f) {
+		*foods = append(*foods, f)
+		f = fdc.FoodMeta{}
+	}
+	rows, err = ds.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(fmt.Sprintf("SELECT RAW COUNT(*) FROM %s f WHERE %s", bucket, where)), nil)
+	if err != nil {
+		return 0, err
+	}
+	var c int
+	for rows.Next(&c) {
+		count = append(count, c)
+	}
+	for _, fr := range sr.Facets {
+		var tf fdc.Facet
+		if fr.Name == fdc.NUTRIENT {
+			tf, err = ds.nutrientFacet(where, fr)
+		} else {
+			tf, err = ds.termFacet(where, fr)
+		}
+		if err != nil {
+			return 0, err
+		}
+		*facets = append(*facets, tf)
+	}
+	if len(count) == 0 {
+		return 0, nil
+	}
+	return count[0], nil
+}
+
+// termFacet counts the foods matching a N1QL predicate by the values of a
+// facet's field
+func (ds *Cb) termFacet(where string, fr fdc.FacetRequest) (fdc.Facet, error) {
+	var row struct {
+		Term  string `json:"term"`
+		Count int    `json:"count"`
+	}
+	field := fdc.FacetFields[fr.Name]
+	tf := fdc.Facet{Name: fr.Name, Field: field, Buckets: []fdc.FacetBucket{}}
+	q := fmt.Sprintf("SELECT f.%s AS term, COUNT(*) AS count FROM %s f WHERE %s AND f.%s IS VALUED GROUP BY f.%s ORDER BY count DESC LIMIT %d", field, ds.Conn.Name(), where, field, field, fr.Size)
+	rows, err := ds.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), nil)
+	if err != nil {
+		return tf, err
+	}
+	for rows.Next(&row) {
+		tf.Buckets = append(tf.Buckets, fdc.FacetBucket{Term: row.Term, Count: row.Count})
+		tf.Total += row.Count
+	}
+	return tf, nil
+}
+
+// nutrientFacet counts the foods matching a N1QL predicate by ranges of
+// values per 100 units for the facet's nutrient
+func (ds *Cb) nutrientFacet(where string, fr fdc.FacetRequest) (fdc.Facet, error) {
+	var (
+		sums []string
+		row  map[string]float64
+	)
+	bucket := ds.Conn.Name()
+	nf := fdc.Facet{Name: fr.Name, Field: fmt.Sprintf("nutrient.%d", fr.Nutrient), Buckets: []fdc.FacetBucket{}}
+	for i, r := range fr.Ranges {
+		sums = append(sums, fmt.Sprintf("SUM(CASE WHEN %s THEN 1 ELSE 0 END) AS r%d", rangeClause("n.valuePer100UnitServing", r), i))
+	}
+	q := fmt.Sprintf("SELECT COUNT(*) AS total,%s FROM %s n WHERE n.type=\"NUTDATA\" AND n.nutrientNumber=%d AND n.fdcId IN (SELECT RAW f.fdcId FROM %s f WHERE %s)", strings.Join(sums, ","), bucket, fr.Nutrient, bucket, where)
+	rows, err := ds.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), nil)
+	if err != nil {
+		return nf, err
+	}
+	if err = rows.One(&row); err != nil {
+		return nf, err
+	}
+	nf.Total = int(row["total"])
+	for i, r := range fr.Ranges {
+		nf.Buckets = append(nf.Buckets, fdc.FacetBucket{Term: r.Name, Min: r.Min, Max: r.Max, Count: int(row[fmt.Sprintf("r%d", i)])})
+	}
+	return nf, nil
+}
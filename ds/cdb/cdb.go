@@ -3,37 +3,58 @@ package cdb
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	kivik "github.com/flimzy/kivik"
 	_ "github.com/go-kivik/couchdb"
+	"github.com/prLorence/fdc-api/ds"
+	"github.com/prLorence/fdc-api/ds/fuzzy"
+	"github.com/prLorence/fdc-api/ds/prefix"
+	"github.com/prLorence/fdc-api/ingredient"
+	"github.com/prLorence/fdc-api/logging"
 	fdc "github.com/prLorence/fdc-api/model"
 	"gopkg.in/couchbase/gocb.v1"
 )
 
-// Cdb implements a DataSource interface to CouchBase
+// maxScan caps the documents read to emulate the searches and facets which
+// CouchDB's Mango queries can't answer, so a request can't walk the whole
+// database
+const maxScan = 10000
+
+// scanBatch is how many documents are read at a time when the prefix index
+// is filled
+const scanBatch = 1000
+
+// maxCounterTries is how often a counter update is retried after a conflict
+const maxCounterTries = 5
+
+// Cdb implements a DataSource interface to CouchDB
 type Cdb struct {
-	Conn *kivik.DB
+	Conn        *kivik.DB
+	Suggestions *prefix.Index
 }
 
+var _ ds.DataSource = (*Cdb)(nil)
+
 // ConnectDs connects to a datastore, e.g. Couchbase, MongoDb, etc.
 func (ds *Cdb) ConnectDs(cs fdc.Config) error {
 	var err error
-<<<<<<< HEAD
 	url := fmt.Sprintf("https://%s:%s@%s", cs.CouchDb.User, cs.CouchDb.Pwd, cs.CouchDb.URL)
-=======
-	url := fmt.Sprintf("http://%s:%s@%s", cs.CouchDb.User, cs.CouchDb.Pwd, cs.CouchDb.URL)
-	log.Println("url=", url)
->>>>>>> 98a87764d7acc0aedf3445c2e36d002c924ae588
 	conn, err := kivik.New(context.TODO(), "couch", url)
 	if err != nil {
-		log.Fatalln("Cannot get a client ", err)
+		logging.Fatal("cannot get a couchdb client", logging.Fields{"error": err})
 	}
 	ds.Conn, err = conn.DB(context.TODO(), cs.CouchDb.Bucket)
 	if err != nil {
-		log.Fatalln("Cannot connect to datastore!", err)
+		logging.Fatal("cannot connect to the couchdb database", logging.Fields{"db": cs.CouchDb.Bucket, "error": err})
 	}
+	ds.Suggestions = prefix.New()
 	return err
 }
 
@@ -41,7 +62,7 @@ func (ds *Cdb) ConnectDs(cs fdc.Config) error {
 func (ds Cdb) Get(q string, f interface{}) error {
 	r, err := ds.Conn.Get(context.TODO(), q)
 	if err != nil {
-		log.Println("Get failed ", err)
+		logging.Debug("couchdb get failed", logging.Fields{"id": q, "error": err})
 		return err
 	}
 	return r.ScanDoc(&f)
@@ -60,7 +81,7 @@ func (ds *Cdb) GetDictionary(bucket string, doctype string, offset int64, limit
 	q := fmt.Sprintf("{\"selector\":{\"type\":\"%s\"},\"fields\":[],\"limit\":%d,\"skip\":%d}", doctype, limit, offset)
 	rows, err := ds.Conn.Find(context.Background(), q)
 	if err != nil {
-		log.Printf("%v\n", err)
+		logging.Error("couchdb find failed", logging.Fields{"selector": q, "error": err})
 		return nil, err
 	}
 	switch doctype {
@@ -104,7 +125,7 @@ func (ds *Cdb) Browse(bucket string, doctype string, offset int64, limit int64,
 	q := fmt.Sprintf("{\"selector\":{\"type\":\"%s\"},\"fields\":[\"fdcId\",\"foodDescription\",\"Company\",\"upc\",\"dataSource\"],\"limit\":%d,\"skip\":%d,\"sort\":[\"%s\"]}", doctype, limit, offset, sort)
 	rows, err := ds.Conn.Find(context.Background(), q)
 	if err != nil {
-		log.Printf("%v\n", err)
+		logging.Error("couchdb find failed", logging.Fields{"selector": q, "error": err})
 		return nil, err
 	}
 
@@ -116,22 +137,87 @@ func (ds *Cdb) Browse(bucket string, doctype string, offset int64, limit int64,
 	return i, nil
 }
 
-// Search performs a search query, fills out a Foods slice and returns count, error
-func (ds *Cdb) Search(sr fdc.SearchRequest, foods *[]interface{}) (int, error) {
+// Search performs a search query, fills out a Foods slice and any requested
+// facets and returns count, error
+func (ds *Cdb) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
 	count := 0
 	var row interface{}
 	sr.SearchField = "foodDescription"
 	sr.Sort = "foodDescription"
-	q := fmt.Sprintf("{\"selector\":{\"%s\":{\"%s\":\"%s\"}},\"fields\":[],\"limit\":%d,\"skip\":%d,\"sort\":[\"%s\"]}", sr.SearchField, "$regex", sr.Query, sr.Max, sr.Page, sr.Sort)
-	fmt.Printf("q=%s\n", q)
-	rows, err := ds.Conn.Find(context.Background(), q)
+	if len(sr.NutrientFilters) > 0 {
+		return 0, errors.New("nutrient filters are not supported by this datasource")
+	}
+	selector := map[string]interface{}{"type": "FOOD"}
+	switch sr.SearchType {
+	case fdc.PREFIX:
+		selector[sr.SearchField] = map[string]string{"$regex": wordsRegex(fuzzy.Words(sr.Query), 0)}
+	case fdc.FUZZY:
+		// narrow the candidates on the exact prefixes, if any, and compare
+		// edit distances here
+		if sr.PrefixLength > 0 {
+			selector[sr.SearchField] = map[string]string{"$regex": wordsRegex(fuzzy.Words(sr.Query), sr.PrefixLength)}
+		}
+	case fdc.COMPOUND:
+		for op, sel := range clauseSelectors(sr.Clauses) {
+			selector[op] = sel
+		}
+	default:
+		selector[sr.SearchField] = map[string]string{"$regex": sr.Query}
+	}
+	for name, values := range sr.Filters {
+		selector[fdc.FacetFields[name]] = map[string][]string{"$in": values}
+	}
+	if len(sr.AllergenFree) > 0 {
+		// unchecked foods have null allergens
+		selector["allergens"] = map[string]interface{}{"$type": "array", "$not": map[string]interface{}{"$elemMatch": map[string][]string{"$in": sr.AllergenFree}}}
+		selector["mayContain"] = map[string]interface{}{"$not": map[string]interface{}{"$elemMatch": map[string][]string{"$in": sr.AllergenFree}}}
+	}
+	if len(sr.Diets) > 0 {
+		selector["diets"] = map[string][]string{"$all": sr.Diets}
+	}
+	if in := ingredientSelectors(sr.Include, sr.Exclude); len(in) > 0 {
+		and, _ := selector["$and"].([]interface{})
+		selector["$and"] = append(and, in...)
+	}
+	sel, err := json.Marshal(selector)
 	if err != nil {
-		log.Printf("%v\n", err)
 		return 0, err
 	}
-	for rows.Next() {
-		rows.ScanDoc(&row)
-		*foods = append(*foods, row)
+	if sr.SearchType == fdc.FUZZY {
+		// edit distances are compared for the first maxScan candidates only
+		q := fmt.Sprintf("{\"selector\":%s,\"fields\":[],\"limit\":%d,\"sort\":[\"%s\"]}", sel, maxScan, sr.Sort)
+		rows, err := ds.Conn.Find(context.Background(), q)
+		if err != nil {
+			logging.Error("couchdb search failed", logging.Fields{"selector": q, "error": err})
+			return 0, err
+		}
+		for rows.Next() {
+			var f map[string]interface{}
+			rows.ScanDoc(&f)
+			if d, ok := f[sr.SearchField].(string); ok && fuzzy.Match(sr.Query, d, sr.Fuzziness, sr.PrefixLength) {
+				if count >= sr.Page && count < sr.Page+sr.Max {
+					*foods = append(*foods, f)
+				}
+				count++
+			}
+		}
+	} else {
+		q := fmt.Sprintf("{\"selector\":%s,\"fields\":[],\"limit\":%d,\"skip\":%d,\"sort\":[\"%s\"]}", sel, sr.Max, sr.Page, sr.Sort)
+		logging.Debug("couchdb search", logging.Fields{"selector": q})
+		rows, err := ds.Conn.Find(context.Background(), q)
+		if err != nil {
+			logging.Error("couchdb search failed", logging.Fields{"selector": q, "error": err})
+			return 0, err
+		}
+		for rows.Next() {
+			rows.ScanDoc(&row)
+			*foods = append(*foods, row)
+		}
+	}
+	if len(sr.Facets) > 0 {
+		if err = ds.facets(sel, sr.Facets, facets); err != nil {
+			return 0, err
+		}
 	}
 	/*selector, err := mango.New(sr)
 	if err != nil {
@@ -178,6 +264,176 @@ func (ds *Cdb) Search(sr fdc.SearchRequest, foods *[]interface{}) (int, error) {
 	return count, nil
 }
 
+// wordsRegex returns a case insensitive regular expression matching text
+// containing a word beginning with each of the words, or with the first n
+// characters of each word if n is greater than 0
+func wordsRegex(words []string, n int) string {
+	r := "(?i)"
+	for _, w := range words {
+		if p := []rune(w); n > 0 && len(p) > n {
+			w = string(p[:n])
+		}
+		r += "(?=.*\\b" + regexp.QuoteMeta(w) + ")"
+	}
+	return r
+}
+
+// clauseSelectors maps the clauses of a compound search onto $and, $or and
+// $nor selectors of regular expressions.  Boosts are ignored and FUZZY clauses
+// match whole words.
+func clauseSelectors(clauses []fdc.SearchClause) map[string][]interface{} {
+	sel := make(map[string][]interface{})
+	for _, c := range clauses {
+		var r string
+		field := strings.TrimSuffix(c.Field, "_kw")
+		if field == "" {
+			field = fdc.DESCRIPTION
+		}
+		switch c.SearchType {
+		case fdc.REGEX:
+			r = c.Query
+		case fdc.PHRASE:
+			r = "(?i)" + regexp.QuoteMeta(c.Query)
+		case fdc.WILDCARD:
+			r = "(?i)\\b" + strings.NewReplacer("\\*", "\\w*", "\\?", "\\w").Replace(regexp.QuoteMeta(c.Query)) + "\\b"
+		default:
+			r = wordsRegex(fuzzy.Words(c.Query), 0)
+		}
+		op := "$and"
+		switch c.Occur {
+		case fdc.SHOULD:
+			op = "$or"
+		case fdc.MUSTNOT:
+			op = "$nor"
+		}
+		sel[op] = append(sel[op], map[string]interface{}{field: map[string]string{"$regex": r}})
+	}
+	return sel
+}
+
+// ingredientSelectors returns selectors matching foods with an ingredient
+// token containing each included ingredient and none of the excluded ones
+func ingredientSelectors(include []string, exclude []string) []interface{} {
+	var sel []interface{}
+	match := func(name string) map[string]interface{} {
+		return map[string]interface{}{"$elemMatch": map[string]string{"$regex": "(^| )" + regexp.QuoteMeta(ingredient.Normalize(name)) + "( |$)"}}
+	}
+	for _, in := range include {
+		sel = append(sel, map[string]interface{}{"ingredientTokens": match(in)})
+	}
+	for _, ex := range exclude {
+		sel = append(sel, map[string]interface{}{"ingredientTokens": map[string]interface{}{"$not": match(ex)}})
+	}
+	return sel
+}
+
+// facets emulates term facets by counting field values over the first
+// maxScan documents matching a selector
+func (ds *Cdb) facets(sel []byte, fr []fdc.FacetRequest, facets *[]fdc.Facet) error {
+	var fields []string
+	counts := make([]map[string]int, len(fr))
+	for i, f := range fr {
+		if f.Name == fdc.NUTRIENT {
+			return errors.New("nutrient facets are not supported by this datasource")
+		}
+		fields = append(fields, fdc.FacetFields[f.Name])
+		counts[i] = make(map[string]int)
+	}
+	fl, _ := json.Marshal(fields)
+	rows, err := ds.Conn.Find(context.Background(), fmt.Sprintf("{\"selector\":%s,\"fields\":%s,\"limit\":%d}", sel, fl, maxScan))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err = rows.ScanDoc(&doc); err != nil {
+			return err
+		}
+		for i, f := range fields {
+			// walk dotted paths such as foodGroup.description
+			var v interface{} = doc
+			for _, p := range strings.Split(f, ".") {
+				if m, ok := v.(map[string]interface{}); ok {
+					v = m[p]
+				} else {
+					v = nil
+				}
+			}
+			if t, ok := v.(string); ok && t != "" {
+				counts[i][t]++
+			}
+		}
+	}
+	for i, f := range fr {
+		tf := fdc.Facet{Name: f.Name, Field: fields[i], Buckets: []fdc.FacetBucket{}}
+		for t, n := range counts[i] {
+			tf.Buckets = append(tf.Buckets, fdc.FacetBucket{Term: t, Count: n})
+			tf.Total += n
+		}
+		sort.Slice(tf.Buckets, func(a, b int) bool {
+			if tf.Buckets[a].Count != tf.Buckets[b].Count {
+				return tf.Buckets[a].Count > tf.Buckets[b].Count
+			}
+			return tf.Buckets[a].Term < tf.Buckets[b].Term
+		})
+		if len(tf.Buckets) > f.Size {
+			for _, b := range tf.Buckets[f.Size:] {
+				tf.Other += b.Count
+			}
+			tf.Buckets = tf.Buckets[:f.Size]
+		}
+		*facets = append(*facets, tf)
+	}
+	return rows.Err()
+}
+
+// Suggest answers typeahead requests from a local prefix index which is
+// filled on first use from the first maxScan food documents, read scanBatch
+// at a time
+func (ds *Cdb) Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error {
+	err := ds.Suggestions.Load(func(ix *prefix.Index) error {
+		for skip := 0; skip < maxScan; skip += scanBatch {
+			n, err := ds.suggestions(ix, skip)
+			if err != nil {
+				return err
+			}
+			if n < scanBatch {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Error("cannot load the couchdb suggestions", logging.Fields{"error": err})
+		return err
+	}
+	*s = append(*s, ds.Suggestions.Complete(sr.Query, sr.Field, sr.Max)...)
+	return nil
+}
+
+// suggestions adds a batch of food documents starting at skip to ix and
+// returns how many were read
+func (ds *Cdb) suggestions(ix *prefix.Index, skip int) (int, error) {
+	q := fmt.Sprintf("{\"selector\":{\"type\":\"FOOD\"},\"fields\":[\"%s\",\"%s\",\"%s\"],\"skip\":%d,\"limit\":%d}", fdc.DESCRIPTION, fdc.COMPANY, fdc.INGREDIENTS, skip, scanBatch)
+	rows, err := ds.Conn.Find(context.Background(), q)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var row fdc.FoodMeta
+		if err = rows.ScanDoc(&row); err != nil {
+			return n, err
+		}
+		n++
+		ix.Add(fdc.DESCRIPTION, row.Description, 1)
+		ix.Add(fdc.COMPANY, row.Manufacturer, 1)
+		ix.AddList(fdc.INGREDIENTS, row.Ingredients, 1)
+	}
+	return n, rows.Err()
+}
+
 // NutrientReport Runs a NutrientReportRequest
 func (ds *Cdb) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error {
 	/*w := ""
@@ -195,11 +451,65 @@ func (ds *Cdb) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutri
 func (ds *Cdb) Update(id string, r interface{}) error {
 	_, err := ds.Conn.Put(context.TODO(), id, r)
 	if err != nil {
-		log.Fatalln("Error on update: ", err)
+		logging.Error("couchdb update failed", logging.Fields{"id": id, "error": err})
 	}
 	//	_, err := ds.Conn.Upsert(id, r, 0)
-	return nil
+	return err
+
+}
+
+// Remove deletes the current revision of a document
+func (ds *Cdb) Remove(id string) error {
+	rev, err := ds.Conn.Rev(context.TODO(), id)
+	if err != nil {
+		return err
+	}
+	if _, err = ds.Conn.Delete(context.TODO(), id, rev); err != nil {
+		logging.Error("couchdb delete failed", logging.Fields{"id": id, "error": err})
+	}
+	return err
+}
 
+// Counter adds delta to a counter document.  CouchDB has no atomic counters
+// so the update is retried while other writers change the revision first.
+func (ds *Cdb) Counter(id string, delta int64) (int64, error) {
+	var err error
+	for i := 0; i < maxCounterTries; i++ {
+		var c struct {
+			Rev   string `json:"_rev,omitempty"`
+			Value int64  `json:"value"`
+		}
+		if r, gerr := ds.Conn.Get(context.TODO(), id); gerr == nil {
+			if err = r.ScanDoc(&c); err != nil {
+				return 0, err
+			}
+		}
+		c.Value += delta
+		if _, err = ds.Conn.Put(context.TODO(), id, c); err == nil {
+			return c.Value, nil
+		}
+	}
+	logging.Error("couchdb counter update failed", logging.Fields{"id": id, "error": err})
+	return 0, err
+}
+
+// FoodExists reports whether a document with the id exists
+func (ds *Cdb) FoodExists(id string) bool {
+	_, err := ds.Conn.Rev(context.TODO(), id)
+	return err == nil
+}
+
+// Ping checks the database answers.  CouchDB needs no indexes beyond the
+// design documents loaded with the data.
+func (ds *Cdb) Ping(cs fdc.Config) ([]fdc.Check, error) {
+	c := fdc.Check{Name: "couchdb " + cs.CouchDb.Bucket, Status: "ok"}
+	start := time.Now()
+	if _, err := ds.Conn.Stats(context.TODO()); err != nil {
+		c.Status, c.Message = "fail", err.Error()
+		return []fdc.Check{c}, err
+	}
+	c.Latency = time.Since(start).String()
+	return []fdc.Check{c}, nil
 }
 
 // CloseDs is a wrapper for the connection close func
@@ -212,7 +522,7 @@ func (ds *Cdb) Bulk(items *[]fdc.NutrientData) error {
 	_, err := ds.Conn.BulkDocs(context.TODO(), items)
 
 	if err != nil {
-		log.Fatalln("Bulk insert error", err)
+		logging.Error("couchdb bulk insert failed", logging.Fields{"items": len(*items), "error": err})
 	}
 	/*var v []gocb.BulkOp
 	for _, r := range *items {
@@ -235,7 +545,7 @@ func (ds Cdb) Query(q string, f *[]interface{}) error {
 	var row interface{}
 	rows, err := ds.Conn.Find(context.Background(), q)
 	if err != nil {
-		log.Printf("%v\n", err)
+		logging.Error("couchdb query failed", logging.Fields{"selector": q, "error": err})
 		return err
 	}
 	for rows.Next() {
@@ -8,7 +8,10 @@ import (
 )
 
 // DataSource wraps the basic methods used for accessing and updating a
-// data store.
+// data store.  Counter atomically adds to a counter document, creating it,
+// and returns the new count.  Ping reports whether the datastore is reachable
+// and has the indexes the queries need; its error is set only when it can't
+// be reached.
 type DataSource interface {
 	ConnectDs(cs fdc.Config) error
 	Get(q string, f interface{}) error
@@ -16,12 +19,15 @@ type DataSource interface {
 	Counts(bucket string, doctype string, c *[]interface{}) error
 	GetDictionary(dsname string, doctype string, offset int64, limit int64) ([]interface{}, error)
 	Browse(bucket string, where string, offset int64, limit int64, sort string, order string) ([]interface{}, error)
-	Search(sr fdc.SearchRequest, foods *[]interface{}) (int, error)
+	Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error)
+	Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error
 	NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error
 	Update(id string, r interface{}) error
 	Remove(id string) error
+	Counter(id string, delta int64) (int64, error)
 	FoodExists(id string) bool
 	Bulk(n *[]fdc.NutrientData) error
 	BulkInsert(v []gocb.BulkOp) error
+	Ping(cs fdc.Config) ([]fdc.Check, error)
 	CloseDs()
 }
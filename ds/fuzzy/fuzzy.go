@@ -0,0 +1,75 @@
+// Package fuzzy provides edit distance matching which data sources without a
+// full-text search engine can use to emulate fuzzy queries.
+package fuzzy
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Distance returns the Levenshtein edit distance between a and b
+func Distance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Match returns true if every word in query is within fuzziness edits of a
+// word in text.  Words must share their first prefixLength characters
+// exactly, which is how a full-text engine limits the terms it compares.
+func Match(query string, text string, fuzziness int, prefixLength int) bool {
+	words := Words(text)
+	for _, q := range Words(query) {
+		found := false
+		for _, w := range words {
+			if samePrefix(q, w, prefixLength) && Distance(q, w) <= fuzziness {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Words splits s into lower-cased words
+func Words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func samePrefix(a string, b string, n int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < n || len(rb) < n {
+		return string(ra) == string(rb)
+	}
+	return string(ra[:n]) == string(rb[:n])
+}
+
+func min(v ...int) int {
+	m := v[0]
+	for _, i := range v[1:] {
+		if i < m {
+			m = i
+		}
+	}
+	return m
+}
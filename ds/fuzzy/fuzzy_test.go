@@ -0,0 +1,37 @@
+package fuzzy
+
+import "testing"
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+	}{
+		{"broccoli", "broccoli", 0},
+		{"brocoli", "broccoli", 1},
+		{"brocolli", "broccoli", 2},
+		{"", "kale", 4},
+		{"flakes", "", 6},
+		{"crème", "creme", 1},
+	}
+	for _, tt := range tests {
+		if d := Distance(tt.a, tt.b); d != tt.d {
+			t.Errorf("Distance(%s, %s) expecting %d got %d", tt.a, tt.b, tt.d, d)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	if !Match("brocoli raw", "BROCCOLI, RAW", 1, 0) {
+		t.Error("Expecting 'brocoli raw' to match 'BROCCOLI, RAW'")
+	}
+	if Match("brocolli", "BROCCOLI, RAW", 1, 0) {
+		t.Error("Expecting 'brocolli' not to match within 1 edit")
+	}
+	if Match("procoli", "BROCCOLI, RAW", 2, 1) {
+		t.Error("Expecting prefix length to reject a different first letter")
+	}
+	if !Match("procoli", "BROCCOLI, RAW", 2, 0) {
+		t.Error("Expecting 'procoli' to match within 2 edits")
+	}
+}
@@ -0,0 +1,156 @@
+// Package prefix provides an in-memory prefix index which data sources
+// without a full-text search engine can use to answer typeahead requests.
+package prefix
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Index maps lower-cased word prefixes to completion candidates
+type Index struct {
+	mu     sync.RWMutex
+	lmu    sync.Mutex
+	items  map[string]*item
+	keys   []key
+	sorted bool
+	loaded bool
+}
+
+// item is a completion candidate
+type item struct {
+	text   string
+	field  string
+	weight float64
+}
+
+// key points a searchable string at a completion candidate.  A candidate is
+// keyed once for every word it contains so "Frosted Flakes" completes both
+// "fro" and "fla".
+type key struct {
+	k     string
+	start bool
+	i     *item
+}
+
+// New returns an empty Index
+func New() *Index {
+	return &Index{items: make(map[string]*item)}
+}
+
+// Add puts a completion candidate for field into the index.  Adding the same
+// text more than once increases its weight.
+func (ix *Index) Add(field string, text string, weight float64) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	id := field + "|" + strings.ToLower(text)
+	if it, ok := ix.items[id]; ok {
+		it.weight += weight
+		return
+	}
+	it := &item{text: text, field: field, weight: weight}
+	ix.items[id] = it
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i := range words {
+		ix.keys = append(ix.keys, key{k: strings.Join(words[i:], " "), start: i == 0, i: it})
+	}
+	ix.sorted = false
+}
+
+// AddList splits a list such as an ingredients statement into its items and
+// adds each of them to the index
+func (ix *Index) AddList(field string, list string, weight float64) {
+	for _, t := range strings.FieldsFunc(list, func(r rune) bool { return strings.ContainsRune(",;()[].", r) }) {
+		ix.Add(field, t, weight)
+	}
+}
+
+// Complete returns up to max candidates which have a word beginning with
+// prefix ordered by weight.  Candidates whose text begins with the prefix are
+// ranked ahead of those which match on an inner word.  An empty field matches
+// candidates from all fields.
+func (ix *Index) Complete(prefix string, field string, max int) []fdc.Suggestion {
+	var s []fdc.Suggestion
+	prefix = strings.Join(strings.FieldsFunc(strings.ToLower(prefix), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+	if prefix == "" || max <= 0 {
+		return s
+	}
+	ix.sort()
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	seen := make(map[*item]float64)
+	for i := sort.Search(len(ix.keys), func(i int) bool { return ix.keys[i].k >= prefix }); i < len(ix.keys) && strings.HasPrefix(ix.keys[i].k, prefix); i++ {
+		k := ix.keys[i]
+		if field != "" && k.i.field != field {
+			continue
+		}
+		score := k.i.weight
+		if k.start {
+			score *= 2
+		}
+		if score > seen[k.i] {
+			seen[k.i] = score
+		}
+	}
+	for it, score := range seen {
+		s = append(s, fdc.Suggestion{Text: it.text, Field: it.field, Score: score})
+	}
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Score != s[j].Score {
+			return s[i].Score > s[j].Score
+		}
+		return s[i].Text < s[j].Text
+	})
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// Len returns the number of completion candidates in the index
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.items)
+}
+
+// Load fills the index by calling load the first time it is invoked.  If
+// load fails the index is cleared so a later call can try again.
+func (ix *Index) Load(load func(ix *Index) error) error {
+	ix.lmu.Lock()
+	defer ix.lmu.Unlock()
+	if ix.loaded {
+		return nil
+	}
+	if err := load(ix); err != nil {
+		ix.mu.Lock()
+		ix.items = make(map[string]*item)
+		ix.keys = nil
+		ix.mu.Unlock()
+		return err
+	}
+	ix.loaded = true
+	return nil
+}
+
+func (ix *Index) sort() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.sorted {
+		return
+	}
+	sort.Slice(ix.keys, func(i, j int) bool { return ix.keys[i].k < ix.keys[j].k })
+	ix.sorted = true
+}
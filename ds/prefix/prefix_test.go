@@ -0,0 +1,61 @@
+package prefix
+
+import (
+	"errors"
+	"testing"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestComplete(t *testing.T) {
+	ix := New()
+	ix.Add(fdc.DESCRIPTION, "Frosted Flakes", 1)
+	ix.Add(fdc.DESCRIPTION, "Corn Flakes", 1)
+	ix.Add(fdc.DESCRIPTION, "Corn Flakes", 1)
+	ix.Add(fdc.COMPANY, "Kellogg Company", 1)
+	ix.AddList(fdc.INGREDIENTS, "MILLED CORN, SUGAR, MALT FLAVOR (CONTAINS BARLEY).", 1)
+
+	s := ix.Complete("flak", "", 10)
+	if len(s) != 2 {
+		t.Fatalf("Expecting 2 suggestions for 'flak' got %d %v", len(s), s)
+	}
+	if s[0].Text != "Corn Flakes" {
+		t.Errorf("Expecting heavier 'Corn Flakes' first got %s", s[0].Text)
+	}
+	s = ix.Complete("cor", "", 10)
+	if len(s) != 2 || s[0].Text != "Corn Flakes" || s[1].Text != "MILLED CORN" {
+		t.Errorf("Expecting leading word match ranked first got %v", s)
+	}
+	if s = ix.Complete("corn fl", "", 10); len(s) != 1 {
+		t.Errorf("Expecting 1 suggestion for 'corn fl' got %v", s)
+	}
+	if s = ix.Complete("k", fdc.DESCRIPTION, 10); len(s) != 0 {
+		t.Errorf("Expecting field filter to exclude company got %v", s)
+	}
+	if s = ix.Complete("barley", fdc.INGREDIENTS, 10); len(s) != 1 || s[0].Text != "CONTAINS BARLEY" {
+		t.Errorf("Expecting ingredient list item got %v", s)
+	}
+	if s = ix.Complete("f", "", 1); len(s) != 1 {
+		t.Errorf("Expecting max to limit suggestions got %v", s)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ix := New()
+	err := ix.Load(func(ix *Index) error {
+		ix.Add(fdc.DESCRIPTION, "Broccoli", 1)
+		return errors.New("failed")
+	})
+	if err == nil || ix.Len() != 0 {
+		t.Errorf("Expecting a failed load to clear the index, have %d items", ix.Len())
+	}
+	for i := 0; i < 2; i++ {
+		ix.Load(func(ix *Index) error {
+			ix.Add(fdc.DESCRIPTION, "Broccoli", 1)
+			return nil
+		})
+	}
+	if s := ix.Complete("bro", "", 5); len(s) != 1 || s[0].Score != 2 {
+		t.Errorf("Expecting index to be loaded once got %v", s)
+	}
+}
@@ -0,0 +1,70 @@
+// Package gtin validates and normalizes Global Trade Item Numbers so that the
+// UPC-A, EAN-13 and GTIN-14 forms of a barcode identify the same food
+package gtin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Length is the number of digits in a normalized GTIN
+const Length = 14
+
+// Normalize validates the check digit of a GTIN-8, UPC-A (GTIN-12), EAN-13
+// or GTIN-14 and returns it as a GTIN-14 padded with leading zeros.  Spaces
+// and dashes are ignored.
+func Normalize(code string) (string, error) {
+	c := strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+	for _, r := range c {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("GTIN %s must contain only digits", code)
+		}
+	}
+	switch len(c) {
+	case 8, 12, 13, 14:
+	default:
+		return "", fmt.Errorf("GTIN %s must have 8, 12, 13 or 14 digits", code)
+	}
+	if cd := CheckDigit(c[:len(c)-1]); int(c[len(c)-1]-'0') != cd {
+		return "", fmt.Errorf("GTIN %s has an invalid check digit, expecting %d", code, cd)
+	}
+	return strings.Repeat("0", Length-len(c)) + c, nil
+}
+
+// Valid reports whether a code is a GTIN with a correct check digit
+func Valid(code string) bool {
+	_, err := Normalize(code)
+	return err == nil
+}
+
+// CheckDigit returns the GS1 mod 10 check digit for the digits of a GTIN
+// without its check digit.  Digits are weighted 3 and 1 alternately from the
+// right.
+func CheckDigit(digits string) int {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// Forms returns the equivalent GTIN-14, EAN-13, UPC-A and GTIN-8 forms of a
+// normalized GTIN, longest first, which can be stored without leading zeros
+func Forms(gtin14 string) ([]string, error) {
+	if len(gtin14) != Length {
+		return nil, errors.New("Forms requires a normalized GTIN")
+	}
+	forms := []string{gtin14}
+	for _, n := range []int{13, 12, 8} {
+		if strings.Trim(gtin14[:Length-n], "0") != "" {
+			break
+		}
+		forms = append(forms, gtin14[Length-n:])
+	}
+	return forms, nil
+}
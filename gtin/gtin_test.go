@@ -0,0 +1,41 @@
+package gtin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for code, want := range map[string]string{
+		"011150548885":    "00011150548885",
+		"0011150548885":   "00011150548885",
+		"00011150548885":  "00011150548885",
+		"0-11150-54888-5": "00011150548885",
+		"4006381333931":   "04006381333931",
+		"96385074":        "00000096385074",
+		"10614141000415":  "10614141000415",
+	} {
+		if got, err := Normalize(code); err != nil || got != want {
+			t.Errorf("Normalize(%s) = %s %v, want %s", code, got, err, want)
+		}
+	}
+	for _, code := range []string{"011150548886", "01115054888", "01115054888X", "", "123456789012345"} {
+		if _, err := Normalize(code); err == nil {
+			t.Errorf("Expecting an error normalizing %s", code)
+		}
+	}
+}
+
+func TestForms(t *testing.T) {
+	f, err := Forms("00011150548885")
+	if want := []string{"00011150548885", "0011150548885", "011150548885"}; err != nil || !reflect.DeepEqual(f, want) {
+		t.Errorf("Forms = %v %v, want %v", f, err, want)
+	}
+	f, _ = Forms("00000096385074")
+	if len(f) != 4 || f[3] != "96385074" {
+		t.Errorf("Expecting a GTIN-8 form got %v", f)
+	}
+	if _, err = Forms("011150548885"); err == nil {
+		t.Error("Expecting an error for a code which is not normalized")
+	}
+}
@@ -0,0 +1,164 @@
+// Package ingredient parses branded food ingredient statements into
+// normalized ingredient tokens which can be stored on a food and used to
+// include or exclude foods by ingredient.
+package ingredient
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+var (
+	// qualifiers introduce minor ingredients, e.g. "contains 2% or less of"
+	qualifiers = regexp.MustCompile(`(contains\s+)?(less\s+than\s+)?\d+(\.\d+)?\s*%\s*(or\s+less\s+)?(of\s*)?(each\s+)?(of\s+the\s+following\s*)?:?|(contains\s+)?(one\s+or\s+more\s+of\s+the\s+following|the\s+following)\s*:?`)
+	// statements end the list of ingredients, e.g. "contains: milk" or
+	// "may contain traces of peanuts"
+	statements = regexp.MustCompile(`^(contains\b|may\s+contain|manufactured\s+(in|on)|processed\s+(in|on)|produced\s+in|made\s+in\s+a\s+facility)`)
+	// prefixes are dropped from the start of an ingredient
+	prefixes = []string{"ingredients:", "ingredients", "and ", "or ", "also ", "with "}
+)
+
+// Tokenize sets the ingredient tokens of a food from its ingredient statement
+func Tokenize(f *fdc.Food) {
+	f.IngredientTokens = Parse(f.Ingredients)
+}
+
+// Parse splits an ingredient statement into a list of unique, normalized
+// ingredients.  An ingredient with sub-ingredients in parentheses or brackets,
+// e.g. "enriched flour (wheat flour, niacin)", yields the ingredient followed
+// by each of its sub-ingredients.  Qualifiers such as "contains 2% or less of"
+// are removed and allergen or facility statements which follow the
+// ingredients are ignored.
+func Parse(statement string) []string {
+	var tokens []string
+	seen := make(map[string]bool)
+	parse(strings.ToLower(statement), func(t string) {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			tokens = append(tokens, t)
+		}
+	})
+	return tokens
+}
+
+// parse splits s on separators outside of parentheses and passes each
+// normalized ingredient to add.  Nested lists are parsed recursively.
+func parse(s string, add func(string)) {
+	var (
+		item, sub strings.Builder
+		level     int
+	)
+	flush := func() bool {
+		text := item.String()
+		inner := sub.String()
+		item.Reset()
+		sub.Reset()
+		text = qualifiers.ReplaceAllString(text, " ")
+		if statements.MatchString(strings.TrimSpace(text)) {
+			return false
+		}
+		for _, alt := range strings.Split(text, "and/or") {
+			add(Normalize(alt))
+		}
+		if inner != "" {
+			parse(inner, add)
+		}
+		return true
+	}
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case c == '(' || c == '[' || c == '{':
+			if level > 0 {
+				sub.WriteRune(c)
+			}
+			level++
+		case c == ')' || c == ']' || c == '}':
+			if level > 0 {
+				level--
+			}
+			if level > 0 {
+				sub.WriteRune(c)
+			}
+		case level > 0:
+			sub.WriteRune(c)
+		case c == ',' || c == ';':
+			if !flush() {
+				return
+			}
+		case c == ':' && !qualifierBefore(item.String()):
+			// keep the colon so that "contains:" ends the ingredients
+			item.WriteRune(c)
+			if !flush() {
+				return
+			}
+		case c == '.' && (i+1 == len(r) || unicode.IsSpace(r[i+1])):
+			// a period ends a sentence but not a decimal number
+			if !flush() {
+				return
+			}
+		default:
+			item.WriteRune(c)
+		}
+	}
+	flush()
+}
+
+// qualifierBefore reports whether a colon ends a qualifier rather than an
+// ingredient, e.g. "contains 2% or less of:"
+func qualifierBefore(s string) bool {
+	s = strings.TrimSpace(s)
+	return s != "" && qualifiers.ReplaceAllString(s, "") == ""
+}
+
+// Normalize lower cases an ingredient, removes punctuation and markers such
+// as * and collapses white space so that it can be compared with tokens
+func Normalize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		case r == '-' || r == '\'' || r == '%' || r == '.':
+			return r
+		}
+		return ' '
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	for again := true; again; {
+		again = false
+		for _, p := range prefixes {
+			if strings.HasPrefix(s, p) {
+				s = strings.TrimSpace(strings.TrimPrefix(s, p))
+				again = true
+			}
+		}
+	}
+	return strings.Trim(s, "-'. ")
+}
+
+// Contains reports whether any of a food's ingredient tokens includes the
+// named ingredient as a whole word or phrase, e.g. "palm oil" is contained in
+// "fractionated palm oil" but not in "palm kernel oil" or "palmitate"
+func Contains(tokens []string, name string) bool {
+	_, ok := Match(tokens, name)
+	return ok
+}
+
+// Match returns the first token which includes the named ingredient as a
+// whole word or phrase
+func Match(tokens []string, name string) (string, bool) {
+	name = " " + Normalize(name) + " "
+	if name == "  " {
+		return "", false
+	}
+	for _, t := range tokens {
+		if strings.Contains(" "+t+" ", name) {
+			return t, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,49 @@
+package ingredient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      []string
+	}{
+		{
+			"INGREDIENTS: ENRICHED FLOUR (WHEAT FLOUR, NIACIN, THIAMINE MONONITRATE {VITAMIN B1}, FOLIC ACID), SUGAR, CONTAINS 2% OR LESS OF: PALM OIL, HIGH FRUCTOSE CORN SYRUP, SALT. CONTAINS: WHEAT, SOY.",
+			[]string{"enriched flour", "wheat flour", "niacin", "thiamine mononitrate", "vitamin b1", "folic acid", "sugar", "palm oil", "high fructose corn syrup", "salt"},
+		},
+		{
+			"Water, tomato paste, less than 2% of: salt, spices*, canola and/or soybean oil. *organic. May contain traces of peanuts.",
+			[]string{"water", "tomato paste", "salt", "spices", "canola", "soybean oil", "organic"},
+		},
+		{
+			"MILK CHOCOLATE [SUGAR, COCOA BUTTER, MILK, SOY LECITHIN (AN EMULSIFIER)], PEANUTS, CONTAINS ONE OR MORE OF THE FOLLOWING: SUNFLOWER OIL, SALT",
+			[]string{"milk chocolate", "sugar", "cocoa butter", "milk", "soy lecithin", "an emulsifier", "peanuts", "sunflower oil", "salt"},
+		},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.statement); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q)\n got %q\nwant %q", tt.statement, got, tt.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tokens := Parse("sugar, fractionated palm oil, palm kernel oil, vitamin a palmitate, high fructose corn syrup")
+	for _, name := range []string{"palm oil", "PALM OIL", "high fructose corn syrup", "sugar"} {
+		if !Contains(tokens, name) {
+			t.Errorf("Expecting %v to contain %s", tokens, name)
+		}
+	}
+	for _, name := range []string{"corn oil", "", "syrup solids"} {
+		if Contains(tokens, name) {
+			t.Errorf("Expecting %v not to contain %s", tokens, name)
+		}
+	}
+	if tok, ok := Match(tokens, "palm oil"); !ok || tok != "fractionated palm oil" {
+		t.Errorf("Expecting palm oil to match fractionated palm oil got %s", tok)
+	}
+}
@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+	gocb "gopkg.in/couchbase/gocb.v1"
+)
+
+// DataSource logs the calls to a DataSource at debug level and adds their
+// time to the datastore timings of the logger's request
+type DataSource struct {
+	d ds.DataSource
+	l *Logger
+}
+
+// Instrument returns d wrapped so its calls are logged by l
+func (l *Logger) Instrument(d ds.DataSource) ds.DataSource {
+	return &DataSource{d: d, l: l}
+}
+
+// FromStore returns the logger of a datastore returned by Instrument, or the
+// default logger for any other.  It lets functions which are handed a
+// request's datastore log with the request's fields.
+func FromStore(d ds.DataSource) *Logger {
+	if ld, ok := d.(*DataSource); ok {
+		return ld.l
+	}
+	return Default()
+}
+
+// observe logs a datastore operation begun at start
+func (d *DataSource) observe(op string, start time.Time, err error, f Fields) {
+	elapsed := time.Since(start)
+	if d.l.req != nil {
+		d.l.req.add(elapsed)
+	}
+	if !d.l.Enabled(DEBUG) {
+		return
+	}
+	if f == nil {
+		f = Fields{}
+	}
+	f["op"], f["op_ms"] = op, Ms(elapsed)
+	if err != nil {
+		f["error"] = err
+	}
+	d.l.Debug("datastore", f)
+}
+
+// ConnectDs is logged as the ConnectDs operation
+func (d *DataSource) ConnectDs(cs fdc.Config) error {
+	start := time.Now()
+	err := d.d.ConnectDs(cs)
+	d.observe("ConnectDs", start, err, nil)
+	return err
+}
+
+// Get is logged as the Get operation with the document id
+func (d *DataSource) Get(q string, f interface{}) error {
+	start := time.Now()
+	err := d.d.Get(q, f)
+	d.observe("Get", start, err, Fields{"id": q})
+	return err
+}
+
+// Query is logged as the Query operation with the statement
+func (d *DataSource) Query(q string, f *[]interface{}) error {
+	start := time.Now()
+	err := d.d.Query(q, f)
+	d.observe("Query", start, err, Fields{"query": q})
+	return err
+}
+
+// Counts is logged as the Counts operation
+func (d *DataSource) Counts(bucket string, doctype string, c *[]interface{}) error {
+	start := time.Now()
+	err := d.d.Counts(bucket, doctype, c)
+	d.observe("Counts", start, err, Fields{"doctype": doctype})
+	return err
+}
+
+// GetDictionary is logged as the GetDictionary operation
+func (d *DataSource) GetDictionary(dsname string, doctype string, offset int64, limit int64) ([]interface{}, error) {
+	start := time.Now()
+	items, err := d.d.GetDictionary(dsname, doctype, offset, limit)
+	d.observe("GetDictionary", start, err, Fields{"doctype": doctype})
+	return items, err
+}
+
+// Browse is logged as the Browse operation with its sort
+func (d *DataSource) Browse(bucket string, where string, offset int64, limit int64, sort string, order string) ([]interface{}, error) {
+	start := time.Now()
+	items, err := d.d.Browse(bucket, where, offset, limit, sort, order)
+	d.observe("Browse", start, err, Fields{"sort": sort, "order": order})
+	return items, err
+}
+
+// Search is logged as the Search operation with its total hits
+func (d *DataSource) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
+	start := time.Now()
+	n, err := d.d.Search(sr, foods, facets)
+	d.observe("Search", start, err, Fields{"searchtype": sr.SearchType, "hits": n})
+	return n, err
+}
+
+// Suggest is logged as the Suggest operation
+func (d *DataSource) Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error {
+	start := time.Now()
+	err := d.d.Suggest(sr, s)
+	d.observe("Suggest", start, err, Fields{"field": sr.Field})
+	return err
+}
+
+// NutrientReport is logged as the NutrientReport operation
+func (d *DataSource) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error {
+	start := time.Now()
+	err := d.d.NutrientReport(bucket, nr, nutrients)
+	d.observe("NutrientReport", start, err, Fields{"nutrient": nr.Nutrient})
+	return err
+}
+
+// Update is logged as the Update operation with the document id
+func (d *DataSource) Update(id string, r interface{}) error {
+	start := time.Now()
+	err := d.d.Update(id, r)
+	d.observe("Update", start, err, Fields{"id": id})
+	return err
+}
+
+// Remove is logged as the Remove operation with the document id
+func (d *DataSource) Remove(id string) error {
+	start := time.Now()
+	err := d.d.Remove(id)
+	d.observe("Remove", start, err, Fields{"id": id})
+	return err
+}
+
+// Counter is logged as the Counter operation with the document id
+func (d *DataSource) Counter(id string, delta int64) (int64, error) {
+	start := time.Now()
+	n, err := d.d.Counter(id, delta)
+	d.observe("Counter", start, err, Fields{"id": id})
+	return n, err
+}
+
+// FoodExists is logged as the FoodExists operation with the document id
+func (d *DataSource) FoodExists(id string) bool {
+	start := time.Now()
+	ok := d.d.FoodExists(id)
+	d.observe("FoodExists", start, nil, Fields{"id": id, "exists": ok})
+	return ok
+}
+
+// Bulk is logged as the Bulk operation with the number of items
+func (d *DataSource) Bulk(n *[]fdc.NutrientData) error {
+	start := time.Now()
+	err := d.d.Bulk(n)
+	d.observe("Bulk", start, err, Fields{"items": len(*n)})
+	return err
+}
+
+// BulkInsert is logged as the BulkInsert operation with the number of items
+func (d *DataSource) BulkInsert(v []gocb.BulkOp) error {
+	start := time.Now()
+	err := d.d.BulkInsert(v)
+	d.observe("BulkInsert", start, err, Fields{"items": len(v)})
+	return err
+}
+
+// Ping is logged as the Ping operation
+func (d *DataSource) Ping(cs fdc.Config) ([]fdc.Check, error) {
+	start := time.Now()
+	checks, err := d.d.Ping(cs)
+	d.observe("Ping", start, err, nil)
+	return checks, err
+}
+
+// CloseDs closes the datastore
+func (d *DataSource) CloseDs() {
+	d.d.CloseDs()
+}
@@ -0,0 +1,280 @@
+// Package logging writes leveled log entries as JSON lines to one or more
+// sinks.  A logger carries fields, such as a request's id and route, which
+// are added to every entry it writes.
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Level is the severity of an entry
+type Level int
+
+// Levels in increasing severity.  A logger writes the entries at or above its
+// level.
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of a level as written in entries
+func (l Level) String() string {
+	if l < DEBUG || l > ERROR {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown log level %q", s)
+}
+
+// Fields are the named values of an entry.  Errors are written as their
+// message.
+type Fields map[string]interface{}
+
+// sink serializes the writes of the loggers which share it
+type sink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Logger writes entries at or above its level with its fields
+type Logger struct {
+	out    *sink
+	level  Level
+	fields Fields
+	req    *request
+}
+
+// New returns a logger which writes to w
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: &sink{w: w}, level: level}
+}
+
+// Open returns a logger which writes to the configured sinks: stdout, stderr
+// or the path of a file which is appended to.  Debug overrides the configured
+// level.
+func Open(cfg fdc.Logging, debug bool) (*Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		level = DEBUG
+	}
+	if len(cfg.Sinks) == 0 {
+		return nil, errors.New("no log sinks are configured")
+	}
+	var ws []io.Writer
+	for _, s := range cfg.Sinks {
+		switch s {
+		case "stdout":
+			ws = append(ws, os.Stdout)
+		case "stderr":
+			ws = append(ws, os.Stderr)
+		default:
+			f, err := os.OpenFile(s, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open log file %s: %v", s, err)
+			}
+			ws = append(ws, f)
+		}
+	}
+	return New(io.MultiWriter(ws...), level), nil
+}
+
+// With returns a logger which adds f to the fields of l
+func (l *Logger) With(f Fields) *Logger {
+	fields := make(Fields, len(l.fields)+len(f))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for k, v := range f {
+		fields[k] = v
+	}
+	return &Logger{out: l.out, level: l.level, fields: fields, req: l.req}
+}
+
+// Enabled reports whether l writes entries at level
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes an entry with the fields of l and f.  Entries written for a
+// request also carry its latency and datastore timings so far.
+func (l *Logger) Log(level Level, msg string, f ...Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := make(Fields, len(l.fields))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	if l.req != nil {
+		l.req.fields(fields)
+	}
+	for _, m := range f {
+		for k, v := range m {
+			fields[k] = v
+		}
+	}
+	l.write(level, msg, fields)
+}
+
+// write encodes an entry as a JSON line with time, level and msg first and
+// the fields after them in name order
+func (l *Logger) write(level Level, msg string, fields Fields) {
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	b.Write(encode(time.Now().UTC().Format(time.RFC3339Nano)))
+	b.WriteString(`,"level":`)
+	b.Write(encode(level.String()))
+	b.WriteString(`,"msg":`)
+	b.Write(encode(msg))
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		b.WriteByte(',')
+		b.Write(encode(k))
+		b.WriteByte(':')
+		b.Write(encode(fields[k]))
+	}
+	b.WriteString("}\n")
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	io.WriteString(l.out.w, b.String())
+}
+
+// encode returns the JSON of a field value
+func encode(v interface{}) []byte {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case time.Duration:
+		v = Ms(t)
+	case fmt.Stringer:
+		v = t.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return b
+}
+
+// Ms returns a duration in milliseconds, the unit of the timings in entries
+func Ms(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
+
+// Debug writes an entry at debug level
+func (l *Logger) Debug(msg string, f ...Fields) {
+	l.Log(DEBUG, msg, f...)
+}
+
+// Info writes an entry at info level
+func (l *Logger) Info(msg string, f ...Fields) {
+	l.Log(INFO, msg, f...)
+}
+
+// Warn writes an entry at warn level
+func (l *Logger) Warn(msg string, f ...Fields) {
+	l.Log(WARN, msg, f...)
+}
+
+// Error writes an entry at error level
+func (l *Logger) Error(msg string, f ...Fields) {
+	l.Log(ERROR, msg, f...)
+}
+
+// Fatal writes an entry at error level and exits
+func (l *Logger) Fatal(msg string, f ...Fields) {
+	l.Log(ERROR, msg, f...)
+	os.Exit(1)
+}
+
+// Writer returns a writer which logs each write to it as an entry at level.
+// It lets the standard log package and gin write entries.
+func (l *Logger) Writer(level Level) io.Writer {
+	return &entryWriter{l: l, level: level}
+}
+
+type entryWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w *entryWriter) Write(p []byte) (int, error) {
+	if msg := strings.TrimSpace(string(p)); msg != "" {
+		w.l.Log(w.level, msg)
+	}
+	return len(p), nil
+}
+
+var (
+	mu  sync.RWMutex
+	std = New(os.Stdout, INFO)
+)
+
+// SetDefault replaces the logger used outside of requests
+func SetDefault(l *Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	std = l
+}
+
+// Default returns the logger used outside of requests
+func Default() *Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+	return std
+}
+
+// Debug writes an entry at debug level with the default logger
+func Debug(msg string, f ...Fields) {
+	Default().Log(DEBUG, msg, f...)
+}
+
+// Info writes an entry at info level with the default logger
+func Info(msg string, f ...Fields) {
+	Default().Log(INFO, msg, f...)
+}
+
+// Warn writes an entry at warn level with the default logger
+func Warn(msg string, f ...Fields) {
+	Default().Log(WARN, msg, f...)
+}
+
+// Error writes an entry at error level with the default logger
+func Error(msg string, f ...Fields) {
+	Default().Log(ERROR, msg, f...)
+}
+
+// Fatal writes an entry at error level with the default logger and exits
+func Fatal(msg string, f ...Fields) {
+	Default().Fatal(msg, f...)
+}
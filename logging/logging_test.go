@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+)
+
+// entries decodes the JSON lines written to b
+func entries(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	var es []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%s is not JSON: %v", line, err)
+		}
+		es = append(es, e)
+	}
+	return es
+}
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, INFO).With(Fields{"app": "fdc"})
+	l.Debug("hidden")
+	l.Info("shown", Fields{"n": 1, "error": errors.New("boom")})
+	l.Writer(WARN).Write([]byte("from the log package\n"))
+	es := entries(t, &b)
+	if len(es) != 2 {
+		t.Fatalf("got %d entries: %s", len(es), b.String())
+	}
+	if e := es[0]; e["level"] != "info" || e["msg"] != "shown" || e["app"] != "fdc" || e["n"] != 1.0 || e["error"] != "boom" {
+		t.Errorf("got %v", e)
+	}
+	if e := es[1]; e["level"] != "warn" || e["msg"] != "from the log package" {
+		t.Errorf("got %v", e)
+	}
+	if !strings.HasPrefix(b.String(), `{"time":"`) || !strings.Contains(b.String(), `"level":"info","msg":"shown","app":"fdc"`) {
+		t.Errorf("time, level and msg don't lead: %s", b.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != WARN {
+		t.Errorf("got %v %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("parsed an unknown level")
+	}
+}
+
+// getDs answers every Get with an error
+type getDs struct {
+	ds.DataSource
+}
+
+func (getDs) Get(q string, f interface{}) error {
+	return errors.New("key not found")
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var b bytes.Buffer
+	r := gin.New()
+	r.Use(Middleware(New(&b, DEBUG)))
+	r.GET("/food/:id", func(c *gin.Context) {
+		Annotate(c, Fields{"api_key": "abc"})
+		var f interface{}
+		From(c).Instrument(getDs{}).Get(c.Param("id"), &f)
+		From(c).Info("handled")
+		c.Status(http.StatusNotFound)
+	})
+	for _, id := range []string{"client-id-1", "bad id\n", ""} {
+		b.Reset()
+		req := httptest.NewRequest("GET", "/food/389714", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		got := w.Header().Get(RequestIDHeader)
+		if id == "client-id-1" && got != id || id != "client-id-1" && len(got) != 32 {
+			t.Errorf("sent id %q got %q", id, got)
+		}
+		es := entries(t, &b)
+		if len(es) != 3 {
+			t.Fatalf("got %d entries: %s", len(es), b.String())
+		}
+		for _, e := range es {
+			if e["request_id"] != got || e["route"] != "/food/:id" || e["latency_ms"] == nil || e["ds_calls"] == nil {
+				t.Errorf("entry lacks the request fields: %v", e)
+			}
+		}
+		if e := es[0]; e["msg"] != "datastore" || e["op"] != "Get" || e["id"] != "389714" || e["error"] != "key not found" {
+			t.Errorf("got datastore entry %v", e)
+		}
+		if e := es[2]; e["msg"] != "request" || e["status"] != 404.0 || e["ds_calls"] != 1.0 || e["api_key"] != "abc" || e["level"] != "info" {
+			t.Errorf("got request entry %v", e)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the id of a request from the client or a proxy in
+// front of the server and back in the response
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	loggerKey    = "logger"
+	requestIDKey = "requestId"
+	maxIDLength  = 128
+)
+
+// request times a request and totals its datastore calls
+type request struct {
+	start   time.Time
+	mu      sync.Mutex
+	calls   int
+	elapsed time.Duration
+}
+
+// add counts a datastore call which took d
+func (r *request) add(d time.Duration) {
+	r.mu.Lock()
+	r.calls++
+	r.elapsed += d
+	r.mu.Unlock()
+}
+
+// fields sets the latency of the request and its datastore timings so far
+func (r *request) fields(f Fields) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f["latency_ms"] = Ms(time.Since(r.start))
+	f["ds_calls"] = r.calls
+	f["ds_ms"] = Ms(r.elapsed)
+}
+
+// Middleware gives each request an id and a logger which writes the id and
+// the request's route with every entry.  A client's X-Request-ID is kept when
+// it is printable and not too long, else a new id is made, and it is echoed
+// in the response.  Each request is logged when it completes: at error level
+// for a 5xx status and at info level otherwise.
+func Middleware(l *Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validID(id) {
+			id = newID()
+		}
+		c.Header(RequestIDHeader, id)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		rl := l.With(Fields{"request_id": id, "route": route})
+		rl.req = &request{start: time.Now()}
+		c.Set(requestIDKey, id)
+		c.Set(loggerKey, rl)
+		c.Next()
+		rl = From(c)
+		f := Fields{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+			"status": c.Writer.Status(),
+			"bytes":  c.Writer.Size(),
+			"ip":     c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			f["errors"] = c.Errors.String()
+		}
+		level := INFO
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = ERROR
+		}
+		rl.Log(level, "request", f)
+	}
+}
+
+// From returns the logger of a request, or the default logger outside one
+func From(c *gin.Context) *Logger {
+	if c != nil {
+		if v, ok := c.Get(loggerKey); ok {
+			if l, ok := v.(*Logger); ok {
+				return l
+			}
+		}
+	}
+	return Default()
+}
+
+// Annotate adds f to the fields of the entries written for a request from
+// now on, including the one written when it completes
+func Annotate(c *gin.Context, f Fields) {
+	c.Set(loggerKey, From(c).With(f))
+}
+
+// RequestID returns the id given to a request by Middleware
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// validID reports whether an id from a client is safe to log and echo
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newID returns a random 128 bit id in hex
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,104 @@
+// Package mail sends mail to users.  Senders are chosen by configuration:
+// log and file senders are for development, smtp for production.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Message is a plain text mail message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender sends messages
+type Sender interface {
+	Send(m Message) error
+}
+
+// LogSender writes messages to the log
+type LogSender struct{}
+
+// FileSender writes each message to a file in Dir
+type FileSender struct {
+	Dir string
+}
+
+// SMTPSender sends messages through an SMTP server
+type SMTPSender struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// New returns the Sender configured by cfg
+func New(cfg fdc.Mail) (Sender, error) {
+	switch cfg.Sender {
+	case "log":
+		return LogSender{}, nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, errors.New("the file mail sender needs a directory")
+		}
+		if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+			return nil, err
+		}
+		return FileSender{Dir: cfg.Dir}, nil
+	case "smtp":
+		if cfg.Host == "" || cfg.From == "" {
+			return nil, errors.New("the smtp mail sender needs a host and from address")
+		}
+		s := SMTPSender{Addr: cfg.Host + ":" + strconv.Itoa(cfg.Port), From: cfg.From}
+		if cfg.User != "" {
+			s.Auth = smtp.PlainAuth("", cfg.User, cfg.Pwd, cfg.Host)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown mail sender %s", cfg.Sender)
+}
+
+// Send logs m
+func (LogSender) Send(m Message) error {
+	logging.Info("mail", logging.Fields{"to": m.To, "subject": m.Subject, "body": m.Body})
+	return nil
+}
+
+// Send writes m to a new file
+func (s FileSender) Send(m Message) error {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(b))
+	return ioutil.WriteFile(filepath.Join(s.Dir, name), format("", m), 0600)
+}
+
+// Send sends m to the SMTP server
+func (s SMTPSender) Send(m Message) error {
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{m.To}, format(s.From, m))
+}
+
+// format returns m with its headers
+func format(from string, m Message) []byte {
+	var b bytes.Buffer
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", m.To, m.Subject, time.Now().UTC().Format(time.RFC1123Z), m.Body)
+	return b.Bytes()
+}
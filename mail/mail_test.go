@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		cfg fdc.Mail
+		ok  bool
+	}{
+		{fdc.Mail{Sender: "log"}, true},
+		{fdc.Mail{Sender: "file"}, false},
+		{fdc.Mail{Sender: "smtp", Host: "mail.example.com"}, false},
+		{fdc.Mail{Sender: "smtp", Host: "mail.example.com", Port: 587, From: "noreply@example.com", User: "u"}, true},
+		{fdc.Mail{Sender: "pigeon"}, false},
+	} {
+		if _, err := New(tt.cfg); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v", tt.cfg, err)
+		}
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := New(fdc.Mail{Sender: "file", Dir: filepath.Join(dir, "out")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Send(Message{To: "user@example.com", Subject: "Reset", Body: "token 1234"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "out", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("got %d files", len(files))
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	for _, want := range []string{"To: user@example.com\r\n", "Subject: Reset\r\n", "\r\n\r\ntoken 1234"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("message lacks %q:\n%s", want, b)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+	gocb "gopkg.in/couchbase/gocb.v1"
+)
+
+// DataSource times the calls to a DataSource and counts their errors
+type DataSource struct {
+	d ds.DataSource
+	m *Metrics
+}
+
+// Instrument returns d wrapped so its calls are measured
+func (m *Metrics) Instrument(d ds.DataSource) ds.DataSource {
+	return &DataSource{d: d, m: m}
+}
+
+// ConnectDs connects to the datastore and sets the connection state
+func (d *DataSource) ConnectDs(cs fdc.Config) error {
+	start := time.Now()
+	err := d.d.ConnectDs(cs)
+	d.m.observe("ConnectDs", start, err)
+	if err == nil {
+		d.m.connected.Set(1)
+	}
+	return err
+}
+
+// Get is measured as the Get operation
+func (d *DataSource) Get(q string, f interface{}) error {
+	start := time.Now()
+	err := d.d.Get(q, f)
+	d.m.observe("Get", start, err)
+	return err
+}
+
+// Query is measured as the Query operation
+func (d *DataSource) Query(q string, f *[]interface{}) error {
+	start := time.Now()
+	err := d.d.Query(q, f)
+	d.m.observe("Query", start, err)
+	return err
+}
+
+// Counts is measured as the Counts operation
+func (d *DataSource) Counts(bucket string, doctype string, c *[]interface{}) error {
+	start := time.Now()
+	err := d.d.Counts(bucket, doctype, c)
+	d.m.observe("Counts", start, err)
+	return err
+}
+
+// GetDictionary is measured as the GetDictionary operation
+func (d *DataSource) GetDictionary(dsname string, doctype string, offset int64, limit int64) ([]interface{}, error) {
+	start := time.Now()
+	items, err := d.d.GetDictionary(dsname, doctype, offset, limit)
+	d.m.observe("GetDictionary", start, err)
+	return items, err
+}
+
+// Browse is measured as the Browse operation
+func (d *DataSource) Browse(bucket string, where string, offset int64, limit int64, sort string, order string) ([]interface{}, error) {
+	start := time.Now()
+	items, err := d.d.Browse(bucket, where, offset, limit, sort, order)
+	d.m.observe("Browse", start, err)
+	return items, err
+}
+
+// Search is measured as the Search operation and its total hits are
+// observed
+func (d *DataSource) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
+	start := time.Now()
+	n, err := d.d.Search(sr, foods, facets)
+	d.m.observe("Search", start, err)
+	if err == nil {
+		d.m.hits.Observe(float64(n))
+	}
+	return n, err
+}
+
+// Suggest is measured as the Suggest operation
+func (d *DataSource) Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error {
+	start := time.Now()
+	err := d.d.Suggest(sr, s)
+	d.m.observe("Suggest", start, err)
+	return err
+}
+
+// NutrientReport is measured as the NutrientReport operation
+func (d *DataSource) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error {
+	start := time.Now()
+	err := d.d.NutrientReport(bucket, nr, nutrients)
+	d.m.observe("NutrientReport", start, err)
+	return err
+}
+
+// Update is measured as the Update operation
+func (d *DataSource) Update(id string, r interface{}) error {
+	start := time.Now()
+	err := d.d.Update(id, r)
+	d.m.observe("Update", start, err)
+	return err
+}
+
+// Remove is measured as the Remove operation
+func (d *DataSource) Remove(id string) error {
+	start := time.Now()
+	err := d.d.Remove(id)
+	d.m.observe("Remove", start, err)
+	return err
+}
+
+// Counter is measured as the Counter operation
+func (d *DataSource) Counter(id string, delta int64) (int64, error) {
+	start := time.Now()
+	n, err := d.d.Counter(id, delta)
+	d.m.observe("Counter", start, err)
+	return n, err
+}
+
+// FoodExists is measured as the FoodExists operation.  It can't fail.
+func (d *DataSource) FoodExists(id string) bool {
+	start := time.Now()
+	ok := d.d.FoodExists(id)
+	d.m.observe("FoodExists", start, nil)
+	return ok
+}
+
+// Bulk is measured as the Bulk operation
+func (d *DataSource) Bulk(n *[]fdc.NutrientData) error {
+	start := time.Now()
+	err := d.d.Bulk(n)
+	d.m.observe("Bulk", start, err)
+	return err
+}
+
+// BulkInsert is measured as the BulkInsert operation
+func (d *DataSource) BulkInsert(v []gocb.BulkOp) error {
+	start := time.Now()
+	err := d.d.BulkInsert(v)
+	d.m.observe("BulkInsert", start, err)
+	return err
+}
+
+// Ping is measured as the Ping operation and sets the connection state
+func (d *DataSource) Ping(cs fdc.Config) ([]fdc.Check, error) {
+	start := time.Now()
+	checks, err := d.d.Ping(cs)
+	d.m.observe("Ping", start, err)
+	if err == nil {
+		d.m.connected.Set(1)
+	} else {
+		d.m.connected.Set(0)
+	}
+	return checks, err
+}
+
+// CloseDs closes the datastore and clears the connection state
+func (d *DataSource) CloseDs() {
+	d.d.CloseDs()
+	d.m.connected.Set(0)
+}
@@ -0,0 +1,112 @@
+// Package metrics exposes Prometheus metrics of the web server and its
+// datastore.  Requests are counted and timed by gin route and status and
+// every DataSource call by operation, whatever the backend.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fdc"
+
+// Metrics holds the collectors of a server in their own registry
+type Metrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	dsLatency *prometheus.HistogramVec
+	dsErrors  *prometheus.CounterVec
+	hits      prometheus.Histogram
+	connected prometheus.Gauge
+}
+
+// New returns metrics registered with a new registry along with the Go
+// runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latencies by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dsLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ds",
+			Name:      "operation_duration_seconds",
+			Help:      "Datastore operation latencies by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		dsErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ds",
+			Name:      "operation_errors_total",
+			Help:      "Datastore operations which returned an error by operation.",
+		}, []string{"operation"}),
+		hits: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ds",
+			Name:      "search_total_hits",
+			Help:      "Total hits of full text searches.",
+			Buckets:   prometheus.ExponentialBuckets(1, 10, 7),
+		}),
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "ds",
+			Name:      "connected",
+			Help:      "1 while the datastore is connected.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.latency, m.dsLatency, m.dsErrors, m.hits, m.connected,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics to Prometheus
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times requests.  Requests are labelled with their
+// route pattern, not their path, so ids don't multiply the series; requests
+// which match no route are labelled unmatched.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.latency.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observe records the latency and any error of a datastore operation begun
+// at start
+func (m *Metrics) observe(op string, start time.Time, err error) {
+	m.dsLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.dsErrors.WithLabelValues(op).Inc()
+	}
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// fakeDs fails its gets and finds 42 hits for every search
+type fakeDs struct {
+	ds.DataSource
+}
+
+func (f *fakeDs) ConnectDs(cs fdc.Config) error {
+	return nil
+}
+
+func (f *fakeDs) Get(q string, v interface{}) error {
+	return errors.New("key not found")
+}
+
+func (f *fakeDs) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
+	return 42, nil
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New()
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/food/:id", func(c *gin.Context) { c.String(http.StatusOK, c.Param("id")) })
+	for _, p := range []string{"/food/1", "/food/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+	out := scrape(t, m)
+	for _, want := range []string{
+		`fdc_http_requests_total{method="GET",route="/food/:id",status="200"} 2`,
+		`fdc_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`fdc_http_request_duration_seconds_count{method="GET",route="/food/:id",status="200"} 2`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	m := New()
+	d := m.Instrument(&fakeDs{})
+	if err := d.ConnectDs(fdc.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := d.Get("FOOD:1", &v); err == nil {
+		t.Error("the error of Get was lost")
+	}
+	if n, _ := d.Search(fdc.SearchRequest{}, nil, nil); n != 42 {
+		t.Errorf("got %d hits", n)
+	}
+	out := scrape(t, m)
+	for _, want := range []string{
+		"fdc_ds_connected 1",
+		`fdc_ds_operation_errors_total{operation="Get"} 1`,
+		`fdc_ds_operation_duration_seconds_count{operation="Search"} 1`,
+		"fdc_ds_search_total_hits_sum 42",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `fdc_ds_operation_errors_total{operation="Search"}`) {
+		t.Error("counted an error for a search which succeeded")
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -13,6 +16,23 @@ import (
 type Config struct {
 	CouchDb CouchDb
 	Aws     Aws
+	Diet    Diet
+	APIKeys APIKeys
+	JWT     JWT
+	Mail    Mail
+	// Passwords and Lockout harden logins
+	Passwords Passwords
+	Lockout   Lockout
+	OIDC      OIDC
+	Logging   Logging
+	Tracing   Tracing
+	// RateLimits are the limits of each route group: read, user, admin and
+	// login
+	RateLimits map[string]RateLimit
+	// TrustedProxies are the addresses or CIDR ranges of the proxies whose
+	// X-Forwarded-For headers name clients.  Without any the client is the
+	// peer which connected.
+	TrustedProxies []string
 }
 
 // CouchDb configuration for connecting, reading and writing Couchbase nodes
@@ -30,6 +50,131 @@ type Aws struct {
 	Region string // AWS region
 }
 
+// Diet configures dietary classification
+type Diet struct {
+	Rules string // YAML file of rules which add to or replace the built in rules
+}
+
+// APIKeys configures API key authentication of the read endpoints
+type APIKeys struct {
+	Required bool   // reject anonymous reads which carry neither a key nor a token
+	Header   string // request header which carries a key
+	Query    string // query parameter which carries a key
+}
+
+// JWT configures the signing and verification of login tokens.  HS
+// algorithms sign with Secret; RS and ES algorithms sign with the PEM
+// PrivateKey file.
+type JWT struct {
+	Algorithm  string        // HS256, HS384, HS512, RS256, RS384, RS512, ES256, ES384 or ES512
+	Secret     string        // shared secret of at least 32 characters
+	PrivateKey string        // PEM file of an RSA or ECDSA private key
+	KeyID      string        // kid of the signing key, derived from the key if empty
+	Timeout    time.Duration // lifetime of a token
+	MaxRefresh time.Duration // how long after it's issued a token may be refreshed
+	Issuer     string
+	Audience   string
+	// VerifyKeys are retired keys which still verify the tokens they signed
+	VerifyKeys []JWTKey
+}
+
+// JWTKey is a key which only verifies tokens.  HS algorithms verify with
+// Secret; RS and ES algorithms with the PEM PublicKey file.
+type JWTKey struct {
+	KeyID     string
+	Algorithm string
+	Secret    string
+	PublicKey string
+}
+
+// Mail configures how mail to users is sent
+type Mail struct {
+	Sender   string // log, file or smtp
+	Dir      string // directory the file sender writes messages to
+	Host     string // SMTP server
+	Port     int
+	User     string
+	Pwd      string
+	From     string
+	ResetURL string // page which completes a password reset, the token is appended
+}
+
+// Passwords configures the password policy and hashing
+type Passwords struct {
+	MinLength int    // fewest characters
+	Classes   int    // fewest kinds of lower case, upper case, digit and symbol characters
+	Breached  string // file of breached passwords or their SHA-1 hashes, one a line
+	Cost      int    // bcrypt cost, hashes of another cost are replaced at login
+}
+
+// Lockout configures how accounts are locked after failed logins
+type Lockout struct {
+	Attempts    *int          // consecutive failures which lock an account, 0 never locks, defaults to 5
+	Duration    time.Duration // first lockout, doubled by each further failure
+	MaxDuration time.Duration // longest lockout
+}
+
+// OIDC configures login through an OpenID Connect identity provider.  It's
+// turned off unless Issuer is set.
+type OIDC struct {
+	Issuer        string // URL of the identity provider
+	ClientID      string
+	ClientSecret  string // empty for public clients
+	RedirectURL   string // the API's callback route as registered with the provider
+	Scopes        []string
+	UsernameClaim string // claim which names users, preferred_username by default
+	RoleClaim     string // claim which lists a user's groups or roles
+	// Roles map values of RoleClaim to roles, the first match wins
+	Roles []OIDCRole
+	// DefaultRole is given to users without a matching value, users aren't
+	// let in if it's empty
+	DefaultRole string
+}
+
+// OIDCRole maps a value of the role claim to a role
+type OIDCRole struct {
+	Value string
+	Role  string
+}
+
+// Logging sets the level of log entries and where they are written: stdout,
+// stderr or the path of a file.  The -l file and stdout are used when no sinks
+// are configured.
+type Logging struct {
+	Level string
+	Sinks []string
+}
+
+// Tracing sets where the spans of requests and their datastore calls are
+// exported: otlp sends them to the OpenTelemetry collector at Endpoint, a URL
+// such as http://localhost:4318, and stdout or file write them as JSON for
+// local testing.  Tracing is off when no exporter is set.  Sample is the
+// fraction of new traces kept and defaults to all of them; a trace begun by a
+// caller is kept whenever the caller kept it.
+type Tracing struct {
+	Exporter string
+	Endpoint string
+	File     string
+	Service  string
+	Sample   float64
+}
+
+// RateLimit allows Burst requests at once refilled at Rate requests a second
+// and at most Daily requests a day.  Zero turns off a limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	Daily int
+}
+
+// defaultRateLimits apply to route groups which aren't configured
+var defaultRateLimits = map[string]RateLimit{
+	"read":  {Rate: 10, Burst: 50},
+	"user":  {Rate: 5, Burst: 20},
+	"admin": {},
+	"login": {Rate: 0.2, Burst: 5},
+}
+
 // Defaults sets values for CouchBase configuration properties if none have been provided.
 func (cs *Config) Defaults() {
 	if os.Getenv("COUCHBASE_URL") != "" {
@@ -53,6 +198,94 @@ func (cs *Config) Defaults() {
 	if os.Getenv("AWS_DYNAMODB_REGION") != "" {
 		cs.Aws.Table = os.Getenv("AWS_DYNAMODB_REGION")
 	}
+	if os.Getenv("DIET_RULES") != "" {
+		cs.Diet.Rules = os.Getenv("DIET_RULES")
+	}
+	if os.Getenv("API_KEYS_REQUIRED") != "" {
+		cs.APIKeys.Required = os.Getenv("API_KEYS_REQUIRED") == "true"
+	}
+	if os.Getenv("JWT_ALGORITHM") != "" {
+		cs.JWT.Algorithm = os.Getenv("JWT_ALGORITHM")
+	}
+	if os.Getenv("JWT_SECRET") != "" {
+		cs.JWT.Secret = os.Getenv("JWT_SECRET")
+	}
+	if os.Getenv("JWT_PRIVATE_KEY") != "" {
+		cs.JWT.PrivateKey = os.Getenv("JWT_PRIVATE_KEY")
+	}
+	if os.Getenv("JWT_KEY_ID") != "" {
+		cs.JWT.KeyID = os.Getenv("JWT_KEY_ID")
+	}
+	if os.Getenv("JWT_ISSUER") != "" {
+		cs.JWT.Issuer = os.Getenv("JWT_ISSUER")
+	}
+	if os.Getenv("JWT_AUDIENCE") != "" {
+		cs.JWT.Audience = os.Getenv("JWT_AUDIENCE")
+	}
+	if os.Getenv("MAIL_SENDER") != "" {
+		cs.Mail.Sender = os.Getenv("MAIL_SENDER")
+	}
+	if os.Getenv("MAIL_HOST") != "" {
+		cs.Mail.Host = os.Getenv("MAIL_HOST")
+	}
+	if os.Getenv("MAIL_USER") != "" {
+		cs.Mail.User = os.Getenv("MAIL_USER")
+	}
+	if os.Getenv("MAIL_PWD") != "" {
+		cs.Mail.Pwd = os.Getenv("MAIL_PWD")
+	}
+	if os.Getenv("MAIL_FROM") != "" {
+		cs.Mail.From = os.Getenv("MAIL_FROM")
+	}
+	if os.Getenv("MAIL_RESET_URL") != "" {
+		cs.Mail.ResetURL = os.Getenv("MAIL_RESET_URL")
+	}
+	if os.Getenv("PASSWORD_MIN_LENGTH") != "" {
+		cs.Passwords.MinLength, _ = strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	}
+	if os.Getenv("PASSWORD_CLASSES") != "" {
+		cs.Passwords.Classes, _ = strconv.Atoi(os.Getenv("PASSWORD_CLASSES"))
+	}
+	if os.Getenv("PASSWORD_BREACHED") != "" {
+		cs.Passwords.Breached = os.Getenv("PASSWORD_BREACHED")
+	}
+	if os.Getenv("BCRYPT_COST") != "" {
+		cs.Passwords.Cost, _ = strconv.Atoi(os.Getenv("BCRYPT_COST"))
+	}
+	if os.Getenv("LOCKOUT_ATTEMPTS") != "" {
+		n, _ := strconv.Atoi(os.Getenv("LOCKOUT_ATTEMPTS"))
+		cs.Lockout.Attempts = &n
+	}
+	if os.Getenv("OIDC_ISSUER") != "" {
+		cs.OIDC.Issuer = os.Getenv("OIDC_ISSUER")
+	}
+	if os.Getenv("OIDC_CLIENT_ID") != "" {
+		cs.OIDC.ClientID = os.Getenv("OIDC_CLIENT_ID")
+	}
+	if os.Getenv("OIDC_CLIENT_SECRET") != "" {
+		cs.OIDC.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	}
+	if os.Getenv("OIDC_REDIRECT_URL") != "" {
+		cs.OIDC.RedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	}
+	if os.Getenv("LOG_LEVEL") != "" {
+		cs.Logging.Level = os.Getenv("LOG_LEVEL")
+	}
+	if os.Getenv("LOG_SINKS") != "" {
+		cs.Logging.Sinks = strings.Split(os.Getenv("LOG_SINKS"), ",")
+	}
+	if os.Getenv("TRACE_EXPORTER") != "" {
+		cs.Tracing.Exporter = os.Getenv("TRACE_EXPORTER")
+	}
+	if os.Getenv("TRACE_ENDPOINT") != "" {
+		cs.Tracing.Endpoint = os.Getenv("TRACE_ENDPOINT")
+	}
+	if os.Getenv("TRACE_FILE") != "" {
+		cs.Tracing.File = os.Getenv("TRACE_FILE")
+	}
+	if os.Getenv("TRUSTED_PROXIES") != "" {
+		cs.TrustedProxies = strings.Split(os.Getenv("TRUSTED_PROXIES"), ",")
+	}
 	if cs.CouchDb.URL == "" {
 		cs.CouchDb.URL = "localhost"
 	}
@@ -62,6 +295,69 @@ func (cs *Config) Defaults() {
 	if cs.CouchDb.Fts == "" {
 		cs.CouchDb.Fts = "fd_food"
 	}
+	if cs.JWT.Algorithm == "" {
+		cs.JWT.Algorithm = "HS256"
+	}
+	if cs.JWT.Timeout == 0 {
+		cs.JWT.Timeout = time.Hour
+	}
+	if cs.JWT.MaxRefresh == 0 {
+		cs.JWT.MaxRefresh = time.Hour
+	}
+	if cs.Mail.Sender == "" {
+		cs.Mail.Sender = "log"
+	}
+	if cs.Mail.Port == 0 {
+		cs.Mail.Port = 587
+	}
+	if cs.Passwords.MinLength == 0 {
+		cs.Passwords.MinLength = 10
+	}
+	if cs.Passwords.Classes == 0 {
+		cs.Passwords.Classes = 2
+	}
+	if cs.Passwords.Cost == 0 {
+		cs.Passwords.Cost = 14
+	}
+	if cs.Lockout.Attempts == nil {
+		n := 5
+		cs.Lockout.Attempts = &n
+	}
+	if cs.Lockout.Duration == 0 {
+		cs.Lockout.Duration = time.Minute
+	}
+	if cs.Lockout.MaxDuration == 0 {
+		cs.Lockout.MaxDuration = time.Hour
+	}
+	if len(cs.OIDC.Scopes) == 0 {
+		cs.OIDC.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cs.OIDC.UsernameClaim == "" {
+		cs.OIDC.UsernameClaim = "preferred_username"
+	}
+	if cs.Logging.Level == "" {
+		cs.Logging.Level = "info"
+	}
+	if cs.Tracing.Service == "" {
+		cs.Tracing.Service = "fdc-api"
+	}
+	if cs.Tracing.Sample == 0 {
+		cs.Tracing.Sample = 1
+	}
+	if cs.RateLimits == nil {
+		cs.RateLimits = make(map[string]RateLimit)
+	}
+	for g, l := range defaultRateLimits {
+		if _, ok := cs.RateLimits[g]; !ok {
+			cs.RateLimits[g] = l
+		}
+	}
+	if cs.APIKeys.Header == "" {
+		cs.APIKeys.Header = "X-Api-Key"
+	}
+	if cs.APIKeys.Query == "" {
+		cs.APIKeys.Query = "api_key"
+	}
 }
 
 // GetConfig reads config from a file
@@ -0,0 +1,63 @@
+package fdc
+
+import "time"
+
+// BREAKFAST etc are the meals of diary entries
+const (
+	BREAKFAST = "breakfast"
+	LUNCH     = "lunch"
+	DINNER    = "dinner"
+	SNACK     = "snack"
+)
+
+// DiaryEntryRequest records an amount of a food eaten by the current user.
+// Amount is a number of the named serving or grams if Serving is empty.
+type DiaryEntryRequest struct {
+	FdcID   string    `json:"fdcId" binding:"required"`
+	Serving string    `json:"serving,omitempty"`
+	Amount  float64   `json:"amount" binding:"required"`
+	Time    time.Time `json:"timestamp"`
+	Meal    string    `json:"meal,omitempty"`
+}
+
+// DiaryEntry is a DIARY document with the nutrients in the amount eaten
+type DiaryEntry struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Owner       string          `json:"owner"`
+	FdcID       string          `json:"fdcId"`
+	Description string          `json:"foodDescription"`
+	Serving     string          `json:"serving,omitempty"`
+	Amount      float64         `json:"amount"`
+	Grams       float64         `json:"grams"`
+	Time        time.Time       `json:"timestamp"`
+	Meal        string          `json:"meal,omitempty"`
+	Nutrients   []DiaryNutrient `json:"nutrients"`
+}
+
+// DiaryNutrient is an amount of a nutrient eaten.  DailyValue and
+// PercentDailyValue are set in daily totals for nutrients with a Daily Value.
+type DiaryNutrient struct {
+	Nutrientno        int     `json:"nutrientNumber"`
+	Name              string  `json:"nutrientName"`
+	Unit              string  `json:"unit"`
+	Value             float64 `json:"value"`
+	DailyValue        float64 `json:"dailyValue,omitempty"`
+	PercentDailyValue float64 `json:"percentDailyValue,omitempty"`
+}
+
+// DiaryDay is a day's diary entries with their nutrient totals
+type DiaryDay struct {
+	Date      string          `json:"date"`
+	Entries   []DiaryEntry    `json:"entries"`
+	Nutrients []DiaryNutrient `json:"nutrients"`
+}
+
+// DiaryReport is the diary of a user between two dates compared against a
+// Daily Value profile
+type DiaryReport struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	Profile string     `json:"profile"`
+	Days    []DiaryDay `json:"days"`
+}
@@ -17,8 +17,42 @@ const (
 	PHRASE   = "PHRASE"
 	WILDCARD = "WILDCARD"
 	REGEX    = "REGEX"
+	FUZZY    = "FUZZY"
+	PREFIX   = "PREFIX"
+	COMPOUND = "COMPOUND"
 )
 
+// MUST etc define how a clause of a compound search must occur in results
+const (
+	MUST    = "MUST"
+	SHOULD  = "SHOULD"
+	MUSTNOT = "MUSTNOT"
+)
+
+// DESCRIPTION etc define food fields used for suggestions and compound searches
+const (
+	DESCRIPTION = "foodDescription"
+	COMPANY     = "company"
+	INGREDIENTS = "ingredients"
+	UPC         = "upc"
+)
+
+// FOODGROUP etc define the names of search facets
+const (
+	FOODGROUP = "foodGroup"
+	SOURCE    = "dataSource"
+	COUNTRY   = "marketCountry"
+	NUTRIENT  = "nutrient"
+)
+
+// FacetFields maps term facet names to food document fields
+var FacetFields = map[string]string{
+	FOODGROUP: "foodGroup.description",
+	COMPANY:   "company",
+	SOURCE:    "dataSource",
+	COUNTRY:   "marketCountry",
+}
+
 // SR is standard reference
 const (
 	SR DocType = iota
@@ -33,6 +67,16 @@ const (
 	FOOD
 	USER
 	NUTDATA
+	CUSTOM
+	RECIPE
+	DIARY
+	LIST
+	APIKEY
+	ROLE
+	RESET
+	OIDCSTATE
+	AUDIT
+	FAILURES
 )
 
 //ToDocType -- convert a string to a DocType
@@ -62,6 +106,26 @@ func (dt *DocType) ToDocType(t string) DocType {
 		return FOOD
 	case "USER":
 		return USER
+	case "CUSTOM":
+		return CUSTOM
+	case "RECIPE":
+		return RECIPE
+	case "DIARY":
+		return DIARY
+	case "LIST":
+		return LIST
+	case "APIKEY":
+		return APIKEY
+	case "ROLE":
+		return ROLE
+	case "RESET":
+		return RESET
+	case "OIDCSTATE":
+		return OIDCSTATE
+	case "AUDIT":
+		return AUDIT
+	case "FAILURES":
+		return FAILURES
 	default:
 		return 999
 	}
@@ -94,6 +158,26 @@ func (dt *DocType) ToString(t DocType) string {
 		return "FOOD"
 	case USER:
 		return "USER"
+	case CUSTOM:
+		return "CUSTOM"
+	case RECIPE:
+		return "RECIPE"
+	case DIARY:
+		return "DIARY"
+	case LIST:
+		return "LIST"
+	case APIKEY:
+		return "APIKEY"
+	case ROLE:
+		return "ROLE"
+	case RESET:
+		return "RESET"
+	case OIDCSTATE:
+		return "OIDCSTATE"
+	case AUDIT:
+		return "AUDIT"
+	case FAILURES:
+		return "FAILURES"
 	default:
 		return ""
 	}
@@ -38,6 +38,19 @@ type Food struct {
 	Type            string      `json:"type" binding:"required"`
 	Country         string      `json:"marketCountry,omitempty"`
 	InputFoods      []InputFood `json:"inputfoods,omitempty"`
+	// IngredientTokens are the normalized ingredients parsed from Ingredients
+	IngredientTokens []string `json:"ingredientTokens,omitempty"`
+	// Allergens and MayContain are derived from Ingredients and are null
+	// for foods which have not been checked
+	Allergens  []string `json:"allergens"`
+	MayContain []string `json:"mayContain"`
+	// Diets lists the dietary classifications the food satisfies and is
+	// null for foods which have not been classified
+	Diets []string `json:"diets"`
+	// Gtin is Upc normalized to a GTIN-14 and is the key for barcode look-ups
+	Gtin string `json:"gtin,omitempty"`
+	// Owner is the name of the user who created a CUSTOM food or RECIPE
+	Owner string `json:"owner,omitempty"`
 }
 
 // InputFood describes an FNDDS Input Food
@@ -50,6 +63,7 @@ type InputFood struct {
 	Portion            string  `json:"portion,omitempty"`
 	PortionDescription string  `json:"portionDescription,omitempty"`
 	Weight             float32 `json:"weight"`
+	FdcID              string  `json:"fdcId,omitempty"`
 }
 
 // Serving describes a list nutrients for a given state, weight and amount
@@ -104,6 +118,7 @@ type NutrientData struct {
 	Datapoints   int         `json:"datapoints,omitempty"`
 	Min          float32     `json:"min,omitempty"`
 	Max          float32     `json:"max,omitempty"`
+	Owner        string      `json:"owner,omitempty"`
 }
 
 // FoodGroup is the dictionary of FNDDS and SR food groups
@@ -0,0 +1,96 @@
+package fdc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FDCID, NDBNO and FOODCODE are the prefixes of food identifiers along with
+// UPC, e.g. ndb:01001 or fndds:11111000
+const (
+	FDCID    = "fdc"
+	NDBNO    = "ndb"
+	FOODCODE = "fndds"
+)
+
+var (
+	digits = regexp.MustCompile(`^[0-9]+$`)
+	// barcode matches unprefixed ids which are GTIN/UPC codes rather than
+	// fdcIds, allowing for the spaces and dashes scanners sometimes add
+	barcode = regexp.MustCompile(`^[0-9][0-9 -]{6,}[0-9]$`)
+	// customKey matches the keys given to custom foods and recipes
+	customKey = regexp.MustCompile(`^c[0-9a-f]{16}$`)
+)
+
+// FoodID is a food identifier in one of the FDCID, NDBNO, FOODCODE or UPC
+// schemes
+type FoodID struct {
+	Scheme string `json:"scheme"`
+	Value  string `json:"value"`
+}
+
+// ParseFoodID splits a prefixed identifier into its scheme and value.  Ids
+// without a prefix are UPCs if they look like barcodes and fdcIds if they are
+// numeric or the key of a custom food; anything else is an error.  SR NDB
+// numbers are padded to 5 digits.
+func ParseFoodID(id string) (FoodID, error) {
+	id = strings.TrimSpace(id)
+	i := strings.Index(id, ":")
+	if i < 0 {
+		if barcode.MatchString(id) {
+			return FoodID{UPC, id}, nil
+		}
+		if !digits.MatchString(id) && !customKey.MatchString(id) {
+			return FoodID{}, fmt.Errorf("%s is not a valid fdcId or GTIN/UPC", id)
+		}
+		return FoodID{FDCID, id}, nil
+	}
+	f := FoodID{strings.ToLower(id[:i]), strings.TrimSpace(id[i+1:])}
+	switch f.Scheme {
+	case FDCID:
+		if !digits.MatchString(f.Value) {
+			return f, fmt.Errorf("%s is not a valid fdcId", id)
+		}
+	case NDBNO:
+		if !digits.MatchString(f.Value) || len(f.Value) > 5 {
+			return f, fmt.Errorf("%s is not a valid SR NDB number", id)
+		}
+		f.Value = strings.Repeat("0", 5-len(f.Value)) + f.Value
+	case FOODCODE:
+		if !digits.MatchString(f.Value) || len(f.Value) != 8 {
+			return f, fmt.Errorf("%s is not a valid 8 digit FNDDS food code", id)
+		}
+	case UPC:
+		if f.Value == "" {
+			return f, fmt.Errorf("%s requires a GTIN/UPC code", id)
+		}
+	default:
+		return f, fmt.Errorf("unrecognized id prefix in %s, use one of %s, %s, %s or %s", id, FDCID, NDBNO, FOODCODE, UPC)
+	}
+	return f, nil
+}
+
+// NdbForms returns the forms in which an SR NDB number may be stored, with
+// and without leading zeros
+func NdbForms(ndb string) []string {
+	forms := []string{ndb}
+	if t := strings.TrimLeft(ndb, "0"); t != ndb && t != "" {
+		forms = append(forms, t)
+	}
+	return forms
+}
+
+// Crosswalk maps a food's fdcId to its SR NDB number, FNDDS food code and
+// GTIN.  Related lists the SR foods which are ingredients of an FNDDS food or
+// the FNDDS foods which use an SR food.
+type Crosswalk struct {
+	ID          string      `json:"id"`
+	FdcID       string      `json:"fdcId"`
+	Source      string      `json:"dataSource"`
+	Description string      `json:"foodDescription"`
+	NdbNo       string      `json:"ndbno,omitempty"`
+	FoodCode    string      `json:"foodCode,omitempty"`
+	Gtin        string      `json:"gtin,omitempty"`
+	Related     []Crosswalk `json:"related,omitempty"`
+}
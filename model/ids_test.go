@@ -0,0 +1,38 @@
+package fdc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFoodID(t *testing.T) {
+	for id, want := range map[string]FoodID{
+		"389714":            {FDCID, "389714"},
+		"fdc:389714":        {FDCID, "389714"},
+		"ndb:01001":         {NDBNO, "01001"},
+		"NDB:1001":          {NDBNO, "01001"},
+		"fndds:11111000":    {FOODCODE, "11111000"},
+		"upc:042222850325":  {UPC, "042222850325"},
+		"042222850325":      {UPC, "042222850325"},
+		"0-42222-85032-5":   {UPC, "0-42222-85032-5"},
+		"c5f0e2a9b1c3d4e5f": {FDCID, "c5f0e2a9b1c3d4e5f"},
+	} {
+		if got, err := ParseFoodID(id); err != nil || got != want {
+			t.Errorf("ParseFoodID(%s) = %v %v, want %v", id, got, err, want)
+		}
+	}
+	for _, id := range []string{"fdc:abc", "ndb:123456", "fndds:1111", "upc:", "sr:01001", "", "abc", "389714\" OR 1=1", "cself", "01001 OR"} {
+		if _, err := ParseFoodID(id); err == nil {
+			t.Errorf("Expecting an error for %s", id)
+		}
+	}
+}
+
+func TestNdbForms(t *testing.T) {
+	if f := NdbForms("01001"); !reflect.DeepEqual(f, []string{"01001", "1001"}) {
+		t.Errorf("Unexpected forms %v", f)
+	}
+	if f := NdbForms("11001"); !reflect.DeepEqual(f, []string{"11001"}) {
+		t.Errorf("Unexpected forms %v", f)
+	}
+}
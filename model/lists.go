@@ -0,0 +1,42 @@
+package fdc
+
+import "time"
+
+// EVERYONE shares a food list with anyone who has its id
+const EVERYONE = "*"
+
+// FoodList is a LIST document holding a user's named, ordered list of foods.
+// SharedWith names the users who may read the list or is EVERYONE.
+type FoodList struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	Owner       string     `json:"owner"`
+	Name        string     `json:"name" binding:"required"`
+	Description string     `json:"description,omitempty"`
+	SharedWith  []string   `json:"sharedWith,omitempty"`
+	Items       []ListItem `json:"items"`
+	UpdatedAt   time.Time  `json:"lastChangeDateTime"`
+}
+
+// ListItem is a food in a list
+type ListItem struct {
+	FdcID        string `json:"fdcId"`
+	Upc          string `json:"upc,omitempty"`
+	Description  string `json:"foodDescription"`
+	Manufacturer string `json:"company,omitempty"`
+}
+
+// FoodListRequest creates a list or changes its name, description or
+// sharing.  Items may be given when a list is created.
+type FoodListRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description,omitempty"`
+	SharedWith  []string `json:"sharedWith,omitempty"`
+	Items       []string `json:"items,omitempty"`
+}
+
+// ListItemsRequest lists fdcIds or UPCs to add to a list or the fdcIds of a
+// list in their new order
+type ListItemsRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
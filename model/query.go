@@ -0,0 +1,180 @@
+package fdc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// QueryFields maps the field names accepted in compound queries to food fields
+var QueryFields = map[string]string{
+	"description":   DESCRIPTION,
+	DESCRIPTION:     DESCRIPTION,
+	COMPANY:         COMPANY,
+	"manufacturer":  COMPANY,
+	INGREDIENTS:     INGREDIENTS,
+	"ingredient":    INGREDIENTS,
+	UPC:             UPC,
+	"gtin":          UPC,
+	"foodgroup":     "foodGroup.description",
+	"foodGroup":     "foodGroup.description",
+	"source":        SOURCE,
+	SOURCE:          SOURCE,
+	"marketcountry": COUNTRY,
+}
+
+// Quote returns s as a N1QL string literal.  Every value placed in a N1QL
+// statement is quoted with it so quotes and backslashes can't end the literal.
+func Quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// QuoteList returns values as a N1QL array of string literals
+func QuoteList(values []string) string {
+	q := make([]string, len(values))
+	for i, v := range values {
+		q[i] = Quote(v)
+	}
+	return "[" + strings.Join(q, ",") + "]"
+}
+
+// ParseQuery parses a compound query string into a list of SearchClauses.
+// Terms have the form field:value, field:"a phrase" or value and may end in
+// ^boost.  Terms preceded by NOT or - must not match, terms on either side of
+// an OR should match and all other terms, whether joined by AND or not, must
+// match.  Values containing * or ? are run as WILDCARD searches and quoted
+// values as PHRASE searches.  Parentheses are not supported.
+func ParseQuery(q string) ([]SearchClause, error) {
+	var (
+		clauses []SearchClause
+		or      []bool
+		not     bool
+		pending bool
+		op      bool
+	)
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		switch {
+		case t == "AND" || t == "&&":
+			if len(clauses) == 0 {
+				return nil, errors.New("AND must follow a search term")
+			}
+			op = true
+			continue
+		case t == "OR" || t == "||":
+			if len(clauses) == 0 {
+				return nil, errors.New("OR must follow a search term")
+			}
+			or[len(or)-1] = true
+			pending = true
+			op = true
+			continue
+		case t == "NOT" || t == "!":
+			not = true
+			op = true
+			continue
+		}
+		c, err := parseTerm(t)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(t, "-") {
+			not = true
+		}
+		if not {
+			c.Occur = MUSTNOT
+		}
+		clauses = append(clauses, c)
+		or = append(or, pending)
+		not = false
+		pending = false
+		op = false
+	}
+	if op {
+		return nil, errors.New("query cannot end with an operator")
+	}
+	if len(clauses) == 0 {
+		return nil, errors.New("query has no search terms")
+	}
+	for i := range clauses {
+		if clauses[i].Occur == "" {
+			if or[i] {
+				clauses[i].Occur = SHOULD
+			} else {
+				clauses[i].Occur = MUST
+			}
+		}
+	}
+	return clauses, nil
+}
+
+// parseTerm converts a single query term into a SearchClause without an Occur
+func parseTerm(t string) (SearchClause, error) {
+	var c SearchClause
+	t = strings.TrimLeft(t, "+-")
+	if i := strings.LastIndex(t, "^"); i > 0 && !strings.HasSuffix(t, "\"") {
+		b, err := strconv.ParseFloat(t[i+1:], 32)
+		if err != nil || b <= 0 {
+			return c, fmt.Errorf("invalid boost in %s", t)
+		}
+		c.Boost = float32(b)
+		t = t[:i]
+	}
+	if i := strings.Index(t, ":"); i > 0 && !strings.HasPrefix(t, "\"") {
+		f, ok := QueryFields[t[:i]]
+		if !ok {
+			return c, fmt.Errorf("unrecognized field %s", t[:i])
+		}
+		c.Field = f
+		t = t[i+1:]
+	}
+	switch {
+	case len(t) > 1 && strings.HasPrefix(t, "\"") && strings.HasSuffix(t, "\""):
+		c.SearchType = PHRASE
+		t = t[1 : len(t)-1]
+	case strings.ContainsAny(t, "*?"):
+		c.SearchType = WILDCARD
+	}
+	if t == "" {
+		return c, errors.New("empty search term")
+	}
+	c.Query = t
+	return c, nil
+}
+
+// tokenize splits a query on white space except within double quotes
+func tokenize(q string) ([]string, error) {
+	var (
+		tokens []string
+		b      strings.Builder
+		quoted bool
+	)
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if b.Len() > 0 {
+				tokens = append(tokens, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote in query")
+	}
+	if b.Len() > 0 {
+		tokens = append(tokens, b.String())
+	}
+	return tokens, nil
+}
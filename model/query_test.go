@@ -0,0 +1,59 @@
+package fdc
+
+import "testing"
+
+func TestParseQuery(t *testing.T) {
+	c, err := ParseQuery("company:kellogg AND description:flakes NOT ingredients:sugar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SearchClause{
+		{Occur: MUST, Field: COMPANY, Query: "kellogg"},
+		{Occur: MUST, Field: DESCRIPTION, Query: "flakes"},
+		{Occur: MUSTNOT, Field: INGREDIENTS, Query: "sugar"},
+	}
+	if len(c) != len(want) {
+		t.Fatalf("Expecting %d clauses got %v", len(want), c)
+	}
+	for i := range want {
+		if c[i] != want[i] {
+			t.Errorf("Expecting clause %d to be %v got %v", i, want[i], c[i])
+		}
+	}
+	c, err = ParseQuery("description:\"corn flakes\"^2 OR upc:0001* -company:post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 3 || c[0].Occur != SHOULD || c[0].SearchType != PHRASE || c[0].Query != "corn flakes" || c[0].Boost != 2 {
+		t.Errorf("Expecting a boosted SHOULD phrase clause got %v", c)
+	}
+	if c[1].Occur != SHOULD || c[1].Field != UPC || c[1].SearchType != WILDCARD {
+		t.Errorf("Expecting a SHOULD wildcard upc clause got %v", c[1])
+	}
+	if c[2].Occur != MUSTNOT || c[2].Query != "post" {
+		t.Errorf("Expecting a MUSTNOT clause got %v", c[2])
+	}
+	for _, q := range []string{"", "OR flakes", "flakes AND", "color:red", "\"corn flakes", "flakes^x"} {
+		if _, err = ParseQuery(q); err == nil {
+			t.Errorf("Expecting an error parsing '%s'", q)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"kellogg":                 `"kellogg"`,
+		`bob" OR owner IS VALUED`: `"bob\" OR owner IS VALUED"`,
+		`trailing\`:               `"trailing\\"`,
+	} {
+		if got := Quote(in); got != want {
+			t.Errorf("Quote(%s) got %s want %s", in, got, want)
+		}
+	}
+	if got := QuoteList([]string{"a", `b"`}); got != `["a","b\""]` {
+		t.Errorf("QuoteList got %s", got)
+	}
+	if got := QuoteList(nil); got != "[]" {
+		t.Errorf("QuoteList(nil) got %s", got)
+	}
+}
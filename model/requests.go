@@ -2,10 +2,11 @@ package fdc
 
 // BrowseResult is returned from the browse endpoints
 type BrowseResult struct {
-	Count int32         `json:"count"`
-	Start int32         `json:"start"`
-	Max   int32         `json:"max"`
-	Items []interface{} `json:"items"`
+	Count  int32         `json:"count"`
+	Start  int32         `json:"start"`
+	Max    int32         `json:"max"`
+	Items  []interface{} `json:"items"`
+	Facets []Facet       `json:"facets,omitempty"`
 }
 
 // BrowseNutrientReport is returned from the nutrients report endpoing
@@ -36,11 +37,13 @@ type NutrientReportRequest struct {
 	Order     string  `json:"order,omitEmpty"`
 	ValueGTE  float64 `json:"valueGTE"`
 	ValueLTE  float64 `json:"valueLTE"`
+	// Owner includes the custom foods of a user and is set from the token
+	Owner string `json:"-"`
 }
 
 // SearchRequest wraps a POST search
 type SearchRequest struct {
-	Query       string `json:"q" binding:"required"`
+	Query       string `json:"q"`
 	SearchField string `json:"searchfield,omitEmpty"`
 	Page        int    `json:"page"`
 	Max         int    `json:"max"`
@@ -48,6 +51,90 @@ type SearchRequest struct {
 	SearchType  string `json:"searchtype,omitEmpty"`
 	FoodGroup   string `json:"foodgroup,omitEmpty"`
 	IndexName   string `json:"indexname"`
+	// Fuzziness is the maximum edit distance of a FUZZY search
+	Fuzziness int `json:"fuzziness,omitempty"`
+	// PrefixLength is the number of leading characters which must match
+	// exactly in a FUZZY search
+	PrefixLength int `json:"prefixLength,omitempty"`
+	// Facets lists the facets to count for the foods matching the query
+	Facets []FacetRequest `json:"facets,omitempty"`
+	// Filters restricts results to foods having one of the listed values for
+	// each named facet, e.g. {"company":["KELLOGG COMPANY US","GENERAL MILLS"]}
+	Filters map[string][]string `json:"filters,omitempty"`
+	// NutrientFilters restricts results to foods having a nutrient value within
+	// one of the listed ranges
+	NutrientFilters []NutrientFilter `json:"nutrientFilters,omitempty"`
+	// Include restricts results to foods containing all of the listed
+	// ingredients and Exclude to foods containing none of them
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// AllergenFree restricts results to foods which neither contain nor may
+	// contain any of the listed allergens
+	AllergenFree []string `json:"allergenFree,omitempty"`
+	// Diets restricts results to foods satisfying all of the listed dietary
+	// classifications, e.g. ["vegan","low-sodium"]
+	Diets []string `json:"diets,omitempty"`
+	// Clauses combines searches of individual fields.  A COMPOUND search
+	// parses its clauses from Query.
+	Clauses []SearchClause `json:"clauses,omitempty"`
+	// Boosts weights the relevance of matches by field for clauses which
+	// don't specify a boost, e.g. {"foodDescription":3}
+	Boosts map[string]float32 `json:"boosts,omitempty"`
+	// Owner includes the custom foods of a user and is set from the token
+	Owner string `json:"-"`
+}
+
+// SearchClause is a search of a single field within a compound search.  Occur
+// is one of MUST, SHOULD or MUSTNOT and an empty Field searches all fields.
+type SearchClause struct {
+	Occur      string  `json:"occur"`
+	Field      string  `json:"field,omitempty"`
+	Query      string  `json:"q"`
+	SearchType string  `json:"searchtype,omitempty"`
+	Boost      float32 `json:"boost,omitempty"`
+}
+
+// FacetRequest names a facet to be returned with search results.  Nutrient
+// facets count foods by ranges of values per 100 units for a nutrient.
+type FacetRequest struct {
+	Name     string         `json:"name" binding:"required"`
+	Size     int            `json:"size"`
+	Nutrient int            `json:"nutrientno,omitempty"`
+	Ranges   []NumericRange `json:"ranges,omitempty"`
+}
+
+// NumericRange is a named range of values.  Min is inclusive and Max is
+// exclusive, either may be omitted for an open ended range.
+type NumericRange struct {
+	Name string   `json:"name"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+// NutrientFilter restricts search results on the value per 100 units of a
+// nutrient.  A food matches if its value falls within any of the ranges.
+type NutrientFilter struct {
+	Nutrient int            `json:"nutrientno" binding:"required"`
+	Ranges   []NumericRange `json:"ranges" binding:"required"`
+}
+
+// Facet is returned with search results and holds the buckets for a
+// FacetRequest
+type Facet struct {
+	Name    string        `json:"name"`
+	Field   string        `json:"field"`
+	Total   int           `json:"total"`
+	Missing int           `json:"missing,omitempty"`
+	Other   int           `json:"other,omitempty"`
+	Buckets []FacetBucket `json:"buckets"`
+}
+
+// FacetBucket is a facet term or range and the count of foods in it
+type FacetBucket struct {
+	Term  string   `json:"term"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Count int      `json:"count"`
 }
 
 // SearchResult is returned from the search endpoints
@@ -101,3 +188,93 @@ type NutrientReportData struct {
 	Unit            string  `json:"unit"`
 	Type            string  `json:"type"`
 }
+
+// SuggestRequest wraps a typeahead request
+type SuggestRequest struct {
+	Query     string `json:"q" binding:"required"`
+	Field     string `json:"field,omitempty"`
+	Max       int    `json:"max"`
+	IndexName string `json:"indexname"`
+}
+
+// Suggestion is a single completion returned by the suggest endpoint
+type Suggestion struct {
+	Text  string  `json:"text"`
+	Field string  `json:"field"`
+	Score float64 `json:"score"`
+}
+
+// SuggestResult is returned from the suggest endpoint
+type SuggestResult struct {
+	Query string       `json:"q"`
+	Count int32        `json:"count"`
+	Items []Suggestion `json:"items"`
+}
+
+// AllergenReport is returned from the allergens endpoint and explains the
+// allergen flags derived for a food
+type AllergenReport struct {
+	FdcID       string             `json:"fdcId"`
+	Description string             `json:"foodDescription"`
+	Allergens   []string           `json:"allergens"`
+	MayContain  []string           `json:"mayContain"`
+	Evidence    []AllergenEvidence `json:"evidence"`
+}
+
+// AllergenEvidence is an ingredient token or labeling statement which
+// triggered an allergen flag.  Source is one of INGREDIENT, CONTAINS or
+// MAYCONTAIN.
+type AllergenEvidence struct {
+	Allergen string `json:"allergen"`
+	Source   string `json:"source"`
+	Text     string `json:"text"`
+	Keyword  string `json:"keyword"`
+}
+
+// FoodEditRequest curates a FOOD.  Only the fields which are present are
+// changed.
+type FoodEditRequest struct {
+	Description  *string `json:"foodDescription"`
+	Ingredients  *string `json:"ingredients"`
+	Manufacturer *string `json:"company"`
+	Upc          *string `json:"upc"`
+}
+
+// CustomFoodRequest creates or replaces a user's CUSTOM food or RECIPE.
+// Custom foods give their nutrient values per 100 g.  Recipes list the foods
+// they are made from whose nutrient values are combined.
+type CustomFoodRequest struct {
+	Description string           `json:"foodDescription" binding:"required"`
+	Ingredients string           `json:"ingredients,omitempty"`
+	Servings    []Serving        `json:"servingSizes,omitempty"`
+	Nutrients   []CustomNutrient `json:"nutrients,omitempty"`
+	Items       []RecipeItem     `json:"items,omitempty"`
+}
+
+// CustomNutrient is the value of a nutrient per 100 g of a custom food
+type CustomNutrient struct {
+	Nutrientno int     `json:"nutrientNumber" binding:"required"`
+	Value      float64 `json:"valuePer100UnitServing"`
+}
+
+// RecipeItem is the weight in grams of a food used in a recipe
+type RecipeItem struct {
+	FdcID string  `json:"fdcId" binding:"required"`
+	Grams float64 `json:"grams" binding:"required"`
+}
+
+// Check is the outcome of one of the checks made by a datastore's Ping.
+// Status is ok or fail.
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Latency string `json:"latency,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Readiness is returned from the readyz endpoint.  Status is ready only when
+// every check is ok.
+type Readiness struct {
+	Status string  `json:"status"`
+	Checks []Check `json:"checks"`
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often a MemoryStore drops buckets of idle clients
+const sweepEvery = time.Minute
+
+// MemoryStore keeps buckets in the memory of a single server
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+	day    time.Time
+	used   int
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Take takes a token from the bucket for key and counts the request against
+// the daily quota.  Rejected requests take nothing and neither do requests
+// without a rate, whose buckets never refill.  Buckets hold at least one
+// token.
+func (m *MemoryStore) Take(key string, l Limit, now time.Time) (Result, error) {
+	if l.Rate <= 0 && l.Daily <= 0 {
+		return Result{Allowed: true}, nil
+	}
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.swept) > sweepEvery {
+		m.sweep(now)
+	}
+	day := now.UTC().Truncate(24 * time.Hour)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now, day: day}
+		m.buckets[key] = b
+	}
+	if b.day != day {
+		b.day, b.used = day, 0
+	}
+	r := Result{Allowed: true}
+	if l.Rate > 0 {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+		b.last = now
+		r.Limit = l.Burst
+		if b.tokens < 1 {
+			r.Allowed = false
+			r.RetryAfter = time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+		}
+	}
+	if l.Daily > 0 {
+		r.Quota = l.Daily
+		r.QuotaReset = day.Add(24 * time.Hour)
+		if b.used >= l.Daily {
+			r.Allowed = false
+			if wait := r.QuotaReset.Sub(now); wait > r.RetryAfter {
+				r.RetryAfter = wait
+			}
+		}
+	}
+	if r.Allowed {
+		if l.Rate > 0 {
+			b.tokens--
+		}
+		if l.Daily > 0 {
+			b.used++
+		}
+	}
+	if l.Rate > 0 {
+		r.Remaining = int(math.Max(0, math.Floor(b.tokens)))
+		r.Reset = now.Add(time.Duration((float64(l.Burst) - b.tokens) / l.Rate * float64(time.Second)))
+		b.full = r.Reset
+	}
+	if l.Daily > 0 {
+		r.QuotaRemaining = l.Daily - b.used
+	}
+	return r, nil
+}
+
+// sweep drops the buckets which are full and have no quota used today
+func (m *MemoryStore) sweep(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	for k, b := range m.buckets {
+		if now.After(b.full) && (b.day != day || b.used == 0) {
+			delete(m.buckets, k)
+		}
+	}
+	m.swept = now
+}
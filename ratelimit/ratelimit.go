@@ -0,0 +1,87 @@
+// Package ratelimit throttles clients with token buckets and daily quotas.
+// Buckets are kept in a Store so limits can be shared between servers; an
+// in-memory store is provided for a single server.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/logging"
+)
+
+// Limit allows Burst requests at once refilled at Rate requests a second
+// and at most Daily requests each UTC day.  A zero Rate or Daily turns off
+// that limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+	Daily int
+}
+
+// Result is the outcome of taking a token from a client's bucket
+type Result struct {
+	Allowed        bool
+	Limit          int
+	Remaining      int
+	Reset          time.Time // when the bucket is full again
+	RetryAfter     time.Duration
+	Quota          int
+	QuotaRemaining int
+	QuotaReset     time.Time
+}
+
+// Store takes tokens from the buckets of clients.  Implementations shared
+// between servers must make Take atomic for a key.
+type Store interface {
+	Take(key string, l Limit, now time.Time) (Result, error)
+}
+
+// KeyFunc identifies the client which made a request
+type KeyFunc func(c *gin.Context) string
+
+// Middleware limits the clients identified by key to l on the routes of the
+// named group.  Rejected requests get a 429 and a Retry-After header.  If
+// the store fails requests are let through.
+func Middleware(s Store, group string, l Limit, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.Rate <= 0 && l.Daily <= 0 {
+			c.Next()
+			return
+		}
+		r, err := s.Take(fmt.Sprintf("%s:%s", group, key(c)), l, time.Now())
+		if err != nil {
+			logging.From(c).Error("rate limit store error", logging.Fields{"group": group, "error": err})
+			c.Next()
+			return
+		}
+		Headers(c.Writer.Header(), r)
+		if !r.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(r.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"status":  http.StatusTooManyRequests,
+				"message": "Rate limit exceeded",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Headers sets the X-RateLimit headers which describe r
+func Headers(h http.Header, r Result) {
+	if r.Limit > 0 {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(r.Reset.Unix(), 10))
+	}
+	if r.Quota > 0 {
+		h.Set("X-RateLimit-Quota-Limit", strconv.Itoa(r.Quota))
+		h.Set("X-RateLimit-Quota-Remaining", strconv.Itoa(r.QuotaRemaining))
+		h.Set("X-RateLimit-Quota-Reset", strconv.FormatInt(r.QuotaReset.Unix(), 10))
+	}
+}
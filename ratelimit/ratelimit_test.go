@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryStoreBucket(t *testing.T) {
+	s := NewMemoryStore()
+	l := Limit{Rate: 1, Burst: 2}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		r, _ := s.Take("a", l, now)
+		if r.Allowed != want {
+			t.Fatalf("request %d: got allowed %v", i, r.Allowed)
+		}
+		if !r.Allowed && r.RetryAfter != time.Second {
+			t.Errorf("got retry after %v", r.RetryAfter)
+		}
+	}
+	if r, _ := s.Take("b", l, now); !r.Allowed || r.Remaining != 1 {
+		t.Errorf("client b shares a's bucket: %+v", r)
+	}
+	r, _ := s.Take("a", l, now.Add(1500*time.Millisecond))
+	if !r.Allowed || r.Remaining != 0 {
+		t.Errorf("bucket didn't refill: %+v", r)
+	}
+	if !r.Reset.Equal(now.Add(3 * time.Second)) {
+		t.Errorf("got reset %v", r.Reset)
+	}
+}
+
+func TestMemoryStoreQuota(t *testing.T) {
+	s := NewMemoryStore()
+	l := Limit{Daily: 2}
+	now := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		r, _ := s.Take("a", l, now)
+		if r.Allowed != want {
+			t.Fatalf("request %d: got allowed %v", i, r.Allowed)
+		}
+		if !r.Allowed && r.RetryAfter != time.Hour {
+			t.Errorf("got retry after %v", r.RetryAfter)
+		}
+	}
+	r, _ := s.Take("a", l, now.Add(time.Hour))
+	if !r.Allowed || r.QuotaRemaining != 1 {
+		t.Errorf("quota didn't reset at midnight: %+v", r)
+	}
+}
+
+func TestMemoryStoreZeroRate(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if r, _ := s.Take("a", Limit{Burst: 1}, now); !r.Allowed {
+			t.Fatalf("request %d without a rate was rejected", i)
+		}
+	}
+	if len(s.buckets) != 0 {
+		t.Errorf("kept %d buckets for a limit without a rate or quota", len(s.buckets))
+	}
+	l := Limit{Burst: 1, Daily: 5}
+	for i := 0; i < 3; i++ {
+		if r, _ := s.Take("a", l, now); !r.Allowed || r.QuotaRemaining != 4-i {
+			t.Fatalf("request %d: got %+v", i, r)
+		}
+	}
+	if b := s.buckets["a"]; b.tokens != 1 {
+		t.Errorf("requests without a rate took tokens, %v left", b.tokens)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+	s.Take("a", Limit{Rate: 10, Burst: 1}, now)
+	s.Take("b", Limit{Daily: 5}, now)
+	s.sweep(now.Add(time.Second))
+	if _, ok := s.buckets["a"]; ok {
+		t.Error("full bucket wasn't swept")
+	}
+	if _, ok := s.buckets["b"]; !ok {
+		t.Error("bucket with quota used today was swept")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", Middleware(NewMemoryStore(), "read", Limit{Rate: 0.5, Burst: 1, Daily: 10}, func(c *gin.Context) string {
+		return c.ClientIP()
+	}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	for _, h := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-RateLimit-Quota-Remaining"} {
+		if w.Header().Get(h) == "" {
+			t.Errorf("no %s header", h)
+		}
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "2" {
+		t.Errorf("got Retry-After %s", ra)
+	}
+}
@@ -0,0 +1,209 @@
+// Package recipe expands FNDDS survey foods into their input foods and
+// computes the amount of each nutrient every input food contributes.  Input
+// foods which are themselves FNDDS recipes are expanded recursively.
+package recipe
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// MaxDepth limits how deeply recipes within recipes are expanded
+const MaxDepth = 10
+
+// Source looks up the foods and nutrient values a recipe expands into
+type Source interface {
+	// Food returns the food with an SR NDB number or FNDDS food code or nil
+	// if there is none
+	Food(id fdc.FoodID) (*fdc.Food, error)
+	// Nutrients returns the nutrient values per 100 units of a food
+	Nutrients(fdcID string) ([]fdc.NutrientData, error)
+}
+
+// Node is a food in an expanded recipe.  Nutrients are the amounts in Weight
+// grams of the food with the percentage they contribute to the amount in the
+// enclosing recipe.  Cycle marks an input food which contains a recipe it is
+// part of and Unresolved one which could not be found; neither contributes
+// nutrients.
+type Node struct {
+	Code        int      `json:"srcode,omitempty"`
+	FdcID       string   `json:"fdcId,omitempty"`
+	Description string   `json:"foodDescription"`
+	Source      string   `json:"dataSource,omitempty"`
+	Amount      float32  `json:"amount,omitempty"`
+	Unit        string   `json:"unit,omitempty"`
+	Weight      float64  `json:"weight"`
+	Nutrients   []Amount `json:"nutrients"`
+	Ingredients []Node   `json:"ingredients,omitempty"`
+	Cycle       bool     `json:"cycle,omitempty"`
+	Unresolved  bool     `json:"unresolved,omitempty"`
+}
+
+// Amount is the quantity of a nutrient
+type Amount struct {
+	Nutrientno int     `json:"nutrientNumber"`
+	Name       string  `json:"nutrientName"`
+	Unit       string  `json:"unit"`
+	Value      float64 `json:"value"`
+	Percent    float64 `json:"percentOfTotal,omitempty"`
+}
+
+// Expansion is a survey food expanded into its input foods.  Reported are
+// the food's own nutrient values for the same weight as the recipe so they
+// can be compared with the totals computed from the input foods.
+type Expansion struct {
+	Node
+	Reported []Amount `json:"reported"`
+}
+
+// FoodID returns the id of an input food's code, which is an 8 digit FNDDS
+// food code for recipes and an SR NDB number otherwise
+func FoodID(code int) fdc.FoodID {
+	if code >= 10000000 {
+		return fdc.FoodID{Scheme: fdc.FOODCODE, Value: fmt.Sprintf("%d", code)}
+	}
+	return fdc.FoodID{Scheme: fdc.NDBNO, Value: fmt.Sprintf("%05d", code)}
+}
+
+// Expand returns a food's input foods with their nutrient contributions for
+// the weight of the whole recipe.  Only the nutrients numbered in nutrients
+// are included unless it is empty.
+func Expand(f *fdc.Food, src Source, nutrients []int) (Expansion, error) {
+	if len(f.InputFoods) == 0 {
+		return Expansion{}, fmt.Errorf("food %s has no input foods", f.FdcID)
+	}
+	e := expander{src: src, only: make(map[int]bool)}
+	for _, n := range nutrients {
+		e.only[n] = true
+	}
+	var (
+		x   Expansion
+		err error
+	)
+	if x.Node, err = e.expand(f, recipeWeight(f), map[string]bool{}, 0); err != nil {
+		return x, err
+	}
+	per100, err := e.nutrients(f.FdcID)
+	if err != nil {
+		return x, err
+	}
+	x.Reported = scale(per100, x.Weight)
+	return x, nil
+}
+
+type expander struct {
+	src  Source
+	only map[int]bool
+}
+
+// expand returns the nutrients in weight grams of a food, summing those of
+// its input foods if it is a recipe.  path holds the recipes being expanded.
+func (e expander) expand(f *fdc.Food, weight float64, path map[string]bool, depth int) (Node, error) {
+	n := Node{FdcID: f.FdcID, Description: f.Description, Source: f.Source, Weight: weight}
+	if len(f.InputFoods) == 0 || depth >= MaxDepth {
+		per100, err := e.nutrients(f.FdcID)
+		n.Nutrients = scale(per100, weight)
+		return n, err
+	}
+	path[f.FdcID] = true
+	defer delete(path, f.FdcID)
+	total := recipeWeight(f)
+	sums := make(map[int]Amount)
+	for _, in := range f.InputFoods {
+		c := Node{Code: in.SrCode, Description: in.Description, Amount: in.Amount, Unit: in.Unit, Weight: float64(in.Weight)}
+		if total > 0 {
+			c.Weight = float64(in.Weight) * weight / total
+		}
+		var food *fdc.Food
+		var err error
+		if in.SrCode > 0 {
+			if food, err = e.src.Food(FoodID(in.SrCode)); err != nil {
+				return n, err
+			}
+		}
+		switch {
+		case food == nil:
+			c.Unresolved = true
+		case path[food.FdcID]:
+			c.FdcID, c.Source, c.Cycle = food.FdcID, food.Source, true
+		default:
+			sub, err := e.expand(food, c.Weight, path, depth+1)
+			if err != nil {
+				return n, err
+			}
+			sub.Code, sub.Description, sub.Amount, sub.Unit = c.Code, c.Description, c.Amount, c.Unit
+			c = sub
+		}
+		for _, a := range c.Nutrients {
+			s := sums[a.Nutrientno]
+			s.Nutrientno, s.Name, s.Unit = a.Nutrientno, a.Name, a.Unit
+			s.Value += a.Value
+			sums[a.Nutrientno] = s
+		}
+		n.Ingredients = append(n.Ingredients, c)
+	}
+	n.Nutrients = sorted(sums)
+	for i := range n.Ingredients {
+		for j, a := range n.Ingredients[i].Nutrients {
+			if t := sums[a.Nutrientno].Value; t > 0 {
+				n.Ingredients[i].Nutrients[j].Percent = round(100 * a.Value / t)
+			}
+		}
+	}
+	return n, nil
+}
+
+// nutrients returns the selected nutrient values per 100 units of a food
+func (e expander) nutrients(fdcID string) (map[int]Amount, error) {
+	nd, err := e.src.Nutrients(fdcID)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int]Amount)
+	for _, d := range nd {
+		no := int(d.Nutrientno)
+		if len(e.only) > 0 && !e.only[no] {
+			continue
+		}
+		m[no] = Amount{Nutrientno: no, Name: d.Nutrient, Unit: d.Unit, Value: d.Value}
+	}
+	return m, nil
+}
+
+// recipeWeight returns the total weight in grams of a recipe's input foods
+func recipeWeight(f *fdc.Food) float64 {
+	w := 0.0
+	for _, in := range f.InputFoods {
+		w += float64(in.Weight)
+	}
+	return w
+}
+
+// scale returns values per 100 units as amounts in weight grams
+func scale(per100 map[int]Amount, weight float64) []Amount {
+	m := make(map[int]Amount)
+	for k, a := range per100 {
+		a.Value = a.Value * weight / 100
+		m[k] = a
+	}
+	return sorted(m)
+}
+
+// sorted returns amounts ordered by nutrient number with values rounded
+func sorted(m map[int]Amount) []Amount {
+	a := []Amount{}
+	for _, v := range m {
+		v.Value = round(v.Value)
+		a = append(a, v)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Nutrientno < a[j].Nutrientno })
+	return a
+}
+
+// round rounds to 3 decimal places
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
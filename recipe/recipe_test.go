@@ -0,0 +1,89 @@
+package recipe
+
+import (
+	"testing"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+type source struct {
+	foods     map[fdc.FoodID]*fdc.Food
+	nutrients map[string][]fdc.NutrientData
+}
+
+func (s source) Food(id fdc.FoodID) (*fdc.Food, error) {
+	return s.foods[id], nil
+}
+
+func (s source) Nutrients(fdcID string) ([]fdc.NutrientData, error) {
+	return s.nutrients[fdcID], nil
+}
+
+func testSource() (source, *fdc.Food) {
+	sandwich := &fdc.Food{FdcID: "1", Description: "Sandwich", Source: "FNDDS", NdbNo: "11111000", InputFoods: []fdc.InputFood{
+		{Description: "Butter", SrCode: 1001, Weight: 10},
+		{Description: "Bread", SrCode: 22222222, Weight: 90},
+		{Description: "Mystery", SrCode: 99999, Weight: 5},
+	}}
+	bread := &fdc.Food{FdcID: "2", Description: "Bread", Source: "FNDDS", NdbNo: "22222222", InputFoods: []fdc.InputFood{
+		{Description: "Flour", SrCode: 20481, Weight: 100},
+		{Description: "Sandwich", SrCode: 11111000, Weight: 100},
+	}}
+	s := source{
+		foods: map[fdc.FoodID]*fdc.Food{
+			{Scheme: fdc.FOODCODE, Value: "11111000"}: sandwich,
+			{Scheme: fdc.FOODCODE, Value: "22222222"}: bread,
+			{Scheme: fdc.NDBNO, Value: "01001"}:       {FdcID: "3", Description: "Butter", Source: "SR"},
+			{Scheme: fdc.NDBNO, Value: "20481"}:       {FdcID: "4", Description: "Flour", Source: "SR"},
+		},
+		nutrients: map[string][]fdc.NutrientData{
+			"1": {{Nutrientno: 204, Nutrient: "Total lipid (fat)", Unit: "g", Value: 9}, {Nutrientno: 208, Nutrient: "Energy", Unit: "kcal", Value: 300}},
+			"3": {{Nutrientno: 204, Nutrient: "Total lipid (fat)", Unit: "g", Value: 81}, {Nutrientno: 208, Nutrient: "Energy", Unit: "kcal", Value: 717}},
+			"4": {{Nutrientno: 204, Nutrient: "Total lipid (fat)", Unit: "g", Value: 1}, {Nutrientno: 208, Nutrient: "Energy", Unit: "kcal", Value: 364}},
+		},
+	}
+	return s, sandwich
+}
+
+func TestExpand(t *testing.T) {
+	s, sandwich := testSource()
+	x, err := Expand(sandwich, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Weight != 105 || len(x.Ingredients) != 3 {
+		t.Fatalf("Expecting 3 input foods weighing 105 g got %d weighing %v", len(x.Ingredients), x.Weight)
+	}
+	bread := x.Ingredients[1]
+	if len(bread.Ingredients) != 2 || !bread.Ingredients[1].Cycle || bread.Ingredients[0].Weight != 45 {
+		t.Errorf("Expecting flour weighing 45 g and a cycle back to the sandwich got %+v", bread.Ingredients)
+	}
+	if !x.Ingredients[2].Unresolved {
+		t.Errorf("Expecting an unresolved input food got %+v", x.Ingredients[2])
+	}
+	// 10 g butter has 8.1 g fat and 45 g flour has 0.45 g
+	if fat := x.Nutrients[0]; fat.Nutrientno != 204 || fat.Value != 8.55 {
+		t.Errorf("Expecting 8.55 g fat got %+v", fat)
+	}
+	if p := x.Ingredients[0].Nutrients[0].Percent; p != 94.737 {
+		t.Errorf("Expecting butter to contribute 94.737%% of fat got %v", p)
+	}
+	if r := x.Reported[1]; r.Nutrientno != 208 || r.Value != 315 {
+		t.Errorf("Expecting 315 kcal reported for 105 g got %+v", r)
+	}
+	if x, _ = Expand(sandwich, s, []int{208}); len(x.Nutrients) != 1 || x.Nutrients[0].Nutrientno != 208 {
+		t.Errorf("Expecting only energy got %+v", x.Nutrients)
+	}
+	if _, err = Expand(&fdc.Food{FdcID: "3"}, s, nil); err == nil {
+		t.Error("Expecting an error for a food without input foods")
+	}
+}
+
+func TestFoodID(t *testing.T) {
+	if id := FoodID(1001); id.Scheme != fdc.NDBNO || id.Value != "01001" {
+		t.Errorf("Unexpected SR id %v", id)
+	}
+	if id := FoodID(11111000); id.Scheme != fdc.FOODCODE || id.Value != "11111000" {
+		t.Errorf("Unexpected FNDDS id %v", id)
+	}
+}
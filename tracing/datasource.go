@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	gocb "gopkg.in/couchbase/gocb.v1"
+)
+
+// DataSource starts a child span of its context for each call to a
+// DataSource
+type DataSource struct {
+	d   ds.DataSource
+	t   *Tracing
+	ctx context.Context
+}
+
+// Instrument returns d wrapped so its calls are spans within ctx, usually
+// the context of a request
+func (t *Tracing) Instrument(ctx context.Context, d ds.DataSource) ds.DataSource {
+	return &DataSource{d: d, t: t, ctx: ctx}
+}
+
+// start begins the span of an operation
+func (d *DataSource) start(op string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := d.t.tracer.Start(d.ctx, "ds."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.operation.name", op))...))
+	return span
+}
+
+// end records the error of an operation and ends its span
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ConnectDs is traced as the ConnectDs operation
+func (d *DataSource) ConnectDs(cs fdc.Config) error {
+	span := d.start("ConnectDs")
+	err := d.d.ConnectDs(cs)
+	end(span, err)
+	return err
+}
+
+// Get is traced as the Get operation with the document id
+func (d *DataSource) Get(q string, f interface{}) error {
+	span := d.start("Get", attribute.String("fdc.document.id", q))
+	err := d.d.Get(q, f)
+	end(span, err)
+	return err
+}
+
+// Query is traced as the Query operation with its sanitized statement
+func (d *DataSource) Query(q string, f *[]interface{}) error {
+	span := d.start("Query", attribute.String("db.query.text", Sanitize(q)))
+	err := d.d.Query(q, f)
+	if f != nil {
+		span.SetAttributes(attribute.Int("db.response.returned_rows", len(*f)))
+	}
+	end(span, err)
+	return err
+}
+
+// Counts is traced as the Counts operation
+func (d *DataSource) Counts(bucket string, doctype string, c *[]interface{}) error {
+	span := d.start("Counts", attribute.String("fdc.doctype", doctype))
+	err := d.d.Counts(bucket, doctype, c)
+	end(span, err)
+	return err
+}
+
+// GetDictionary is traced as the GetDictionary operation
+func (d *DataSource) GetDictionary(dsname string, doctype string, offset int64, limit int64) ([]interface{}, error) {
+	span := d.start("GetDictionary", attribute.String("fdc.doctype", doctype))
+	items, err := d.d.GetDictionary(dsname, doctype, offset, limit)
+	end(span, err)
+	return items, err
+}
+
+// Browse is traced as the Browse operation with its sanitized where clause
+// and sort
+func (d *DataSource) Browse(bucket string, where string, offset int64, limit int64, sort string, order string) ([]interface{}, error) {
+	span := d.start("Browse",
+		attribute.String("db.query.text", Sanitize(where)),
+		attribute.String("fdc.sort", sort),
+		attribute.String("fdc.order", order))
+	items, err := d.d.Browse(bucket, where, offset, limit, sort, order)
+	end(span, err)
+	return items, err
+}
+
+// Search is traced as the Search operation with the sanitized search request
+// and its total hits
+func (d *DataSource) Search(sr fdc.SearchRequest, foods *[]interface{}, facets *[]fdc.Facet) (int, error) {
+	span := d.start("Search",
+		attribute.String("db.query.text", searchStatement(sr)),
+		attribute.String("fdc.search.index", sr.IndexName))
+	n, err := d.d.Search(sr, foods, facets)
+	span.SetAttributes(attribute.Int("fdc.search.hits", n))
+	end(span, err)
+	return n, err
+}
+
+// Suggest is traced as the Suggest operation
+func (d *DataSource) Suggest(sr fdc.SuggestRequest, s *[]fdc.Suggestion) error {
+	span := d.start("Suggest",
+		attribute.String("fdc.suggest.field", sr.Field),
+		attribute.String("fdc.search.index", sr.IndexName))
+	err := d.d.Suggest(sr, s)
+	end(span, err)
+	return err
+}
+
+// NutrientReport is traced as the NutrientReport operation
+func (d *DataSource) NutrientReport(bucket string, nr fdc.NutrientReportRequest, nutrients *[]interface{}) error {
+	span := d.start("NutrientReport", attribute.Int("fdc.nutrient", nr.Nutrient))
+	err := d.d.NutrientReport(bucket, nr, nutrients)
+	end(span, err)
+	return err
+}
+
+// Update is traced as the Update operation with the document id
+func (d *DataSource) Update(id string, r interface{}) error {
+	span := d.start("Update", attribute.String("fdc.document.id", id))
+	err := d.d.Update(id, r)
+	end(span, err)
+	return err
+}
+
+// Remove is traced as the Remove operation with the document id
+func (d *DataSource) Remove(id string) error {
+	span := d.start("Remove", attribute.String("fdc.document.id", id))
+	err := d.d.Remove(id)
+	end(span, err)
+	return err
+}
+
+// Counter is traced as the Counter operation with the document id
+func (d *DataSource) Counter(id string, delta int64) (int64, error) {
+	span := d.start("Counter", attribute.String("fdc.document.id", id))
+	n, err := d.d.Counter(id, delta)
+	end(span, err)
+	return n, err
+}
+
+// FoodExists is traced as the FoodExists operation with the document id
+func (d *DataSource) FoodExists(id string) bool {
+	span := d.start("FoodExists", attribute.String("fdc.document.id", id))
+	ok := d.d.FoodExists(id)
+	span.SetAttributes(attribute.Bool("fdc.exists", ok))
+	end(span, nil)
+	return ok
+}
+
+// Bulk is traced as the Bulk operation with the number of items
+func (d *DataSource) Bulk(n *[]fdc.NutrientData) error {
+	span := d.start("Bulk", attribute.Int("fdc.items", len(*n)))
+	err := d.d.Bulk(n)
+	end(span, err)
+	return err
+}
+
+// BulkInsert is traced as the BulkInsert operation with the number of items
+func (d *DataSource) BulkInsert(v []gocb.BulkOp) error {
+	span := d.start("BulkInsert", attribute.Int("fdc.items", len(v)))
+	err := d.d.BulkInsert(v)
+	end(span, err)
+	return err
+}
+
+// Ping is traced as the Ping operation
+func (d *DataSource) Ping(cs fdc.Config) ([]fdc.Check, error) {
+	span := d.start("Ping")
+	checks, err := d.d.Ping(cs)
+	end(span, err)
+	return checks, err
+}
+
+// CloseDs closes the datastore
+func (d *DataSource) CloseDs() {
+	d.d.CloseDs()
+}
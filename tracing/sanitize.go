@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"encoding/json"
+	"strings"
+
+	fdc "github.com/prLorence/fdc-api/model"
+)
+
+// Sanitize replaces the string and number literals of a N1QL statement with
+// ? so spans show its shape without the ids, barcodes and terms it was built
+// from.  Identifiers, including those quoted in backticks, are kept.
+func Sanitize(statement string) string {
+	var b strings.Builder
+	rs := []rune(statement)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '"' || r == '\'':
+			// a literal ends at an unescaped quote; N1QL escapes quotes by
+			// doubling them or with a backslash
+			for i++; i < len(rs); i++ {
+				if rs[i] == '\\' {
+					i++
+				} else if rs[i] == r {
+					if i+1 < len(rs) && rs[i+1] == r {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteRune('?')
+		case r == '`':
+			b.WriteRune(r)
+			for i++; i < len(rs); i++ {
+				b.WriteRune(rs[i])
+				if rs[i] == '`' {
+					break
+				}
+			}
+		case isDigit(r) && (i == 0 || !isIdent(rs[i-1])):
+			for i+1 < len(rs) && (isDigit(rs[i+1]) || rs[i+1] == '.') {
+				i++
+			}
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isIdent(r rune) bool {
+	return isDigit(r) || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// searchStatement returns a search request as JSON with its query, clause
+// queries, filter values and ingredients replaced with ?.  The type, fields,
+// index, facets, diets and nutrient ranges are kept.
+func searchStatement(sr fdc.SearchRequest) string {
+	sr.Query = redact(sr.Query)
+	sr.Include = redactAll(sr.Include)
+	sr.Exclude = redactAll(sr.Exclude)
+	if len(sr.Filters) > 0 {
+		filters := make(map[string][]string, len(sr.Filters))
+		for name, values := range sr.Filters {
+			filters[name] = redactAll(values)
+		}
+		sr.Filters = filters
+	}
+	if len(sr.Clauses) > 0 {
+		clauses := make([]fdc.SearchClause, len(sr.Clauses))
+		for i, c := range sr.Clauses {
+			c.Query = redact(c.Query)
+			clauses[i] = c
+		}
+		sr.Clauses = clauses
+	}
+	b, err := json.Marshal(sr)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func redact(s string) string {
+	if s == "" {
+		return s
+	}
+	return "?"
+}
+
+func redactAll(values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	r := make([]string, len(values))
+	for i := range values {
+		r[i] = "?"
+	}
+	return r
+}
@@ -0,0 +1,149 @@
+// Package tracing exports OpenTelemetry traces of the web server.  Each
+// request is a server span and every DataSource call made for it a child span
+// carrying its sanitized N1QL statement or search request, whatever the
+// backend.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/logging"
+	fdc "github.com/prLorence/fdc-api/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentation = "github.com/prLorence/fdc-api"
+
+// Tracing starts the spans of a server
+type Tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	shutdown   func(context.Context) error
+}
+
+// New returns tracing which starts spans with tp
+func New(tp trace.TracerProvider) *Tracing {
+	return &Tracing{
+		tracer:     tp.Tracer(instrumentation),
+		propagator: propagation.TraceContext{},
+		shutdown:   func(context.Context) error { return nil },
+	}
+}
+
+// Off returns tracing which records nothing.  Its spans still continue the
+// trace of a caller so the trace id is logged.
+func Off() *Tracing {
+	return New(noop.NewTracerProvider())
+}
+
+// Open returns tracing which exports spans as configured: to an OTLP
+// collector over HTTP, as JSON to stdout or to a file, or nowhere when no
+// exporter is set
+func Open(cfg fdc.Tracing) (*Tracing, error) {
+	var (
+		exp    sdktrace.SpanExporter
+		closer io.Closer
+		err    error
+	)
+	switch cfg.Exporter {
+	case "", "none":
+		return Off(), nil
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exp, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		if cfg.File == "" {
+			return nil, errors.New("no trace file is configured")
+		}
+		f, ferr := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if ferr != nil {
+			return nil, fmt.Errorf("cannot open trace file %s: %v", cfg.File, ferr)
+		}
+		closer = f
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the %s trace exporter: %v", cfg.Exporter, err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", cfg.Service)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Sample))),
+	)
+	t := New(tp)
+	t.shutdown = func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}
+	return t, nil
+}
+
+// Shutdown exports the spans which are still buffered and stops the exporter
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	return t.shutdown(ctx)
+}
+
+// Middleware starts a server span for each request named by its method and
+// route pattern, continuing the trace of a caller which sent a traceparent
+// header.  The request's context carries the span so the datastore calls made
+// for it are its children, and its log entries carry the trace id.
+func (t *Tracing) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := t.propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := t.tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+				attribute.String("fdc.request_id", logging.RequestID(c)),
+			))
+		defer span.End()
+		if sc := span.SpanContext(); sc.IsValid() {
+			logging.Annotate(c, logging.Fields{"trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String()})
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		for _, e := range c.Errors {
+			span.RecordError(e.Err)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prLorence/fdc-api/ds"
+	fdc "github.com/prLorence/fdc-api/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// upcDs finds a food for every query and fails its gets
+type upcDs struct {
+	ds.DataSource
+}
+
+func (upcDs) Query(q string, f *[]interface{}) error {
+	*f = append(*f, map[string]interface{}{"fdcId": "389714"})
+	return nil
+}
+
+func (upcDs) Get(q string, f interface{}) error {
+	return errors.New("key not found")
+}
+
+func attr(s sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestSanitize(t *testing.T) {
+	for in, want := range map[string]string{
+		`SELECT fdcId from gnutdata where type="FOOD" AND (gtin = "00042222850325" OR upc IN ["042222850325","0042222850325"])`: `SELECT fdcId from gnutdata where type=? AND (gtin = ? OR upc IN [?,?])`,
+		"SELECT * FROM `fdc-2` WHERE name = 'O''Brien' AND n > 12.5 LIMIT 10":                                                   "SELECT * FROM `fdc-2` WHERE name = ? AND n > ? LIMIT ?",
+		`type="FOOD" AND idx_fd2 = "a \"b\""`: `type=? AND idx_fd2 = ?`,
+	} {
+		if got := Sanitize(in); got != want {
+			t.Errorf("Sanitize(%s)\ngot  %s\nwant %s", in, got, want)
+		}
+	}
+	s := searchStatement(fdc.SearchRequest{Query: "cheddar", SearchType: "PHRASE", Include: []string{"milk"}, Filters: map[string][]string{"company": {"KRAFT"}}, Diets: []string{"vegan"}})
+	for _, secret := range []string{"cheddar", "milk", "KRAFT"} {
+		if strings.Contains(s, secret) {
+			t.Errorf("%s leaks %s", s, secret)
+		}
+	}
+	if !strings.Contains(s, `"searchtype":"PHRASE"`) || !strings.Contains(s, `"vegan"`) {
+		t.Errorf("%s lost the shape of the search", s)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sr := tracetest.NewSpanRecorder()
+	tr := New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	r := gin.New()
+	r.Use(tr.Middleware())
+	r.GET("/nutrients/foods", func(c *gin.Context) {
+		d := tr.Instrument(c.Request.Context(), upcDs{})
+		for _, upc := range []string{"042222850325", "041570054161"} {
+			var r []interface{}
+			d.Query(`SELECT fdcId from gnutdata where gtin = "`+upc+`"`, &r)
+		}
+		var f interface{}
+		d.Get("FOOD:1", &f)
+		c.Status(http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest("GET", "/nutrients/foods", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	spans := sr.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	server := spans[3]
+	if server.Name() != "GET /nutrients/foods" || server.SpanKind() != trace.SpanKindServer || server.Status().Code != codes.Error {
+		t.Errorf("got server span %s %v %v", server.Name(), server.SpanKind(), server.Status())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error("the caller's trace was not continued")
+	}
+	if attr(server, "http.response.status_code").AsInt64() != 500 {
+		t.Errorf("got status %v", attr(server, "http.response.status_code"))
+	}
+	for _, s := range spans[:2] {
+		if s.Name() != "ds.Query" || s.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the request", s.Name())
+		}
+		if q := attr(s, "db.query.text").AsString(); q != "SELECT fdcId from gnutdata where gtin = ?" {
+			t.Errorf("got statement %s", q)
+		}
+	}
+	if get := spans[2]; get.Name() != "ds.Get" || get.Status().Code != codes.Error || attr(get, "fdc.document.id").AsString() != "FOOD:1" {
+		t.Errorf("got %s %v", get.Name(), get.Status())
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open(fdc.Tracing{Exporter: "zipkin"}); err == nil {
+		t.Error("opened an unknown exporter")
+	}
+	if _, err := Open(fdc.Tracing{Exporter: "file"}); err == nil {
+		t.Error("opened a file exporter without a file")
+	}
+	file := filepath.Join(t.TempDir(), "spans.json")
+	tr, err := Open(fdc.Tracing{Exporter: "file", File: file, Service: "fdc-api", Sample: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f interface{}
+	tr.Instrument(context.Background(), upcDs{}).Get("FOOD:1", &f)
+	if err = tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Name":"ds.Get"`) || !strings.Contains(string(b), `"fdc-api"`) {
+		t.Errorf("the span was not exported: %s", b)
+	}
+}